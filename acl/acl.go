@@ -0,0 +1,275 @@
+package acl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"kv_storage/executer"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultUserName is the user of the clients that did not authenticate
+const DefaultUserName = "default"
+
+// logEntryTimeout is how long a denial is counted in the previous log entry
+// of the same kind instead of a new one
+const logEntryTimeout = 60 * time.Second
+
+// ACL holds the users and the log of the denied commands and
+// authentications
+type ACL struct {
+	mu       sync.RWMutex
+	users    map[string]*User
+	fileName string
+
+	logMu       sync.Mutex
+	log         []*LogEntry // newest first
+	maxLogLen   int
+	nextEntryID int64
+}
+
+// New returns the users of aclfile, or a default user with requirepass as
+// password when there is no file. The default user may do anything, so no
+// ACL is returned when the file can not be loaded.
+func New(requirePass, aclFile string, maxLogLen int) (*ACL, error) {
+	a := &ACL{fileName: aclFile, maxLogLen: maxLogLen}
+	if aclFile != "" {
+		if err := a.Load(); err != nil {
+			return nil, err
+		}
+		return a, nil
+	}
+	a.users = map[string]*User{DefaultUserName: newDefaultUser(requirePass)}
+	return a, nil
+}
+
+func newDefaultUser(requirePass string) *User {
+	u := newUser(DefaultUserName)
+	rules := []string{"on", "nopass", "~*", "&*", "+@all"}
+	if requirePass != "" {
+		rules[1] = ">" + requirePass
+	}
+	for _, rule := range rules {
+		u.applyRule(rule)
+	}
+	return u
+}
+
+// User returns the user named name, nil if it doesn't exist
+func (a *ACL) User(name string) *User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users[name]
+}
+
+// Authenticate reports whether the user exists, is enabled and has password
+func (a *ACL) Authenticate(username, password []byte) bool {
+	u := a.User(string(username))
+	return u != nil && u.enabled && u.checkPassword(password)
+}
+
+// SetUser creates or modifies a user, the user is unchanged when a rule is
+// invalid
+func (a *ACL) SetUser(name string, rules []string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	u, ok := a.users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", executer.ErrorArg([]byte(rule)), err.Error())
+		}
+	}
+	a.users[name] = u
+	return nil
+}
+
+var errDeleteDefault = errors.New("The 'default' user cannot be removed")
+
+// DelUser deletes users and returns the number of users deleted
+func (a *ACL) DelUser(names []string) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, name := range names {
+		if name == DefaultUserName {
+			return 0, errDeleteDefault
+		}
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// Users returns the users sorted by name
+func (a *ACL) Users() []*User {
+	a.mu.RLock()
+	users := make([]*User, 0, len(a.users))
+	for _, u := range a.users {
+		users = append(users, u)
+	}
+	a.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].Name < users[j].Name
+	})
+	return users
+}
+
+var errNoFile = errors.New("This instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then set aclfile in the config file to persist them")
+
+// Load replaces the users with the ones of the acl file, the users are kept
+// when the file is invalid. A file without the default user gets a default
+// user taking any password.
+func (a *ACL) Load() error {
+	if a.fileName == "" {
+		return errNoFile
+	}
+	file, err := os.Open(a.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: should start with user keyword", a.fileName, n)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", a.fileName, n, name)
+		}
+		u := newUser(name)
+		for _, rule := range fields[2:] {
+			if err := u.applyRule(rule); err != nil {
+				return fmt.Errorf("%s:%d: Error in ACL rule '%s': %s", a.fileName, n, rule, err.Error())
+			}
+		}
+		users[name] = u
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := users[DefaultUserName]; !ok {
+		users[DefaultUserName] = newDefaultUser("")
+	}
+	a.mu.Lock()
+	a.users = users
+	a.mu.Unlock()
+	return nil
+}
+
+// Save writes the users to the acl file, the file is replaced atomically
+func (a *ACL) Save() error {
+	if a.fileName == "" {
+		return errNoFile
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.fileName), "temp-acl-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	writer := bufio.NewWriter(tmp)
+	for _, u := range a.Users() {
+		writer.WriteString(u.String() + "\n")
+	}
+	if err = writer.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, a.fileName)
+}
+
+// LogEntry is an entry of ACL LOG, denials of the same kind within a minute
+// are counted in one entry
+type LogEntry struct {
+	Count      int64
+	Reason     string // command, key or auth
+	Context    string
+	Object     string
+	Username   string
+	ClientInfo string
+	EntryID    int64
+	Created    time.Time
+	Updated    time.Time
+}
+
+// AddLog records a denied command or authentication of a client
+func (a *ACL) AddLog(reason, object, username, clientInfo string) {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	now := time.Now()
+	for i, entry := range a.log {
+		if entry.Reason == reason && entry.Object == object && entry.Username == username &&
+			now.Sub(entry.Updated) < logEntryTimeout {
+			entry.Count++
+			entry.ClientInfo = clientInfo
+			entry.Updated = now
+			// move it to the front
+			copy(a.log[1:i+1], a.log[:i])
+			a.log[0] = entry
+			return
+		}
+	}
+	entry := &LogEntry{
+		Count:      1,
+		Reason:     reason,
+		Context:    "toplevel",
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		EntryID:    a.nextEntryID,
+		Created:    now,
+		Updated:    now,
+	}
+	a.nextEntryID++
+	a.log = append([]*LogEntry{entry}, a.log...)
+	if len(a.log) > a.maxLogLen {
+		a.log = a.log[:a.maxLogLen]
+	}
+}
+
+// Log returns copies of the count newest log entries, all of them when
+// count is negative
+func (a *ACL) Log(count int) []LogEntry {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	if count < 0 || count > len(a.log) {
+		count = len(a.log)
+	}
+	entries := make([]LogEntry, count)
+	for i := range entries {
+		entries[i] = *a.log[i]
+	}
+	return entries
+}
+
+// ResetLog clears the log
+func (a *ACL) ResetLog() {
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	a.log = nil
+}
@@ -0,0 +1,295 @@
+package acl
+
+import (
+	"kv_storage/executer"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+)
+
+func init() {
+	// a command like client, one of its subcommands is an admin one
+	cmd := executer.RegisterCommand("acltest", -2, 0, 0, 0, 0, nil)
+	cmd.RegisterSubcommand("kill", -3, executer.FlagAdmin)
+	cmd.RegisterSubcommand("info", 2, 0)
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		want    bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "order:1", false},
+		{"**x", "abx", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`[\]]`, "]", true},
+		{"[abc", "a", true},
+		{"key", "key", true},
+		{"key", "keys", false},
+	}
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCanRun(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		cmd   string
+		// want is the reason and the object of the denial, empty when the
+		// command may run
+		want string
+	}{
+		{"all", "+@all ~*", "set k v", ""},
+		{"nothing", "-@all ~*", "get k", "command get"},
+		{"new user", "", "get k", "command get"},
+		{"command", "+get ~*", "get k", ""},
+		{"other command", "+get ~*", "set k v", "command set"},
+		{"removed command", "+@all -set ~*", "set k v", "command set"},
+		{"category", "+@read ~*", "get k", ""},
+		{"other category", "+@read ~*", "set k v", "command set"},
+		{"removed category", "+@all -@write ~*", "set k v", "command set"},
+		{"no key", "+@all", "get k", "key k"},
+		{"key pattern", "+@all ~user:*", "get user:1", ""},
+		{"other key", "+@all ~user:*", "get order:1", "key order:1"},
+		{"read key", "+@all %R~*", "get k", ""},
+		{"read key written", "+@all %R~*", "set k v", "key k"},
+		{"write key", "+@all %W~*", "set k v", ""},
+		{"write key read", "+@all %W~*", "get k", "key k"},
+		{"reset keys", "+@all ~* resetkeys", "get k", "key k"},
+		{"without keys", "+keys", "keys *", ""},
+		{"subcommand", "-@all +acltest|info", "acltest info", ""},
+		{"other subcommand", "-@all +acltest|info", "acltest kill a b", "command acltest"},
+		{"subcommand case", "-@all +acltest|info", "acltest INFO", ""},
+		{"removed subcommand", "+@all -acltest|kill", "acltest kill a b", "command acltest|kill"},
+		{"command after subcommand", "+@all -acltest|kill +acltest", "acltest kill a b", ""},
+		{"dangerous subcommand", "+@all -@dangerous", "acltest kill a b", "command acltest|kill"},
+		{"safe subcommand", "+@all -@dangerous", "acltest info", ""},
+		{"admin subcommand", "-@all +@admin", "acltest kill a b", ""},
+		{"admin command", "-@all +@admin", "acltest info", "command acltest"},
+		{"reset", "+@all ~* reset", "get k", "command get"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUser("test")
+			for _, rule := range strings.Fields(tt.rules) {
+				if err := u.applyRule(rule); err != nil {
+					t.Fatalf("%s: %v", rule, err)
+				}
+			}
+			cmdArgs := utils.ToCmdLine(strings.Fields(tt.cmd)...)
+			cmd, ok := executer.LookupCommand(cmdArgs[0])
+			if !ok {
+				t.Fatalf("no command %s", cmdArgs[0])
+			}
+			got := ""
+			if denial := u.CanRun(cmd, cmdArgs); denial != nil {
+				got = denial.Reason + " " + denial.Object
+			}
+			if got != tt.want {
+				t.Fatalf("denial %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRuleErrors(t *testing.T) {
+	tests := []struct {
+		rules string
+		want  error
+	}{
+		{"+nosuchcommand", errUnknownCommand},
+		{"+@nosuchcategory", errUnknownCommand},
+		{"+get|", errUnknownCommand},
+		{"#abc", errBadHash},
+		{"!" + strings.Repeat("A", 64), errBadHash},
+		{"<nosuchpassword", errNoSuchPassword},
+		{"~* ~user:*", errAfterAllKeys},
+		{"&* &news", errAfterAllChannels},
+		{"%X~*", errSyntax},
+		{"%~*", errSyntax},
+		{"%R", errSyntax},
+		{"bogus", errSyntax},
+	}
+	for _, tt := range tests {
+		u := newUser("test")
+		var err error
+		for _, rule := range strings.Fields(tt.rules) {
+			if err = u.applyRule(rule); err != nil {
+				break
+			}
+		}
+		if err != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.rules, err, tt.want)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a, err := New("", "", 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rules := range [][]string{
+		{"on", ">secret", ">other", "<other"},
+		{"off", ">secret"},
+		{"on", "nopass"},
+		{"on", "#" + hashPassword([]byte("secret"))},
+	} {
+		if err := a.SetUser(rules[0]+rules[1], rules); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		user, password string
+		want           bool
+	}{
+		{"default", "anything", true},
+		{"on>secret", "secret", true},
+		{"on>secret", "other", false},
+		{"off>secret", "secret", false},
+		{"onnopass", "anything", true},
+		{"on#" + hashPassword([]byte("secret")), "secret", true},
+		{"nosuchuser", "secret", false},
+	}
+	for _, tt := range tests {
+		if got := a.Authenticate([]byte(tt.user), []byte(tt.password)); got != tt.want {
+			t.Errorf("Authenticate(%s, %s) = %v, want %v", tt.user, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		wantErr bool
+		// want are the users described like ACL LIST
+		want []string
+	}{
+		{"empty", "", false, []string{"user default on nopass ~* &* +@all"}},
+		{"comments", "# users\n\nuser alice on >pw ~a:* +get\n", false, []string{
+			"user alice on #" + hashPassword([]byte("pw")) + " ~a:* resetchannels -@all +get",
+			"user default on nopass ~* &* +@all",
+		}},
+		{"default user", "user default on nopass ~* &* +@all -@dangerous\n", false, []string{
+			"user default on nopass ~* &* +@all -@dangerous",
+		}},
+		{"not a user", "alice on\n", true, nil},
+		{"duplicate", "user alice on\nuser alice off\n", true, nil},
+		{"invalid rule", "user alice on +nosuchcommand\n", true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "users.acl")
+			if err := os.WriteFile(fileName, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			a, err := New("", fileName, 128)
+			if tt.wantErr {
+				if err == nil || a != nil {
+					t.Fatalf("New = %v, %v, want no acl and an error", a, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := describe(a); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("loaded %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if a, err := New("", filepath.Join(t.TempDir(), "none.acl"), 128); err == nil || a != nil {
+		t.Fatalf("New = %v, %v with a missing file, want an error", a, err)
+	}
+}
+
+// describe returns the users of a like ACL LIST
+func describe(a *ACL) []string {
+	var users []string
+	for _, u := range a.Users() {
+		users = append(users, u.String())
+	}
+	return users
+}
+
+func TestSave(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "users.acl")
+	if err := os.WriteFile(fileName, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New("", fileName, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, rules := range map[string]string{
+		"alice": "on >pw %R~a:* &news +@read -keys",
+		"bob":   "off nopass ~* +@all -@dangerous +acltest|info",
+	} {
+		if err := a.SetUser(name, strings.Fields(rules)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := New("", fileName, 128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := describe(loaded), describe(a); !reflect.DeepEqual(got, want) {
+		t.Fatalf("loaded %q, want %q", got, want)
+	}
+}
+
+func TestLog(t *testing.T) {
+	a, err := New("", "", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.AddLog("command", "get", "alice", "id=1")
+	a.AddLog("key", "k", "alice", "id=1")
+	a.AddLog("command", "get", "alice", "id=2")
+	entries := a.Log(-1)
+	if len(entries) != 2 {
+		t.Fatalf("%d entries, want 2", len(entries))
+	}
+	if entries[0].Object != "get" || entries[0].Count != 2 || entries[0].ClientInfo != "id=2" {
+		t.Fatalf("newest entry %+v, want get counted twice", entries[0])
+	}
+	a.AddLog("command", "set", "alice", "id=1")
+	if entries = a.Log(-1); len(entries) != 2 || entries[0].Object != "set" || entries[1].Object != "get" {
+		t.Fatalf("entries %+v, want set and get", entries)
+	}
+	if entries = a.Log(1); len(entries) != 1 {
+		t.Fatalf("%d entries, want 1", len(entries))
+	}
+	a.ResetLog()
+	if entries = a.Log(-1); len(entries) != 0 {
+		t.Fatalf("%d entries after reset", len(entries))
+	}
+}
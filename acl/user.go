@@ -0,0 +1,432 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"kv_storage/executer"
+	"strings"
+)
+
+// key permissions of a key pattern
+const (
+	permRead = 1 << iota
+	permWrite
+	permAll = permRead | permWrite
+)
+
+// keyPattern gives access to the keys matching pattern
+type keyPattern struct {
+	pattern string
+	perm    int
+}
+
+func (p keyPattern) String() string {
+	switch p.perm {
+	case permRead:
+		return "%R~" + p.pattern
+	case permWrite:
+		return "%W~" + p.pattern
+	}
+	return "~" + p.pattern
+}
+
+// User is an acl user, a stored user is never changed: SetUser replaces it
+// with a modified copy so that the clients read it without locking
+type User struct {
+	Name      string
+	enabled   bool
+	nopass    bool
+	passwords []string // sha256 of the passwords in hex
+	keys      []keyPattern
+	channels  []string
+	// commands tells whether a command, or a subcommand written as
+	// command|subcommand, may run; a subcommand entry wins over the one of
+	// its command
+	commands map[string]bool
+	// commandRules describe commands as the rules that built it
+	commandRules []string
+}
+
+// newUser returns a user that is disabled and may do nothing, like a user
+// created by ACL SETUSER
+func newUser(name string) *User {
+	return &User{Name: name, commands: make(map[string]bool), commandRules: []string{"-@all"}}
+}
+
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = append([]string(nil), u.passwords...)
+	c.keys = append([]keyPattern(nil), u.keys...)
+	c.channels = append([]string(nil), u.channels...)
+	c.commandRules = append([]string(nil), u.commandRules...)
+	c.commands = make(map[string]bool, len(u.commands))
+	for name, allowed := range u.commands {
+		c.commands[name] = allowed
+	}
+	return &c
+}
+
+// Enabled reports whether the user may authenticate
+func (u *User) Enabled() bool {
+	return u.enabled
+}
+
+// NoPass reports whether the user takes any password
+func (u *User) NoPass() bool {
+	return u.nopass
+}
+
+// checkPassword reports whether password is one of the passwords of u
+func (u *User) checkPassword(password []byte) bool {
+	if u.nopass {
+		return true
+	}
+	hash := hashPassword(password)
+	found := false
+	for _, h := range u.passwords {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+func hashPassword(password []byte) string {
+	sum := sha256.Sum256(password)
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	errSyntax           = errors.New("Syntax error")
+	errUnknownCommand   = errors.New("Unknown command or category name in ACL")
+	errBadHash          = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errNoSuchPassword   = errors.New("The password you are trying to remove from the user does not exist")
+	errAfterAllKeys     = errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+	errAfterAllChannels = errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. Try 'resetchannels' to start with an empty list of channels")
+)
+
+// applyRule changes u according to one rule of ACL SETUSER
+func (u *User) applyRule(rule string) error {
+	lower := strings.ToLower(rule)
+	switch lower {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass = true
+		u.passwords = nil
+		return nil
+	case "resetpass":
+		u.nopass = false
+		u.passwords = nil
+		return nil
+	case "allkeys":
+		return u.applyRule("~*")
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allchannels":
+		return u.applyRule("&*")
+	case "resetchannels":
+		u.channels = nil
+		return nil
+	case "allcommands":
+		return u.applyRule("+@all")
+	case "nocommands":
+		return u.applyRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			u.applyRule(r)
+		}
+		return nil
+	}
+	if rule == "" {
+		return errSyntax
+	}
+	switch rule[0] {
+	case '>':
+		u.addPassword(hashPassword([]byte(rule[1:])))
+		return nil
+	case '#':
+		if !isHash(rule[1:]) {
+			return errBadHash
+		}
+		u.addPassword(rule[1:])
+		return nil
+	case '<':
+		return u.removePassword(hashPassword([]byte(rule[1:])))
+	case '!':
+		if !isHash(rule[1:]) {
+			return errBadHash
+		}
+		return u.removePassword(rule[1:])
+	case '~':
+		return u.addKeyPattern(rule[1:], permAll)
+	case '%':
+		i := strings.IndexByte(rule, '~')
+		if i < 0 {
+			return errSyntax
+		}
+		perm := 0
+		for _, c := range strings.ToUpper(rule[1:i]) {
+			switch c {
+			case 'R':
+				perm |= permRead
+			case 'W':
+				perm |= permWrite
+			default:
+				return errSyntax
+			}
+		}
+		if perm == 0 {
+			return errSyntax
+		}
+		return u.addKeyPattern(rule[i+1:], perm)
+	case '&':
+		for _, channel := range u.channels {
+			if channel == "*" {
+				return errAfterAllChannels
+			}
+		}
+		if rule[1:] == "*" {
+			u.channels = nil
+		}
+		u.channels = append(u.channels, rule[1:])
+		return nil
+	case '+', '-':
+		return u.applyCommandRule(lower)
+	}
+	return errSyntax
+}
+
+func isHash(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9') && !(c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *User) addPassword(hash string) {
+	u.nopass = false
+	for _, h := range u.passwords {
+		if h == hash {
+			return
+		}
+	}
+	u.passwords = append(u.passwords, hash)
+}
+
+func (u *User) removePassword(hash string) error {
+	for i, h := range u.passwords {
+		if h == hash {
+			u.passwords = append(u.passwords[:i], u.passwords[i+1:]...)
+			return nil
+		}
+	}
+	return errNoSuchPassword
+}
+
+func (u *User) addKeyPattern(pattern string, perm int) error {
+	for _, p := range u.keys {
+		if p.pattern == "*" && p.perm == permAll {
+			return errAfterAllKeys
+		}
+	}
+	if pattern == "*" && perm == permAll {
+		u.keys = nil
+	}
+	u.keys = append(u.keys, keyPattern{pattern: pattern, perm: perm})
+	return nil
+}
+
+// applyCommandRule applies +command, -command, +command|subcommand,
+// -command|subcommand, +@category or -@category
+func (u *User) applyCommandRule(rule string) error {
+	allow := rule[0] == '+'
+	name := rule[1:]
+	if strings.HasPrefix(name, "@") {
+		category := name[1:]
+		if category == "all" {
+			u.commands = make(map[string]bool)
+			u.commandRules = nil
+			if allow {
+				for _, cmd := range executer.Commands() {
+					u.commands[cmd.Name] = true
+				}
+			}
+		} else {
+			if !isCategory(category) {
+				return errUnknownCommand
+			}
+			u.setCategory(category, allow)
+		}
+		u.commandRules = append(u.commandRules, rule)
+		return nil
+	}
+	command, sub, isSub := strings.Cut(name, "|")
+	if _, ok := executer.LookupCommand([]byte(command)); !ok || (isSub && sub == "") {
+		return errUnknownCommand
+	}
+	if isSub {
+		u.commands[name] = allow
+	} else {
+		u.setCommand(name, allow)
+	}
+	u.commandRules = append(u.commandRules, rule)
+	return nil
+}
+
+// setCommand allows or denies a command with all its subcommands
+func (u *User) setCommand(name string, allow bool) {
+	u.commands[name] = allow
+	for key := range u.commands {
+		if strings.HasPrefix(key, name+"|") {
+			delete(u.commands, key)
+		}
+	}
+}
+
+// setCategory allows or denies the commands of a category, a subcommand is
+// set on its own when it is in a category its command is not in
+func (u *User) setCategory(category string, allow bool) {
+	for _, cmd := range executer.Commands() {
+		if hasCategory(cmd, category) {
+			u.setCommand(cmd.Name, allow)
+			continue
+		}
+		for _, sub := range cmd.Subcommands() {
+			if hasCategory(sub, category) {
+				u.commands[sub.Name] = allow
+			}
+		}
+	}
+}
+
+func isCategory(category string) bool {
+	for _, name := range executer.CategoryNames() {
+		if name == category {
+			return true
+		}
+	}
+	return false
+}
+
+func hasCategory(cmd *executer.Command, category string) bool {
+	for _, c := range cmd.Categories() {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// Denial tells why a user may not run a command, Reason is command or key
+// and Object the command or the key, like the entries of ACL LOG
+type Denial struct {
+	Reason string
+	Object string
+}
+
+// CanRun returns nil when u may run cmd with args, keys are checked for
+// read access by the read commands and for write access by the others
+func (u *User) CanRun(cmd *executer.Command, args [][]byte) *Denial {
+	name := cmd.Name
+	allowed := u.commands[name]
+	if len(args) > 1 {
+		sub := name + "|" + strings.ToLower(string(args[1]))
+		if a, ok := u.commands[sub]; ok {
+			name, allowed = sub, a
+		}
+	}
+	if !allowed {
+		return &Denial{Reason: "command", Object: name}
+	}
+	perm := permWrite
+	if cmd.HasFlag(executer.FlagReadonly) {
+		perm = permRead
+	}
+	for _, key := range cmd.Keys(args) {
+		if !u.canAccessKey(string(key), perm) {
+			return &Denial{Reason: "key", Object: string(key)}
+		}
+	}
+	return nil
+}
+
+func (u *User) canAccessKey(key string, perm int) bool {
+	for _, p := range u.keys {
+		if p.perm&perm == perm && match(p.pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// String describes u as a line of ACL LIST and of the acl file
+func (u *User) String() string {
+	fields := []string{"user", u.Name}
+	fields = append(fields, u.flags()...)
+	for _, h := range u.passwords {
+		fields = append(fields, "#"+h)
+	}
+	if keys := u.keysRule(); keys != "" {
+		fields = append(fields, keys)
+	}
+	fields = append(fields, u.channelsRule())
+	fields = append(fields, u.commandsRule())
+	return strings.Join(fields, " ")
+}
+
+func (u *User) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *User) keysRule() string {
+	rules := make([]string, len(u.keys))
+	for i, p := range u.keys {
+		rules[i] = p.String()
+	}
+	return strings.Join(rules, " ")
+}
+
+func (u *User) channelsRule() string {
+	if len(u.channels) == 0 {
+		return "resetchannels"
+	}
+	rules := make([]string, len(u.channels))
+	for i, channel := range u.channels {
+		rules[i] = "&" + channel
+	}
+	return strings.Join(rules, " ")
+}
+
+func (u *User) commandsRule() string {
+	if len(u.commandRules) == 0 || u.commandRules[0] != "+@all" && u.commandRules[0] != "-@all" {
+		return strings.Join(append([]string{"-@all"}, u.commandRules...), " ")
+	}
+	return strings.Join(u.commandRules, " ")
+}
+
+// Info returns the fields of ACL GETUSER: the flags, the password hashes,
+// and the commands, keys and channels rules
+func (u *User) Info() (flags, passwords []string, commands, keys, channels string) {
+	channels = strings.TrimPrefix(u.channelsRule(), "resetchannels")
+	return u.flags(), append([]string(nil), u.passwords...), u.commandsRule(), u.keysRule(), channels
+}
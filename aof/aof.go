@@ -1,86 +1,361 @@
 package aof
 
 import (
-	"fmt"
+	"bufio"
+	"errors"
+	"io"
+	"kv_storage/config"
 	"kv_storage/datastore"
 	"kv_storage/entity"
 	"kv_storage/executer"
-	"kv_storage/parser"
+	"kv_storage/latency"
+	"kv_storage/logger"
+	"kv_storage/snapshot"
 	"os"
-	"strconv"
+	"path/filepath"
+	"sync"
 	"time"
-
-	"github.com/hdt3213/godis/lib/logger"
 )
 
+var ErrRewriteInProgress = errors.New("Background append only file rewriting already in progress")
+
+var errAofClosed = errors.New("aof is shut down")
+
+// record is an entry of the persist queue, either a serialized command or
+// a fence which is run by the persist goroutine in queue order
+type record struct {
+	cmd   []byte
+	ms    int64 // execution time of cmd in unix milliseconds
+	fence func()
+}
+
 type AofInstance struct {
-	file  *os.File
-	cmdCh chan parser.Payload
+	fileName string
+	file     *os.File
+	// hybrid aofs are made of a snapshot base and incr parts listed by a
+	// manifest, otherwise the whole aof is the single file fileName
+	hybrid   bool
+	manifest *manifest
+	cmdCh    chan *record
+	// persisted is closed once Persist wrote every queued command
+	persisted chan struct{}
+	// closed is set by Shutdown, guarded by the lock of the executer
+	closed bool
+	db     *datastore.Map
+	exec   *executer.Executer
+	// seq numbers the commands written to the aof, it continues from the
+	// last number found when the aof is loaded, lastMs is the execution
+	// time of the command numbered seq
+	seq    int64
+	lastMs int64
+
+	// mu guards the file and the rewrite state below
+	mu            sync.Mutex
+	rewriting     bool
+	buffering     bool
+	rewriteBuffer []byte
+	baseSize      int64
+	currentSize   int64
+	// rewriteStarted is when the rewrite in progress, or the last one,
+	// started, the last rewrite and the last write ended with the errors
+	rewriteStarted      time.Time
+	lastRewriteDuration time.Duration
+	lastRewriteErr      error
+	lastWriteErr        error
+
+	autoRewritePercentage int64
+	autoRewriteMinSize    int64
 }
 
-func NewAofInstance(fileName string) *AofInstance {
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
-	if err != nil {
-		panic(err.Error())
+// NewAofInstance opens the aof file, an empty file name disables the aof and
+// the instance only executes commands
+func NewAofInstance(config *config.Config) *AofInstance {
+	if config.AofFile == "" {
+		return &AofInstance{}
+	}
+	a := &AofInstance{
+		fileName:              config.AofFile,
+		hybrid:                config.AofUseSnapshotPreamble,
+		cmdCh:                 make(chan *record, 16),
+		persisted:             make(chan struct{}),
+		autoRewritePercentage: int64(config.AutoAofRewritePercentage),
+		autoRewriteMinSize:    int64(config.AutoAofRewriteMinSize),
+	}
+	if _, err := os.Stat(a.manifestName()); err == nil {
+		// once a manifest was written the aof stays hybrid
+		a.hybrid = true
+	}
+	if !a.hybrid {
+		file, err := os.OpenFile(config.AofFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+		if err != nil {
+			panic(err.Error())
+		}
+		a.file = file
+	}
+	return a
+}
+
+func (a *AofInstance) Enabled() bool {
+	return a.fileName != ""
+}
+
+// Execute runs a command and queues it for persistence if it modified the dataset
+func (a *AofInstance) Execute(args [][]byte) entity.Reply {
+	a.exec.RLock()
+	defer a.exec.RUnlock()
+	now := time.Now()
+	reply := a.exec.Execute(args)
+	if a.Enabled() && !a.closed && executer.IsWriteCommand(args) && !entity.IsErrorReply(reply) {
+		if cmd := absoluteExpiry(args, now); cmd != nil {
+			a.cmdCh <- &record{cmd: entity.MakeMultiBulkReply(cmd).ToBytes(), ms: now.UnixMilli()}
+		}
 	}
-	return &AofInstance{file: file, cmdCh: make(chan parser.Payload, 16)}
+	return reply
 }
 
-func (a *AofInstance) ToCmdCh(payload *parser.Payload) {
-	// fmt.Println("cmd to buffer")
-	a.cmdCh <- *payload
+// Status describes the aof for INFO persistence
+type Status struct {
+	Enabled             bool
+	Rewriting           bool
+	RewriteStarted      time.Time
+	LastRewriteDuration time.Duration
+	LastRewriteErr      error
+	LastWriteErr        error
+	CurrentSize         int64
+	BaseSize            int64
+}
+
+func (a *AofInstance) Status() Status {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return Status{
+		Enabled:             a.Enabled(),
+		Rewriting:           a.rewriting,
+		RewriteStarted:      a.rewriteStarted,
+		LastRewriteDuration: a.lastRewriteDuration,
+		LastRewriteErr:      a.lastRewriteErr,
+		LastWriteErr:        a.lastWriteErr,
+		CurrentSize:         a.currentSize,
+		BaseSize:            a.baseSize,
+	}
 }
 
 func (a *AofInstance) Persist() {
-	fmt.Println("start aof instance to persist cmd")
-	for payload := range a.cmdCh {
-		cmdBytes := payload.Data.ToBytes()
-		a.file.Write(cmdBytes)
+	if !a.Enabled() {
+		return
+	}
+	logger.Info("aof persistence started", "file", a.fileName)
+	defer close(a.persisted)
+	for r := range a.cmdCh {
+		a.mu.Lock()
+		if r.fence != nil {
+			r.fence()
+			a.mu.Unlock()
+			continue
+		}
+		a.seq++
+		a.lastMs = r.ms
+		data := append(makeAnnotation(a.seq, r.ms), r.cmd...)
+		start := time.Now()
+		n, err := a.file.Write(data)
+		latency.AddSample(latency.EventAofWrite, time.Since(start))
+		if err != nil {
+			logger.Error("aof write error", "err", err)
+		}
+		a.lastWriteErr = err
+		a.currentSize += int64(n)
+		if a.buffering {
+			a.rewriteBuffer = append(a.rewriteBuffer, data...)
+		}
+		needRewrite := a.needAutoRewrite()
+		a.mu.Unlock()
+		if needRewrite {
+			a.BackgroundRewrite()
+		}
+	}
+}
+
+// needAutoRewrite reports whether the file grew past the configured
+// auto-aof-rewrite thresholds, the caller must hold mu
+func (a *AofInstance) needAutoRewrite() bool {
+	if a.rewriting || a.autoRewritePercentage <= 0 || a.currentSize < a.autoRewriteMinSize {
+		return false
+	}
+	base := a.baseSize
+	if base == 0 {
+		base = 1
 	}
+	growth := (a.currentSize - base) * 100 / base
+	return growth >= a.autoRewritePercentage
+}
+
+// Sync waits until the commands queued so far are written and flushes the
+// file to disk
+func (a *AofInstance) Sync() error {
+	if !a.Enabled() {
+		return nil
+	}
+	synced := make(chan error, 1)
+	a.exec.RLock()
+	if a.closed {
+		a.exec.RUnlock()
+		return nil
+	}
+	a.cmdCh <- &record{fence: func() {
+		synced <- a.fsync()
+	}}
+	a.exec.RUnlock()
+	return <-synced
+}
+
+// Shutdown writes the queued commands, flushes the file to disk and stops
+// Persist, the commands executed afterwards are not appended any more
+func (a *AofInstance) Shutdown() error {
+	if !a.Enabled() {
+		return nil
+	}
+	a.exec.Lock()
+	if a.closed {
+		a.exec.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.cmdCh)
+	a.exec.Unlock()
+	<-a.persisted
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.fsync()
+}
+
+// fsync flushes the file to disk
+func (a *AofInstance) fsync() error {
+	start := time.Now()
+	err := a.file.Sync()
+	latency.AddSample(latency.EventAofFsync, time.Since(start))
+	return err
 }
 
 func (a *AofInstance) Close() {
-	a.file.Close()
+	if a.Enabled() {
+		a.file.Close()
+	}
 }
 
 func (a *AofInstance) Init(db *datastore.Map, execInstance *executer.Executer) error {
-	fmt.Println("building data initial state according to " + a.file.Name())
-	ch := make(chan *parser.Payload)
-	go parser.Parse0(a.file, ch)
-	timer := time.NewTimer(time.Second)
-	flag := true
-	for flag {
-		select {
-		case payload := <-ch:
-			if payload.Err != nil {
-				return payload.Err
-			}
-			if payload.Data == nil {
-				logger.Error("empty payload")
-				continue
-			}
-			r, ok := payload.Data.(*entity.MultiBulkReply)
-			if !ok {
-				logger.Error("require multi bulk protocol")
-				continue
+	a.db = db
+	a.exec = execInstance
+	if !a.Enabled() {
+		return nil
+	}
+	defer a.resetSize()
+	execInstance.SetLoading(true)
+	defer execInstance.SetLoading(false)
+	if a.hybrid {
+		return a.loadParts()
+	}
+	logger.Info("loading aof", "file", a.file.Name())
+	reader := bufio.NewReader(a.file)
+	if rewrite := readRewrite(reader); rewrite != nil {
+		// the numbering continues after the commands held by the rewrite
+		// even when no command was appended to it
+		a.seq, a.lastMs = rewrite.Seq, rewrite.Time.UnixMilli()
+	}
+	if err := a.replay(reader); err != nil {
+		return err
+	}
+	logger.Info("aof loaded", "file", a.file.Name())
+	return nil
+}
+
+// loadParts loads the base snapshot and replays the incr parts listed by the
+// manifest, a plain aof file left by a previous run becomes the first incr part
+func (a *AofInstance) loadParts() error {
+	m, err := loadManifest(a.manifestName())
+	if os.IsNotExist(err) {
+		m = &manifest{parts: []*aofPart{{name: filepath.Base(a.fileName), seq: 1, partType: partTypeIncr}}}
+		err = m.save(a.manifestName())
+	}
+	if err != nil {
+		return err
+	}
+	if m.lastIncr() == nil {
+		m.parts = append(m.parts, a.newPart(m.lastSeq()+1, partTypeIncr))
+		if err = m.save(a.manifestName()); err != nil {
+			return err
+		}
+	}
+	a.manifest = m
+	for _, part := range m.parts {
+		logger.Info("loading aof part", "file", a.partPath(part))
+		if part.partType == partTypeBase {
+			err = snapshot.ReadFile(a.partPath(part), a.db)
+			if part.endSeq > a.seq {
+				a.seq, a.lastMs = part.endSeq, part.endMs
 			}
-			execInstance.Execute(r.Args)
-		case <-timer.C:
-			flag = false
+		} else {
+			err = a.replayFile(a.partPath(part))
+		}
+		if err != nil {
+			return err
 		}
 	}
-	fmt.Println("build completed")
+	a.file, err = os.OpenFile(a.partPath(m.lastIncr()), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	logger.Info("aof loaded", "manifest", a.manifestName())
 	return nil
 }
 
-func ExpireToExpireAt(args [][]byte) [][]byte {
-	var cmd [][]byte
-	if len(args) < 3 {
-		return args
+func (a *AofInstance) replayFile(fileName string) error {
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil
 	}
-	sec, err := strconv.Atoi(string(args[2]))
 	if err != nil {
-		return args
+		return err
+	}
+	defer file.Close()
+	return a.replay(file)
+}
+
+// replay executes every command read from reader
+func (a *AofInstance) replay(reader io.Reader) error {
+	return ReadRecords(reader, func(record *Record) bool {
+		if record.Seq > a.seq {
+			a.seq, a.lastMs = record.Seq, record.Time.UnixMilli()
+		}
+		a.exec.Execute(record.Args)
+		return true
+	})
+}
+
+// resetSize takes the current aof size as the base of the auto rewrite growth
+func (a *AofInstance) resetSize() {
+	size := a.size()
+	a.mu.Lock()
+	a.baseSize = size
+	a.currentSize = size
+	a.mu.Unlock()
+}
+
+// size returns the total size of the files that make up the aof
+func (a *AofInstance) size() int64 {
+	if !a.hybrid {
+		return fileSize(a.fileName)
+	}
+	var size int64
+	for _, part := range a.manifest.parts {
+		size += fileSize(a.partPath(part))
+	}
+	return size
+}
+
+func fileSize(fileName string) int64 {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return 0
 	}
-	return append(cmd, []byte("expireat"), args[1], []byte(fmt.Sprint(time.Now().Unix()+int64(sec))))
+	return info.Size()
 }
@@ -0,0 +1,337 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"kv_storage/config"
+	"kv_storage/datastore"
+	"kv_storage/datastruct/sortedset"
+	"kv_storage/executer"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/lib/utils"
+)
+
+// sorted returns the entries of db sorted by key
+func sorted(db *datastore.Map) []*datastore.Entry {
+	entries := db.Snapshot()
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	at := time.UnixMilli(1760875200123)
+	tests := []struct {
+		name   string
+		record *Record
+	}{
+		{"annotated", &Record{Seq: 42, Time: at, Args: utils.ToCmdLine("set", "k", "v")}},
+		{"without annotation", &Record{Args: utils.ToCmdLine("del", "k")}},
+		{"binary args", &Record{Seq: 1, Time: at, Args: [][]byte{[]byte("set"), []byte("k\r\n"), {0, 0xFF}}}},
+	}
+	var buf bytes.Buffer
+	for _, tt := range tests {
+		if err := WriteRecord(&buf, tt.record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []*Record
+	if err := ReadRecords(&buf, func(record *Record) bool {
+		got = append(got, record)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(tests) {
+		t.Fatalf("read %d records, want %d", len(got), len(tests))
+	}
+	for i, tt := range tests {
+		if !reflect.DeepEqual(got[i], tt.record) {
+			t.Errorf("%s: read %+v, want %+v", tt.name, got[i], tt.record)
+		}
+	}
+}
+
+func TestReadRecordsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"not a command", "+OK\r\n"},
+		{"integer", ":1\r\n"},
+		{"corrupted", "*2\r\n$3\r\nget\r\n$x\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ReadRecords(strings.NewReader(tt.input), func(record *Record) bool { return true })
+			if err == nil {
+				t.Fatal("read without error")
+			}
+		})
+	}
+}
+
+func TestReadRewrite(t *testing.T) {
+	at := time.UnixMilli(1760875200123)
+	tests := []struct {
+		name  string
+		input string
+		want  *Rewrite
+	}{
+		{"rewritten", string(makeRewriteAnnotation(42, at.UnixMilli())) + "*1\r\n$4\r\nping\r\n", &Rewrite{Seq: 42, Time: at}},
+		{"annotated record", string(makeAnnotation(42, at.UnixMilli())) + "*1\r\n$4\r\nping\r\n", nil},
+		{"command", "*3\r\n$3\r\nset\r\n$1\r\nk\r\n$1\r\nv\r\n", nil},
+		{"truncated", string(makeRewriteAnnotation(42, at.UnixMilli()))[:10], nil},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := bufio.NewReader(strings.NewReader(tt.input))
+			if got := readRewrite(reader); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got %+v, want %+v", got, tt.want)
+			}
+			// nothing is consumed
+			if rest, _ := io.ReadAll(reader); string(rest) != tt.input {
+				t.Fatalf("left %q", rest)
+			}
+		})
+	}
+}
+
+func TestManifest(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "cmdLog.txt.manifest")
+	tests := []struct {
+		name  string
+		parts []*aofPart
+	}{
+		{"plain incr", []*aofPart{{name: "cmdLog.txt", seq: 1, partType: partTypeIncr, endSeq: -1}}},
+		{"base and incr", []*aofPart{
+			{name: "cmdLog.txt.2.base.snap", seq: 2, partType: partTypeBase, endSeq: 1040, endMs: 1760875200000},
+			{name: "cmdLog.txt.2.incr.aof", seq: 2, partType: partTypeIncr, endSeq: -1},
+		}},
+		{"base without end", []*aofPart{
+			{name: "cmdLog.txt.3.base.snap", seq: 3, partType: partTypeBase, endSeq: -1},
+			{name: "cmdLog.txt.3.incr.aof", seq: 3, partType: partTypeIncr, endSeq: -1},
+			{name: "cmdLog.txt.4.incr.aof", seq: 4, partType: partTypeIncr, endSeq: -1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := (&manifest{parts: tt.parts}).save(fileName); err != nil {
+				t.Fatal(err)
+			}
+			m, err := loadManifest(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(m.parts, tt.parts) {
+				t.Fatalf("loaded %+v, want %+v", m.parts, tt.parts)
+			}
+			if _, err := os.Stat(fileName + ".tmp"); !os.IsNotExist(err) {
+				t.Fatalf("temporary file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"odd fields", "file a seq"},
+		{"missing file", "seq 1 type i"},
+		{"unknown type", "file a seq 1 type x"},
+		{"invalid seq", "file a seq x type i"},
+		{"invalid endseq", "file a seq 1 type b endseq x endms 1"},
+		{"invalid endms", "file a seq 1 type b endseq 1 endms x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "manifest")
+			if err := os.WriteFile(fileName, []byte("# comment\n\n"+tt.line+"\n"), 0666); err != nil {
+				t.Fatal(err)
+			}
+			if m, err := loadManifest(fileName); err == nil {
+				t.Fatalf("loaded %+v", m.parts)
+			}
+		})
+	}
+}
+
+func TestEntryToCmds(t *testing.T) {
+	var long [][]byte
+	var elements []sortedset.Element
+	for i := 0; i < 2*rewriteItemsPerCmd+1; i++ {
+		long = append(long, []byte(strconv.Itoa(i)))
+		elements = append(elements, sortedset.Element{Member: "m" + strconv.Itoa(i), Score: float64(i) / 3})
+	}
+	deadLine := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	tests := []struct {
+		name     string
+		entry    *datastore.Entry
+		wantCmds int
+	}{
+		{"string", &datastore.Entry{Key: "k", Value: []byte("v")}, 1},
+		{"string with ttl", &datastore.Entry{Key: "k", Value: []byte("v"), HasTTL: true, DeadLine: deadLine}, 2},
+		{"list", &datastore.Entry{Key: "k", Value: [][]byte{[]byte("a"), []byte("b")}}, 1},
+		{"long list", &datastore.Entry{Key: "k", Value: long}, 3},
+		{"zset", &datastore.Entry{Key: "k", Value: []sortedset.Element{{Member: "a", Score: -0.5}}}, 1},
+		{"long zset with ttl", &datastore.Entry{Key: "k", Value: elements, HasTTL: true, DeadLine: deadLine}, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds := EntryToCmds(tt.entry)
+			if len(cmds) != tt.wantCmds {
+				t.Fatalf("%d commands, want %d", len(cmds), tt.wantCmds)
+			}
+			db := datastore.NewMap()
+			exec := executer.NewExecuter(db)
+			if err := ReadRecords(bytes.NewReader(bytes.Join(cmds, nil)), func(record *Record) bool {
+				exec.Execute(record.Args)
+				return true
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if got := sorted(db); len(got) != 1 || !reflect.DeepEqual(got[0], tt.entry) {
+				t.Fatalf("replayed %+v, want %+v", got, tt.entry)
+			}
+		})
+	}
+}
+
+func TestAbsoluteExpiry(t *testing.T) {
+	now := time.UnixMilli(1760875200000)
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"set k v", "set k v"},
+		{"set k v ex 10", "set k v pxat 1760875210000"},
+		{"set k v px 10 nx", "set k v pxat 1760875200010 nx"},
+		{"set k v exat 1", "del k"},
+		{"setex k 10 v", "set k v pxat 1760875210000"},
+		{"psetex k 10 v", "set k v pxat 1760875200010"},
+		{"expire k 10", "pexpireat k 1760875210000"},
+		{"pexpire k 10", "pexpireat k 1760875200010"},
+		{"expire k 0", "del k"},
+		{"pexpire k -1", "del k"},
+		{"expireat k 1", "del k"},
+		{"getex k persist", "persist k"},
+		{"getex k ex 10", "pexpireat k 1760875210000"},
+		{"getex k", ""},
+		{"rpush k a", "rpush k a"},
+	}
+	for _, tt := range tests {
+		got := absoluteExpiry(utils.ToCmdLine(strings.Fields(tt.cmd)...), now)
+		if string(bytes.Join(got, []byte(" "))) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+// openAof loads the aof named fileName into a new dataset and starts
+// persisting the commands executed through it
+func openAof(t *testing.T, fileName string, hybrid bool) (*AofInstance, *datastore.Map) {
+	cfg := config.NewDefaultConfig()
+	cfg.AofFile = fileName
+	cfg.AofUseSnapshotPreamble = hybrid
+	cfg.AutoAofRewritePercentage = 0
+	a := NewAofInstance(cfg)
+	db := datastore.NewMap()
+	if err := a.Init(db, executer.NewExecuter(db)); err != nil {
+		t.Fatal(err)
+	}
+	go a.Persist()
+	return a, db
+}
+
+func closeAof(t *testing.T, a *AofInstance) {
+	if err := a.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+}
+
+func rewriteAof(t *testing.T, a *AofInstance) {
+	if err := a.BackgroundRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	for a.Status().Rewriting {
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.Status().LastRewriteErr; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	// the deadline is a whole millisecond, like the files keep it
+	deadLine := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+	before := []string{
+		"set a 1", "set a 2", "set b x pxat " + deadLine, "rpush l 1 2 3", "lpop l",
+		"zadd z 1 m1 2 m2", "zrem z m1", "set gone v", "del gone",
+	}
+	after := []string{"set a 3", "rpush l 4", "zadd z 3 m3", "set c v"}
+	for _, hybrid := range []bool{false, true} {
+		t.Run("hybrid="+strconv.FormatBool(hybrid), func(t *testing.T) {
+			fileName := filepath.Join(t.TempDir(), "cmdLog.txt")
+			a, db := openAof(t, fileName, hybrid)
+			for _, cmd := range before {
+				a.Execute(utils.ToCmdLine(strings.Fields(cmd)...))
+			}
+			rewriteAof(t, a)
+			for _, cmd := range after {
+				a.Execute(utils.ToCmdLine(strings.Fields(cmd)...))
+			}
+			want := sorted(db)
+			closeAof(t, a)
+
+			base, incrs, err := Parts(fileName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hybrid {
+				if base == nil || len(incrs) != 1 {
+					t.Fatalf("parts %+v %v, want a base and an incr part", base, incrs)
+				}
+				if base.Seq != int64(len(before)) {
+					t.Fatalf("base ends at %d, want %d", base.Seq, len(before))
+				}
+			} else {
+				if base != nil || len(incrs) != 1 || incrs[0] != fileName {
+					t.Fatalf("parts %+v %v, want the single file", base, incrs)
+				}
+				rewrite, err := ReadRewrite(fileName)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if rewrite == nil || rewrite.Seq != int64(len(before)) {
+					t.Fatalf("rewrite %+v, want it to end at %d", rewrite, len(before))
+				}
+			}
+
+			a, db = openAof(t, fileName, hybrid)
+			if got := sorted(db); !reflect.DeepEqual(got, want) {
+				t.Fatalf("reloaded %+v, want %+v", got, want)
+			}
+			// the sequence numbers continue after the reload
+			a.Execute(utils.ToCmdLine("set", "d", "v"))
+			if err := a.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			if a.seq != int64(len(before)+len(after)+1) {
+				t.Fatalf("command numbered %d, want %d", a.seq, len(before)+len(after)+1)
+			}
+			closeAof(t, a)
+		})
+	}
+}
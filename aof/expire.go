@@ -0,0 +1,91 @@
+package aof
+
+import (
+	"kv_storage/executer"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// absoluteExpiry rewrites the relative expiries of a write command executed
+// at now into deadlines in unix milliseconds, so a replayed command neither
+// gives a key a fresh lifetime nor brings back one that already expired.
+// A deadline that already passed is logged as del, like redis propagates it.
+// The result is nil when the command changed nothing worth persisting.
+func absoluteExpiry(args [][]byte, now time.Time) [][]byte {
+	name := strings.ToLower(string(args[0]))
+	switch name {
+	case "expire", "pexpire", "expireat":
+		if len(args) < 3 {
+			return args
+		}
+		deadLine, err := executer.DeadLine(executer.ExpireUnits[name], args[2], now)
+		if err != nil {
+			return args
+		}
+		return expireAt(args[1], deadLine, now)
+	case "setex", "psetex":
+		if len(args) < 4 {
+			return args
+		}
+		unit := "ex"
+		if name == "psetex" {
+			unit = "px"
+		}
+		deadLine, err := executer.DeadLine(unit, args[2], now)
+		if err != nil {
+			return args
+		}
+		if !deadLine.After(now) {
+			return [][]byte{[]byte("del"), args[1]}
+		}
+		return [][]byte{[]byte("set"), args[1], args[3], []byte("pxat"), formatMs(deadLine)}
+	case "set":
+		cmd := make([][]byte, 0, len(args))
+		cmd = append(cmd, args[:min(len(args), 3)]...)
+		for i := 3; i < len(args); i++ {
+			if executer.IsExpiryOption(args[i]) && i+1 < len(args) {
+				deadLine, err := executer.DeadLine(string(args[i]), args[i+1], now)
+				if err != nil {
+					return args
+				}
+				if !deadLine.After(now) {
+					return [][]byte{[]byte("del"), args[1]}
+				}
+				cmd = append(cmd, []byte("pxat"), formatMs(deadLine))
+				i++
+				continue
+			}
+			cmd = append(cmd, args[i])
+		}
+		return cmd
+	case "getex":
+		// getex without options only reads the key
+		if len(args) < 3 {
+			return nil
+		}
+		if strings.EqualFold(string(args[2]), "persist") {
+			return [][]byte{[]byte("persist"), args[1]}
+		}
+		if len(args) < 4 {
+			return args
+		}
+		deadLine, err := executer.DeadLine(string(args[2]), args[3], now)
+		if err != nil {
+			return args
+		}
+		return expireAt(args[1], deadLine, now)
+	}
+	return args
+}
+
+func expireAt(key []byte, deadLine time.Time, now time.Time) [][]byte {
+	if !deadLine.After(now) {
+		return [][]byte{[]byte("del"), key}
+	}
+	return [][]byte{[]byte("pexpireat"), key, formatMs(deadLine)}
+}
+
+func formatMs(t time.Time) []byte {
+	return []byte(strconv.FormatInt(t.UnixMilli(), 10))
+}
@@ -0,0 +1,146 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	partTypeBase = "b"
+	partTypeIncr = "i"
+)
+
+// aofPart is a file of a multi part aof, the base is a snapshot of the
+// dataset and the incr parts hold the commands executed after it
+type aofPart struct {
+	name     string
+	seq      int
+	partType string
+	// endSeq and endMs are the sequence number and the execution time of
+	// the last command held by a base, endSeq is -1 when they are unknown
+	endSeq int64
+	endMs  int64
+}
+
+// manifest records the parts that make up the current dataset, in the
+// order they have to be loaded. Each line looks like
+// file cmdLog.txt.2.base.snap seq 2 type b endseq 1040 endms 1760875200000
+type manifest struct {
+	parts []*aofPart
+}
+
+func loadManifest(fileName string) (*manifest, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m := &manifest{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields)%2 != 0 {
+			return nil, errors.New("invalid aof manifest line: " + line)
+		}
+		part := &aofPart{endSeq: -1}
+		for i := 0; i < len(fields); i += 2 {
+			switch fields[i] {
+			case "file":
+				part.name = fields[i+1]
+			case "seq":
+				if part.seq, err = strconv.Atoi(fields[i+1]); err != nil {
+					return nil, errors.New("invalid aof manifest line: " + line)
+				}
+			case "type":
+				part.partType = fields[i+1]
+			case "endseq":
+				if part.endSeq, err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+					return nil, errors.New("invalid aof manifest line: " + line)
+				}
+			case "endms":
+				if part.endMs, err = strconv.ParseInt(fields[i+1], 10, 64); err != nil {
+					return nil, errors.New("invalid aof manifest line: " + line)
+				}
+			}
+		}
+		if part.name == "" || (part.partType != partTypeBase && part.partType != partTypeIncr) {
+			return nil, errors.New("invalid aof manifest line: " + line)
+		}
+		m.parts = append(m.parts, part)
+	}
+	return m, scanner.Err()
+}
+
+// save replaces the manifest file atomically
+func (m *manifest) save(fileName string) error {
+	tmpName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, part := range m.parts {
+		fmt.Fprintf(writer, "file %s seq %d type %s", part.name, part.seq, part.partType)
+		if part.partType == partTypeBase && part.endSeq >= 0 {
+			fmt.Fprintf(writer, " endseq %d endms %d", part.endSeq, part.endMs)
+		}
+		writer.WriteByte('\n')
+	}
+	if err = writer.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+func (m *manifest) lastSeq() int {
+	seq := 0
+	for _, part := range m.parts {
+		if part.seq > seq {
+			seq = part.seq
+		}
+	}
+	return seq
+}
+
+// lastIncr returns the part new commands are appended to
+func (m *manifest) lastIncr() *aofPart {
+	for i := len(m.parts) - 1; i >= 0; i-- {
+		if m.parts[i].partType == partTypeIncr {
+			return m.parts[i]
+		}
+	}
+	return nil
+}
+
+func (a *AofInstance) manifestName() string {
+	return a.fileName + ".manifest"
+}
+
+func (a *AofInstance) partPath(part *aofPart) string {
+	return filepath.Join(filepath.Dir(a.fileName), part.name)
+}
+
+func (a *AofInstance) newPart(seq int, partType string) *aofPart {
+	suffix := "incr.aof"
+	if partType == partTypeBase {
+		suffix = "base.snap"
+	}
+	name := fmt.Sprintf("%s.%d.%s", filepath.Base(a.fileName), seq, suffix)
+	return &aofPart{name: name, seq: seq, partType: partType, endSeq: -1}
+}
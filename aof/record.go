@@ -0,0 +1,183 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"kv_storage/entity"
+	"kv_storage/parser"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// every command in the aof is preceded by an annotation line
+// #TS:<unix milliseconds>:<sequence number>
+const timestampPrefix = "TS:"
+
+// a plain rewrite starts the file with an annotation line
+// #REWRITE:<unix milliseconds>:<sequence number> of the last command held by
+// the rewritten commands, which carry no annotation of their own
+const rewritePrefix = "REWRITE:"
+
+// Record is a command of the aof with the time it was executed and its
+// sequence number, both are zero for commands written by a rewrite or by
+// versions that did not annotate the aof
+type Record struct {
+	Seq  int64
+	Time time.Time
+	Args [][]byte
+}
+
+func makeAnnotation(seq int64, ms int64) []byte {
+	return makePrefixedAnnotation(timestampPrefix, seq, ms)
+}
+
+func makeRewriteAnnotation(seq int64, ms int64) []byte {
+	return makePrefixedAnnotation(rewritePrefix, seq, ms)
+}
+
+func makePrefixedAnnotation(prefix string, seq int64, ms int64) []byte {
+	return entity.MakeAnnotationReply(prefix + strconv.FormatInt(ms, 10) + ":" + strconv.FormatInt(seq, 10)).ToBytes()
+}
+
+func parseAnnotation(text string, prefix string) (seq int64, t time.Time, ok bool) {
+	if !strings.HasPrefix(text, prefix) {
+		return 0, time.Time{}, false
+	}
+	fields := strings.Split(text[len(prefix):], ":")
+	if len(fields) != 2 {
+		return 0, time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	seq, err = strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, false
+	}
+	return seq, time.UnixMilli(ms), true
+}
+
+// ReadRecords calls consumer with every command read from reader, it stops
+// early when consumer returns false
+func ReadRecords(reader io.Reader, consumer func(record *Record) bool) error {
+	ch := make(chan *parser.Payload)
+	go parser.Parse0(reader, ch)
+	defer func() {
+		// let the parser finish if the consumer stopped early
+		go func() {
+			for range ch {
+			}
+		}()
+	}()
+	var seq int64
+	var t time.Time
+	for payload := range ch {
+		if payload.Err != nil {
+			if payload.Err == io.EOF {
+				return nil
+			}
+			return payload.Err
+		}
+		switch data := payload.Data.(type) {
+		case *entity.AnnotationReply:
+			if s, at, ok := parseAnnotation(data.Text, timestampPrefix); ok {
+				seq, t = s, at
+			}
+		case *entity.MultiBulkReply:
+			if !consumer(&Record{Seq: seq, Time: t, Args: data.Args}) {
+				return nil
+			}
+			seq, t = 0, time.Time{}
+		default:
+			return errors.New("require multi bulk protocol")
+		}
+	}
+	return nil
+}
+
+// Rewrite is the start of a plain aof written by a rewrite, Seq and Time are
+// the sequence number and the execution time of the last command it holds
+type Rewrite struct {
+	Seq  int64
+	Time time.Time
+}
+
+// readRewrite returns the rewrite annotation at the top of the file read by
+// reader without consuming it, or nil when the file does not start with one
+func readRewrite(reader *bufio.Reader) *Rewrite {
+	// the longest annotation is 9+20+1+20+2 bytes
+	head, _ := reader.Peek(64)
+	end := bytes.Index(head, []byte("\r\n"))
+	if len(head) == 0 || head[0] != '#' || end < 0 {
+		return nil
+	}
+	seq, t, ok := parseAnnotation(string(head[1:end]), rewritePrefix)
+	if !ok {
+		return nil
+	}
+	return &Rewrite{Seq: seq, Time: t}
+}
+
+// ReadRewrite returns the rewrite at the top of the plain aof fileName, or
+// nil when it was never rewritten
+func ReadRewrite(fileName string) (*Rewrite, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return readRewrite(bufio.NewReader(file)), nil
+}
+
+// WriteRewrite writes the annotation starting a plain aof whose first
+// commands hold the dataset as of rewrite
+func WriteRewrite(writer io.Writer, rewrite *Rewrite) error {
+	_, err := writer.Write(makeRewriteAnnotation(rewrite.Seq, rewrite.Time.UnixMilli()))
+	return err
+}
+
+// WriteRecord writes a command together with its annotation
+func WriteRecord(writer io.Writer, record *Record) error {
+	if record.Seq > 0 {
+		if _, err := writer.Write(makeAnnotation(record.Seq, record.Time.UnixMilli())); err != nil {
+			return err
+		}
+	}
+	_, err := writer.Write(entity.MakeMultiBulkReply(record.Args).ToBytes())
+	return err
+}
+
+// Base is the snapshot of a hybrid aof, Seq and Time are the sequence number
+// and the execution time of the last command it holds, Seq is -1 when the
+// manifest does not record them
+type Base struct {
+	File string
+	Seq  int64
+	Time time.Time
+}
+
+// Parts returns the files that make up the aof named fileName in load order,
+// base is the snapshot of a hybrid aof and nil for a plain one
+func Parts(fileName string) (base *Base, incrs []string, err error) {
+	a := &AofInstance{fileName: fileName}
+	m, err := loadManifest(a.manifestName())
+	if os.IsNotExist(err) {
+		return nil, []string{fileName}, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, part := range m.parts {
+		if part.partType == partTypeBase {
+			base = &Base{File: a.partPath(part), Seq: part.endSeq, Time: time.UnixMilli(part.endMs)}
+		} else {
+			incrs = append(incrs, a.partPath(part))
+		}
+	}
+	return base, incrs, nil
+}
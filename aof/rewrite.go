@@ -0,0 +1,242 @@
+package aof
+
+import (
+	"errors"
+	"kv_storage/datastore"
+	"kv_storage/datastruct/sortedset"
+	"kv_storage/entity"
+	"kv_storage/logger"
+	"kv_storage/snapshot"
+	"os"
+	"strconv"
+	"time"
+)
+
+// rewriteItemsPerCmd bounds the number of elements a single rpush or zadd
+// emitted by a rewrite carries
+const rewriteItemsPerCmd = 64
+
+// BackgroundRewrite starts compacting the aof file into the smallest set of
+// commands that rebuilds the current dataset. Commands executed while the
+// rewrite is running are buffered and appended to the new file before it
+// replaces the old one. A hybrid aof is compacted into a snapshot base
+// instead, and the commands go to a new incr part right away.
+func (a *AofInstance) BackgroundRewrite() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewriting {
+		return ErrRewriteInProgress
+	}
+	if !a.Enabled() {
+		return errors.New("aof is disabled")
+	}
+	a.rewriting = true
+	a.rewriteStarted = time.Now()
+	go a.doRewrite()
+	return nil
+}
+
+func (a *AofInstance) doRewrite() {
+	err := a.rewrite()
+
+	a.mu.Lock()
+	a.rewriting = false
+	a.buffering = false
+	a.rewriteBuffer = nil
+	a.lastRewriteDuration = time.Since(a.rewriteStarted)
+	a.lastRewriteErr = err
+	a.mu.Unlock()
+	if err != nil {
+		logger.Error("aof rewrite failed", "err", err)
+		return
+	}
+	logger.Info("aof rewrite completed", "duration", time.Since(a.rewriteStarted))
+}
+
+func (a *AofInstance) rewrite() error {
+	if a.hybrid {
+		return a.rewriteHybrid()
+	}
+	// snapshot the dataset and start buffering at the same point of the
+	// command stream, every command executed before the fence is already
+	// in the snapshot and every command queued after it ends up in the buffer.
+	// The snapshot is copy-on-write so the lock is only held to begin it.
+	a.exec.Lock()
+	if a.closed {
+		a.exec.Unlock()
+		return errAofClosed
+	}
+	snap := a.db.BeginSnapshot()
+	defer snap.Close()
+	buffering := make(chan struct{})
+	var endSeq, endMs int64
+	a.cmdCh <- &record{fence: func() {
+		a.buffering = true
+		endSeq, endMs = a.seq, a.lastMs
+		close(buffering)
+	}}
+	a.exec.Unlock()
+
+	tmpName := a.fileName + ".rewrite.tmp"
+	tmpFile, err := os.OpenFile(tmpName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	// the commands queued before the fence are in the snapshot, they must
+	// be written to the old file rather than after the snapshot. The file
+	// starts with the last of them, so that a recovery does not take the
+	// rewritten commands for older ones.
+	<-buffering
+	if _, err = tmpFile.Write(makeRewriteAnnotation(endSeq, endMs)); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	snap.ForEach(func(entry *datastore.Entry) bool {
+		for _, cmd := range EntryToCmds(entry) {
+			if _, err = tmpFile.Write(cmd); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	// the persist goroutine is blocked from here on, so the buffer is
+	// complete and the swap is atomic with respect to new commands
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err = tmpFile.Write(a.rewriteBuffer); err == nil {
+		err = tmpFile.Sync()
+	}
+	if err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err = os.Rename(tmpName, a.fileName); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	a.file.Close()
+	a.file = tmpFile
+	info, err := tmpFile.Stat()
+	if err == nil {
+		a.baseSize = info.Size()
+		a.currentSize = info.Size()
+	}
+	return nil
+}
+
+func (a *AofInstance) rewriteHybrid() error {
+	a.mu.Lock()
+	seq := a.manifest.lastSeq() + 1
+	a.mu.Unlock()
+	incr := a.newPart(seq, partTypeIncr)
+	incrFile, err := os.OpenFile(a.partPath(incr), os.O_CREATE|os.O_RDWR|os.O_TRUNC|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	// switch to the new incr part at the point the dataset is snapshotted,
+	// the manifest keeps listing the old parts until the new base is written
+	// so a crash in between still loads the complete dataset
+	switched := make(chan error, 1)
+	var endSeq, endMs int64
+	a.exec.Lock()
+	if a.closed {
+		a.exec.Unlock()
+		incrFile.Close()
+		os.Remove(a.partPath(incr))
+		return errAofClosed
+	}
+	snap := a.db.BeginSnapshot()
+	defer snap.Close()
+	a.cmdCh <- &record{fence: func() {
+		parts := append(a.manifest.parts, incr)
+		if err := (&manifest{parts: parts}).save(a.manifestName()); err != nil {
+			switched <- err
+			return
+		}
+		a.manifest.parts = parts
+		a.file.Close()
+		a.file = incrFile
+		endSeq, endMs = a.seq, a.lastMs
+		switched <- nil
+	}}
+	a.exec.Unlock()
+	if err = <-switched; err != nil {
+		incrFile.Close()
+		os.Remove(a.partPath(incr))
+		return err
+	}
+
+	base := a.newPart(seq, partTypeBase)
+	base.endSeq, base.endMs = endSeq, endMs
+	if err = snapshot.WriteFile(a.partPath(base), snap); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	next := &manifest{parts: []*aofPart{base, incr}}
+	if err = next.save(a.manifestName()); err != nil {
+		os.Remove(a.partPath(base))
+		return err
+	}
+	for _, part := range a.manifest.parts {
+		if part != incr {
+			os.Remove(a.partPath(part))
+		}
+	}
+	a.manifest = next
+	a.baseSize = a.size()
+	a.currentSize = a.baseSize
+	return nil
+}
+
+// EntryToCmds returns the serialized commands that recreate a single key
+func EntryToCmds(entry *datastore.Entry) [][]byte {
+	var cmds [][]byte
+	key := []byte(entry.Key)
+	switch v := entry.Value.(type) {
+	case []byte:
+		cmds = append(cmds, makeCmd("set", key, v))
+	case [][]byte:
+		for len(v) > 0 {
+			n := min(len(v), rewriteItemsPerCmd)
+			cmds = append(cmds, makeCmd("rpush", append([][]byte{key}, v[:n]...)...))
+			v = v[n:]
+		}
+	case []sortedset.Element:
+		for len(v) > 0 {
+			n := min(len(v), rewriteItemsPerCmd)
+			args := [][]byte{key}
+			for _, element := range v[:n] {
+				args = append(args, []byte(strconv.FormatFloat(element.Score, 'g', -1, 64)), []byte(element.Member))
+			}
+			cmds = append(cmds, makeCmd("zadd", args...))
+			v = v[n:]
+		}
+	}
+	if len(cmds) > 0 && entry.HasTTL {
+		cmds = append(cmds, makeCmd("pexpireat", key, formatMs(entry.DeadLine)))
+	}
+	return cmds
+}
+
+func makeCmd(name string, args ...[]byte) []byte {
+	return entity.MakeMultiBulkReply(append([][]byte{[]byte(name)}, args...)).ToBytes()
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,241 @@
+// aof-tool lists the commands of an aof and recovers the dataset as it was at
+// a given time or sequence number, e.g. right before a mistaken flush.
+//
+//	aof-tool list -aof cmdLog.txt
+//	aof-tool recover -aof cmdLog.txt -until-seq 1041 -out recovered.txt
+//	aof-tool recover -aof cmdLog.txt -until-time 2026-10-19T12:00:00Z -out recovered.txt
+//
+// The recovered file is a plain aof, stop the server and use it as aofFile.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"kv_storage/aof"
+	"kv_storage/datastore"
+	"kv_storage/snapshot"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxArgLen = 64
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = list(os.Args[2:])
+	case "recover":
+		err = recoverAof(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: aof-tool list -aof <file>")
+	fmt.Fprintln(os.Stderr, "       aof-tool recover -aof <file> [-until-seq <n>] [-until-time <RFC3339|unix ms>] -out <file>")
+	os.Exit(2)
+}
+
+func list(args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	aofFile := flags.String("aof", "", "aof file, the manifest next to it is used for a hybrid aof")
+	flags.Parse(args)
+	base, incrs, err := aof.Parts(*aofFile)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(os.Stdout)
+	defer writer.Flush()
+	if base != nil {
+		if base.Seq < 0 {
+			fmt.Fprintf(writer, "base snapshot %s\n", base.File)
+		} else {
+			fmt.Fprintf(writer, "base snapshot %s up to %d  %s\n", base.File, base.Seq, base.Time.Format("2006-01-02 15:04:05.000"))
+		}
+	} else {
+		rewrite, err := aof.ReadRewrite(incrs[0])
+		if err != nil {
+			return err
+		}
+		if rewrite != nil {
+			fmt.Fprintf(writer, "rewritten up to %d  %s\n", rewrite.Seq, rewrite.Time.Format("2006-01-02 15:04:05.000"))
+		}
+	}
+	for _, incr := range incrs {
+		err = readFile(incr, func(record *aof.Record) bool {
+			if record.Seq == 0 {
+				fmt.Fprintf(writer, "%8s  %-23s  %s\n", "-", "-", formatArgs(record.Args))
+			} else {
+				fmt.Fprintf(writer, "%8d  %-23s  %s\n", record.Seq, record.Time.Format("2006-01-02 15:04:05.000"), formatArgs(record.Args))
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recoverAof(args []string) error {
+	flags := flag.NewFlagSet("recover", flag.ExitOnError)
+	aofFile := flags.String("aof", "", "aof file, the manifest next to it is used for a hybrid aof")
+	untilSeq := flags.Int64("until-seq", 0, "keep the commands up to this sequence number")
+	untilTime := flags.String("until-time", "", "keep the commands executed up to this time")
+	out := flags.String("out", "", "recovered aof file to write")
+	flags.Parse(args)
+	if *out == "" || (*untilSeq == 0 && *untilTime == "") {
+		usage()
+	}
+	var until time.Time
+	if *untilTime != "" {
+		var err error
+		if until, err = parseTime(*untilTime); err != nil {
+			return err
+		}
+	}
+
+	base, incrs, err := aof.Parts(*aofFile)
+	if err != nil {
+		return err
+	}
+	// the commands held by a base snapshot or by a rewrite have no sequence
+	// number, the recovered file starts with the same rewrite annotation
+	var rewrite *aof.Rewrite
+	if base != nil {
+		if err = checkBase(base, *untilSeq, until); err != nil {
+			return err
+		}
+		rewrite = &aof.Rewrite{Seq: base.Seq, Time: base.Time}
+	} else {
+		if rewrite, err = aof.ReadRewrite(incrs[0]); err != nil {
+			return err
+		}
+		if rewrite != nil {
+			if err = checkTarget("the rewrite", rewrite.Seq, rewrite.Time, *untilSeq, until); err != nil {
+				return err
+			}
+		}
+	}
+	file, err := os.OpenFile(*out, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	if rewrite != nil {
+		aof.WriteRewrite(writer, rewrite)
+	}
+	if base != nil {
+		db := datastore.NewMap()
+		if err = snapshot.ReadFile(base.File, db); err != nil {
+			return err
+		}
+		for _, entry := range db.Snapshot() {
+			for _, cmd := range aof.EntryToCmds(entry) {
+				writer.Write(cmd)
+			}
+		}
+	}
+	kept, last := 0, int64(0)
+	if rewrite != nil {
+		last = rewrite.Seq
+	}
+	stopped := false
+	var writeErr error
+	for _, incr := range incrs {
+		err = readFile(incr, func(record *aof.Record) bool {
+			if record.Seq > 0 && ((*untilSeq > 0 && record.Seq > *untilSeq) ||
+				(!until.IsZero() && record.Time.After(until))) {
+				stopped = true
+				return false
+			}
+			if writeErr = aof.WriteRecord(writer, record); writeErr != nil {
+				return false
+			}
+			kept++
+			if record.Seq > 0 {
+				last = record.Seq
+			}
+			return true
+		})
+		if err != nil || writeErr != nil || stopped {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if writeErr != nil {
+		return writeErr
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("recovered %d commands up to sequence number %d into %s\n", kept, last, *out)
+	return file.Sync()
+}
+
+// checkBase returns an error when the target is before the last command held
+// by the base snapshot, such a target can not be recovered since the
+// commands of the base can not be undone
+func checkBase(base *aof.Base, untilSeq int64, until time.Time) error {
+	if base.Seq < 0 {
+		return errors.New("the manifest does not record the last command of the base snapshot " + base.File)
+	}
+	return checkTarget("the base snapshot", base.Seq, base.Time, untilSeq, until)
+}
+
+// checkTarget returns an error when the target is before seq and t, the last
+// command held by holder
+func checkTarget(holder string, seq int64, t time.Time, untilSeq int64, until time.Time) error {
+	if untilSeq > 0 && untilSeq < seq {
+		return fmt.Errorf("sequence number %d is not reachable, %s holds the commands up to %d", untilSeq, holder, seq)
+	}
+	if !until.IsZero() && until.Before(t) {
+		return fmt.Errorf("%s is not reachable, %s holds the commands up to %s",
+			until.Format(time.RFC3339Nano), holder, t.Format(time.RFC3339Nano))
+	}
+	return nil
+}
+
+func readFile(fileName string, consumer func(record *aof.Record) bool) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return aof.ReadRecords(file, consumer)
+}
+
+func parseTime(s string) (time.Time, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func formatArgs(args [][]byte) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if len(arg) > maxArgLen {
+			quoted[i] = strconv.Quote(string(arg[:maxArgLen])) + "..."
+		} else {
+			quoted[i] = strconv.Quote(string(arg))
+		}
+	}
+	return strings.Join(quoted, " ")
+}
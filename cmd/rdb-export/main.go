@@ -0,0 +1,101 @@
+// rdb-export converts a snapshot or an aof of kv_storage into a redis dump.rdb
+package main
+
+import (
+	"flag"
+	"fmt"
+	"kv_storage/aof"
+	"kv_storage/config"
+	"kv_storage/datastore"
+	"kv_storage/datastruct/sortedset"
+	"kv_storage/executer"
+	"kv_storage/rdb"
+	"kv_storage/snapshot"
+	"os"
+)
+
+func main() {
+	in := flag.String("in", "dump.snap", "snapshot or aof file to export")
+	format := flag.String("format", "snapshot", "input format, snapshot or aof")
+	out := flag.String("out", "dump.rdb", "redis rdb file to write")
+	flag.Parse()
+
+	db := datastore.NewMap()
+	var err error
+	switch *format {
+	case "snapshot":
+		err = snapshot.ReadFile(*in, db)
+	case "aof":
+		err = loadAof(*in, db)
+	default:
+		fmt.Fprintln(os.Stderr, "unknown format:", *format)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "load error:", err.Error())
+		os.Exit(1)
+	}
+	entries := db.Snapshot()
+	if err = writeRdb(*out, entries); err != nil {
+		fmt.Fprintln(os.Stderr, "write rdb error:", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("exported %d keys into %s\n", len(entries), *out)
+}
+
+// loadAof replays a plain or a hybrid aof, a hybrid one is found through
+// its manifest next to fileName
+func loadAof(fileName string, db *datastore.Map) error {
+	if _, err := os.Stat(fileName); err != nil {
+		if _, err := os.Stat(fileName + ".manifest"); err != nil {
+			return err
+		}
+	}
+	aofInstance := aof.NewAofInstance(&config.Config{AofFile: fileName})
+	defer aofInstance.Close()
+	return aofInstance.Init(db, executer.NewExecuter(db))
+}
+
+func writeRdb(fileName string, entries []*datastore.Entry) error {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	enc := rdb.NewEncoder(file)
+	if err = enc.WriteHeader(); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = enc.WriteObject(entryToObject(entry)); err != nil {
+			return err
+		}
+	}
+	if err = enc.WriteFooter(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+func entryToObject(entry *datastore.Entry) *rdb.Object {
+	object := &rdb.Object{Key: entry.Key}
+	switch v := entry.Value.(type) {
+	case []byte:
+		object.Kind = rdb.StringKind
+		object.String = v
+	case [][]byte:
+		object.Kind = rdb.ListKind
+		object.List = v
+	case []sortedset.Element:
+		object.Kind = rdb.ZSetKind
+		object.ZSet = make([]rdb.ZSetEntry, len(v))
+		for i, element := range v {
+			object.ZSet[i] = rdb.ZSetEntry{Member: element.Member, Score: element.Score}
+		}
+	}
+	if entry.HasTTL {
+		deadLine := entry.DeadLine
+		object.Expiration = &deadLine
+	}
+	return object
+}
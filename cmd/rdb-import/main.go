@@ -0,0 +1,107 @@
+// rdb-import converts a redis dump.rdb into a snapshot or an aof file of kv_storage
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"kv_storage/aof"
+	"kv_storage/datastore"
+	"kv_storage/datastruct/sortedset"
+	"kv_storage/rdb"
+	"kv_storage/snapshot"
+	"os"
+	"time"
+)
+
+func main() {
+	rdbFile := flag.String("rdb", "dump.rdb", "redis rdb file to import")
+	out := flag.String("out", "dump.snap", "file to write")
+	format := flag.String("format", "snapshot", "output format, snapshot or aof")
+	db := flag.Int("db", 0, "redis db to import, -1 merges all dbs")
+	flag.Parse()
+
+	if *format != "snapshot" && *format != "aof" {
+		fmt.Fprintln(os.Stderr, "unknown format:", *format)
+		os.Exit(2)
+	}
+	entries, skipped, err := readRdb(*rdbFile, *db)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "read rdb error:", err.Error())
+		os.Exit(1)
+	}
+	if *format == "snapshot" {
+		err = snapshot.WriteFile(*out, datastore.EntryList(entries))
+	} else {
+		err = writeAof(*out, entries)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "write error:", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("imported %d keys into %s, skipped %d\n", len(entries), *out, skipped)
+}
+
+// readRdb returns the keys of db that kv_storage can store, hashes, sets and
+// expired keys are skipped
+func readRdb(fileName string, db int) ([]*datastore.Entry, int, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+	var entries []*datastore.Entry
+	skipped := 0
+	now := time.Now()
+	err = rdb.NewDecoder(file).Parse(func(object *rdb.Object) bool {
+		if db >= 0 && object.DB != db {
+			return true
+		}
+		if object.Expiration != nil && !object.Expiration.After(now) {
+			skipped++
+			return true
+		}
+		entry := &datastore.Entry{Key: object.Key}
+		switch object.Kind {
+		case rdb.StringKind:
+			entry.Value = object.String
+		case rdb.ListKind:
+			entry.Value = object.List
+		case rdb.ZSetKind:
+			elements := make([]sortedset.Element, len(object.ZSet))
+			for i, e := range object.ZSet {
+				elements[i] = sortedset.Element{Member: e.Member, Score: e.Score}
+			}
+			entry.Value = elements
+		default:
+			fmt.Printf("skip %s key %s\n", object.Kind, object.Key)
+			skipped++
+			return true
+		}
+		if object.Expiration != nil {
+			entry.HasTTL = true
+			entry.DeadLine = *object.Expiration
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	return entries, skipped, err
+}
+
+func writeAof(fileName string, entries []*datastore.Entry) error {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	writer := bufio.NewWriter(file)
+	for _, entry := range entries {
+		for _, cmd := range aof.EntryToCmds(entry) {
+			writer.Write(cmd)
+		}
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	return file.Sync()
+}
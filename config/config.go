@@ -2,8 +2,8 @@ package config
 
 import (
 	"bufio"
-	"fmt"
 	"io"
+	"kv_storage/logger"
 	"os"
 	"reflect"
 	"strconv"
@@ -17,12 +17,128 @@ type Config struct {
 	AofFile   string   `cfg:"aofFile"`
 	Address   string
 	IsCluster bool
+
+	AutoAofRewritePercentage int  `cfg:"auto-aof-rewrite-percentage"`
+	AutoAofRewriteMinSize    int  `cfg:"auto-aof-rewrite-min-size"`
+	AofUseSnapshotPreamble   bool `cfg:"aof-use-snapshot-preamble"`
+
+	DbFilename string `cfg:"dbfilename"`
+	Save       string `cfg:"save"`
+
+	// ShutdownTimeout is the number of seconds a shutdown waits for the
+	// running commands and the clients before it closes them
+	ShutdownTimeout int `cfg:"shutdown-timeout"`
+
+	// MaxClients is the number of clients served at once, the others are
+	// replied an error and disconnected
+	MaxClients int `cfg:"maxclients"`
+	// Timeout is the number of seconds a client may stay idle, 0 never
+	// disconnects idle clients
+	Timeout int `cfg:"timeout"`
+	// TcpKeepalive is the period in seconds of the tcp keepalive probes, 0
+	// disables them
+	TcpKeepalive int `cfg:"tcp-keepalive"`
+	// TcpBacklog is the size of the queue of the pending connections
+	TcpBacklog int `cfg:"tcp-backlog"`
+
+	// ProtoMaxMultiBulkLen limits the number of arguments of a request
+	ProtoMaxMultiBulkLen int `cfg:"proto-max-multibulk-len"`
+	// ProtoMaxBulkLen limits the size of an argument
+	ProtoMaxBulkLen int `cfg:"proto-max-bulk-len"`
+	// ClientQueryBufferLimit limits the size of a request
+	ClientQueryBufferLimit int `cfg:"client-query-buffer-limit"`
+	// ClientOutputBufferLimit lists the output buffer limits of the client
+	// classes as <class> <hard limit> <soft limit> <soft seconds> ..., every
+	// client is a normal one so the replica and pubsub limits are parsed
+	// but never applied
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"`
+
+	// RequirePass is the password of the default user, the clients must
+	// AUTH with it before running commands when it is set
+	RequirePass string `cfg:"requirepass"`
+	// MasterAuth is the password the node sends to its cluster peers, it
+	// must be their requirepass
+	MasterAuth string `cfg:"masterauth"`
+	// AclFile stores the acl users, it is loaded at startup and by ACL LOAD
+	// and written by ACL SAVE
+	AclFile string `cfg:"aclfile"`
+	// AclLogMaxLen is the number of entries ACL LOG keeps
+	AclLogMaxLen int `cfg:"acllog-max-len"`
+
+	// TlsPort is the port of the tls listener, 0 disables it. Port 0
+	// disables the plaintext listener so that only tls is accepted
+	TlsPort       int    `cfg:"tls-port"`
+	TlsCertFile   string `cfg:"tls-cert-file"`
+	TlsKeyFile    string `cfg:"tls-key-file"`
+	TlsCaCertFile string `cfg:"tls-ca-cert-file"`
+	// TlsAuthClients is yes, no or optional: whether the clients must show
+	// a certificate signed by the ca
+	TlsAuthClients string `cfg:"tls-auth-clients"`
+	// TlsCluster makes the peers talk over mutual tls, the peers are then
+	// listed with their tls ports
+	TlsCluster bool `cfg:"tls-cluster"`
+
+	// UnixSocket is the path of a unix socket accepting the clients next to
+	// the tcp listeners, UnixSocketPerm the octal permissions of its file
+	UnixSocket     string `cfg:"unixsocket"`
+	UnixSocketPerm string `cfg:"unixsocketperm"`
+
+	// LogLevel is debug, info, warning, error or nothing, the redis levels
+	// verbose and notice are info
+	LogLevel string `cfg:"loglevel"`
+	// LogFile is the file of the log, empty logs to stdout. It is reopened
+	// on SIGHUP so that it can be rotated.
+	LogFile string `cfg:"logfile"`
+	// LogFormat is logfmt or json
+	LogFormat string `cfg:"log-format"`
+
+	// SlowlogLogSlowerThan is the duration in microseconds from which a
+	// command is logged by SLOWLOG, 0 logs every command and a negative
+	// value none. SlowlogMaxLen is the number of entries kept.
+	SlowlogLogSlowerThan int `cfg:"slowlog-log-slower-than"`
+	SlowlogMaxLen        int `cfg:"slowlog-max-len"`
+
+	// LatencyMonitorThreshold is the duration in milliseconds from which the
+	// events are recorded for LATENCY LATEST and HISTORY, 0 disables it
+	LatencyMonitorThreshold int `cfg:"latency-monitor-threshold"`
+	// LatencyTracking records the latency histogram of each command
+	LatencyTracking bool `cfg:"latency-tracking"`
+	// LatencyTrackingInfoPercentiles lists the percentiles of INFO
+	// latencystats
+	LatencyTrackingInfoPercentiles string `cfg:"latency-tracking-info-percentiles"`
+}
+
+// NewDefaultConfig returns a Config filled with the defaults of the options
+// that a config file may leave out
+func NewDefaultConfig() *Config {
+	return &Config{
+		AutoAofRewritePercentage:       100,
+		AutoAofRewriteMinSize:          64 << 20,
+		DbFilename:                     "dump.snap",
+		Save:                           "3600 1 300 100 60 10000",
+		ShutdownTimeout:                10,
+		MaxClients:                     10000,
+		TcpKeepalive:                   300,
+		TcpBacklog:                     511,
+		ProtoMaxMultiBulkLen:           1024 * 1024,
+		ProtoMaxBulkLen:                512 << 20,
+		ClientQueryBufferLimit:         1 << 30,
+		ClientOutputBufferLimit:        "normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60",
+		AclLogMaxLen:                   128,
+		TlsAuthClients:                 "yes",
+		LogLevel:                       "info",
+		LogFormat:                      "logfmt",
+		SlowlogLogSlowerThan:           10000,
+		SlowlogMaxLen:                  128,
+		LatencyTracking:                true,
+		LatencyTrackingInfoPercentiles: "50 99 99.9",
+	}
 }
 
 var Properties *Config
 
 func parse(src io.Reader) *Config {
-	config := &Config{}
+	config := NewDefaultConfig()
 
 	// read config file
 	rawMap := make(map[string]string)
@@ -40,7 +156,7 @@ func parse(src io.Reader) *Config {
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		fmt.Println(err)
+		logger.Error("read config error", "err", err)
 	}
 
 	// parse format
@@ -61,7 +177,7 @@ func parse(src io.Reader) *Config {
 			case reflect.String:
 				fieldVal.SetString(value)
 			case reflect.Int:
-				intValue, err := strconv.ParseInt(value, 10, 64)
+				intValue, err := ParseSize(value)
 				if err == nil {
 					fieldVal.SetInt(intValue)
 				}
@@ -79,6 +195,28 @@ func parse(src io.Reader) *Config {
 	return config
 }
 
+// ParseSize parses an integer that may carry a memory unit, e.g. 64mb
+func ParseSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	}
+	lower := strings.ToLower(value)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(lower, unit.suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * unit.factor, nil
+		}
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
 // SetupConfig read config file and store properties into Properties
 func SetupConfig(configFilename string) {
 	file, err := os.Open(configFilename)
@@ -2,7 +2,6 @@ package datastore
 
 import (
 	"errors"
-	"fmt"
 	"kv_storage/datastruct/list"
 	"kv_storage/datastruct/sortedset"
 	"kv_storage/entity"
@@ -16,20 +15,61 @@ var (
 	ErrTypeNotMatched = errors.New("type not matched")
 )
 
+// Entry is a point-in-time copy of a key. Value holds []byte for strings,
+// [][]byte for lists and []sortedset.Element for sorted sets.
+type Entry struct {
+	Key      string
+	Value    interface{}
+	HasTTL   bool
+	DeadLine time.Time
+}
+
 type Map struct {
 	store map[string]*entity.Value
-	mx    sync.Mutex
+	// expires holds the keys of store that have a deadline, like the
+	// expires dict of redis it counts them without a scan
+	expires map[string]struct{}
+	mx      sync.Mutex
+	// snapshots are the open copy-on-write snapshots
+	snapshots []*CowSnapshot
 }
 
 func NewMap() *Map {
 	m := make(map[string]*entity.Value, 16)
-	return &Map{store: m, mx: sync.Mutex{}}
+	return &Map{store: m, expires: make(map[string]struct{}), mx: sync.Mutex{}}
+}
+
+// put stores value at key and tracks its deadline, the caller holds mx
+func (m *Map) put(key string, value *entity.Value) {
+	m.store[key] = value
+	if value.HaveLife() {
+		m.expires[key] = struct{}{}
+	} else {
+		delete(m.expires, key)
+	}
+}
+
+// remove deletes key, the caller holds mx
+func (m *Map) remove(key string) {
+	delete(m.store, key)
+	delete(m.expires, key)
 }
 
 func (m *Map) Set(key []byte, value []byte) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
-	m.store[string(key)] = entity.NewValue(value)
+	m.preserve(string(key))
+	m.put(string(key), entity.NewValue(value))
+}
+
+// SetWithDeadLine stores a string value that expires at deadLine
+func (m *Map) SetWithDeadLine(key []byte, value []byte, deadLine time.Time) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.preserve(string(key))
+	v := entity.NewValue(value)
+	v.SetDeadLine(deadLine)
+	m.put(string(key), v)
 }
 
 func (m *Map) Get(key []byte) ([]byte, bool, error) {
@@ -49,10 +89,33 @@ func (m *Map) Get(key []byte) ([]byte, bool, error) {
 	return vb, true, nil
 }
 
+// DelExpired deletes key if it expired and reports whether it did, so that
+// an expired key is counted once however many readers find it
+func (m *Map) DelExpired(key []byte) bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	v, exist := m.store[string(key)]
+	if !exist || !v.Expired() {
+		return false
+	}
+	m.preserve(string(key))
+	m.remove(string(key))
+	return true
+}
+
+// Contains reports whether key is stored, expired or not
+func (m *Map) Contains(key []byte) bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	_, exist := m.store[string(key)]
+	return exist
+}
+
 func (m *Map) Del(key []byte) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
-	delete(m.store, string(key))
+	m.preserve(string(key))
+	m.remove(string(key))
 }
 
 func (m *Map) Keys() [][]byte {
@@ -68,18 +131,108 @@ func (m *Map) Keys() [][]byte {
 	return keys
 }
 
+// keyspaceTTLSamples is the number of keys with a deadline Keyspace looks
+// at to estimate their average ttl
+const keyspaceTTLSamples = 20
+
+// Keyspace counts the keys, expired ones not deleted yet included like
+// redis, and the ones with a deadline. avgTTL is the average time left to a
+// sample of the keys with a deadline, the keys are not scanned.
+func (m *Map) Keyspace() (keys, expires int64, avgTTL time.Duration) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	now := time.Now()
+	var ttlSum time.Duration
+	var sampled, alive int64
+	// the iteration of a map begins at a random key
+	for key := range m.expires {
+		if sampled == keyspaceTTLSamples {
+			break
+		}
+		sampled++
+		if ttl := m.store[key].GetDeadLine().Sub(now); ttl > 0 {
+			ttlSum += ttl
+			alive++
+		}
+	}
+	if alive > 0 {
+		avgTTL = ttlSum / time.Duration(alive)
+	}
+	return int64(len(m.store)), int64(len(m.expires)), avgTTL
+}
+
+// ForEach calls consumer with every live key while holding the store lock,
+// iteration stops as soon as consumer returns false
+func (m *Map) ForEach(consumer func(key string, value *entity.Value) bool) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	for k, v := range m.store {
+		if v.Expired() {
+			continue
+		}
+		if !consumer(k, v) {
+			return
+		}
+	}
+}
+
+// Snapshot copies every live key while holding the store lock
+func (m *Map) Snapshot() []*Entry {
+	var entries []*Entry
+	m.ForEach(func(key string, value *entity.Value) bool {
+		if entry := entryOf(key, value); entry != nil {
+			entries = append(entries, entry)
+		}
+		return true
+	})
+	return entries
+}
+
+// Restore stores a key copied by Snapshot, replacing any existing value
+func (m *Map) Restore(entry *Entry) error {
+	var value *entity.Value
+	switch v := entry.Value.(type) {
+	case []byte:
+		value = entity.NewValue(v)
+	case [][]byte:
+		l := list.NewList()
+		l.Rpush(v)
+		value = entity.NewValue(l)
+	case []sortedset.Element:
+		zset := sortedset.Make()
+		for _, element := range v {
+			zset.Add(element.Member, element.Score)
+		}
+		value = entity.NewValue(zset)
+	default:
+		return ErrTypeNotMatched
+	}
+	if entry.HasTTL {
+		value.SetDeadLine(entry.DeadLine)
+	}
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.preserve(entry.Key)
+	m.put(entry.Key, value)
+	return nil
+}
+
 func (m *Map) SetTTL(key []byte, second int) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(string(key))
 	value := m.store[string(key)]
 	value.SetTTL(time.Second * time.Duration(second))
+	m.expires[string(key)] = struct{}{}
 }
 
 func (m *Map) SetDeadLine(key []byte, deadLine time.Time) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(string(key))
 	value := m.store[string(key)]
 	value.SetDeadLine(deadLine)
+	m.expires[string(key)] = struct{}{}
 }
 
 func (m *Map) GetLeftLife(key []byte) int64 {
@@ -98,21 +251,24 @@ func (m *Map) GetLeftLife(key []byte) int64 {
 func (m *Map) Persist(key []byte) int64 {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(string(key))
 	v := m.store[string(key)]
 	if !v.HaveLife() {
 		return 0
 	}
 	v.Persist()
+	delete(m.expires, string(key))
 	return 1
 }
 
 func (m *Map) Lpush(key []byte, values [][]byte) int64 {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(string(key))
 	v, exist := m.store[string(key)]
 	if !exist {
 		v = &entity.Value{V: list.NewList()}
-		m.store[string(key)] = v
+		m.put(string(key), v)
 	}
 	list, ok := v.V.(*list.List)
 	if !ok {
@@ -125,10 +281,11 @@ func (m *Map) Lpush(key []byte, values [][]byte) int64 {
 func (m *Map) Rpush(key []byte, values [][]byte) int64 {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(string(key))
 	v, exist := m.store[string(key)]
 	if !exist {
 		v = &entity.Value{V: list.NewList()}
-		m.store[string(key)] = v
+		m.put(string(key), v)
 	}
 	list, ok := v.V.(*list.List)
 	if !ok {
@@ -183,6 +340,7 @@ func (m *Map) Lindex(key string, index int) ([]byte, error) {
 func (m *Map) Linsert(key string, before bool, pivot, value string) (int, error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(key)
 	v, exist := m.store[string(key)]
 	if !exist {
 		return 0, nil
@@ -197,6 +355,7 @@ func (m *Map) Linsert(key string, before bool, pivot, value string) (int, error)
 func (m *Map) Lrem(key string, count int, value string) (int, error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(key)
 	v, exist := m.store[string(key)]
 	if !exist {
 		return 0, nil
@@ -207,7 +366,7 @@ func (m *Map) Lrem(key string, count int, value string) (int, error) {
 	}
 	removedNum := list.Lrem(count, value)
 	if list.GetLength() == 0 {
-		delete(m.store, key)
+		m.remove(key)
 	}
 	return removedNum, nil
 }
@@ -215,6 +374,7 @@ func (m *Map) Lrem(key string, count int, value string) (int, error) {
 func (m *Map) Ltrim(key string, start, stop int) error {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(key)
 	v, exist := m.store[string(key)]
 	if !exist {
 		return nil
@@ -225,7 +385,7 @@ func (m *Map) Ltrim(key string, start, stop int) error {
 	}
 	list.Ltrim(start, stop)
 	if list.GetLength() == 0 {
-		delete(m.store, key)
+		m.remove(key)
 	}
 	return nil
 }
@@ -233,6 +393,7 @@ func (m *Map) Ltrim(key string, start, stop int) error {
 func (m *Map) Lset(key string, index int, value string) error {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(key)
 	v, exist := m.store[string(key)]
 	if !exist {
 		return errors.New("key not exist")
@@ -247,6 +408,7 @@ func (m *Map) Lset(key string, index int, value string) error {
 func (m *Map) Lpop(key string, count int) ([][]byte, error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(key)
 	v, exist := m.store[string(key)]
 	if !exist {
 		return nil, nil
@@ -257,7 +419,7 @@ func (m *Map) Lpop(key string, count int) ([][]byte, error) {
 	}
 	poped := list.Lpop(count)
 	if list.GetLength() == 0 {
-		delete(m.store, key)
+		m.remove(key)
 	}
 	return poped, nil
 }
@@ -265,6 +427,7 @@ func (m *Map) Lpop(key string, count int) ([][]byte, error) {
 func (m *Map) Rpop(key string, count int) ([][]byte, error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(key)
 	v, exist := m.store[string(key)]
 	if !exist {
 		return nil, nil
@@ -275,7 +438,7 @@ func (m *Map) Rpop(key string, count int) ([][]byte, error) {
 	}
 	poped := list.Rpop(count)
 	if list.GetLength() == 0 {
-		delete(m.store, key)
+		m.remove(key)
 	}
 	return poped, nil
 }
@@ -284,10 +447,11 @@ func (m *Map) Rpop(key string, count int) ([][]byte, error) {
 func (m *Map) Zadd(scores []float64, names []string, key string) (int, error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(key)
 	zset, exist := m.store[key]
 	if !exist {
 		zset = entity.NewValue(sortedset.Make())
-		m.store[key] = zset
+		m.put(key, zset)
 	}
 	sortedSet, ok := zset.V.(*sortedset.SortedSet)
 	if !ok {
@@ -302,12 +466,13 @@ func (m *Map) Zadd(scores []float64, names []string, key string) (int, error) {
 	return insertedNum, nil
 }
 
-func (m *Map) Zrange(key string, start, stop int, desc, withScore bool) ([][]byte, error) {
+// Zrange returns copies of the elements ranked from start to stop
+func (m *Map) Zrange(key string, start, stop int, desc bool) ([]sortedset.Element, error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	zset, exist := m.store[key]
 	if !exist {
-		return [][]byte{}, nil
+		return []sortedset.Element{}, nil
 	}
 	sortedSet, ok := zset.V.(*sortedset.SortedSet)
 	if !ok {
@@ -315,32 +480,18 @@ func (m *Map) Zrange(key string, start, stop int, desc, withScore bool) ([][]byt
 	}
 	elems := sortedSet.Range(int64(start), int64(stop), desc)
 	if elems == nil {
-		return [][]byte{}, nil
+		return []sortedset.Element{}, nil
 	}
-	size := len(elems)
-	if withScore {
-		size *= 2
-	}
-	rs := make([][]byte, size)
-	i := 0
-	for _, elem := range elems {
-		rs[i] = []byte(elem.Member)
-		if withScore {
-			rs[i+1] = []byte(fmt.Sprintf("%v", elem.Score))
-			i += 2
-		} else {
-			i++
-		}
-	}
-	return rs, nil
+	return copyElements(elems), nil
 }
 
-func (m *Map) ZrangeByScore(key string, min, max *sortedset.ScoreBorder, withScore, desc bool, offset, count int) ([][]byte, error) {
+// ZrangeByScore returns copies of the elements with scores between min and max
+func (m *Map) ZrangeByScore(key string, min, max *sortedset.ScoreBorder, desc bool, offset, count int) ([]sortedset.Element, error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
 	zset, exist := m.store[key]
 	if !exist {
-		return [][]byte{}, nil
+		return []sortedset.Element{}, nil
 	}
 	sortedSet, ok := zset.V.(*sortedset.SortedSet)
 	if !ok {
@@ -348,24 +499,9 @@ func (m *Map) ZrangeByScore(key string, min, max *sortedset.ScoreBorder, withSco
 	}
 	elems := sortedSet.RangeByScore(min, max, int64(offset), int64(count), desc)
 	if elems == nil {
-		return [][]byte{}, nil
+		return []sortedset.Element{}, nil
 	}
-	size := len(elems)
-	if withScore {
-		size *= 2
-	}
-	rs := make([][]byte, size)
-	i := 0
-	for _, elem := range elems {
-		rs[i] = []byte(elem.Member)
-		if withScore {
-			rs[i+1] = []byte(fmt.Sprintf("%v", elem.Score))
-			i += 2
-		} else {
-			i++
-		}
-	}
-	return rs, nil
+	return copyElements(elems), nil
 }
 
 func (m *Map) Zcard(key string) (int64, error) {
@@ -385,6 +521,7 @@ func (m *Map) Zcard(key string) (int64, error) {
 func (m *Map) Zrem(key string, names [][]byte) (int64, error) {
 	m.mx.Lock()
 	defer m.mx.Unlock()
+	m.preserve(key)
 	zset, exist := m.store[key]
 	if !exist {
 		return 0, nil
@@ -400,7 +537,7 @@ func (m *Map) Zrem(key string, names [][]byte) (int64, error) {
 		}
 	}
 	if sortedSet.Len() == 0 {
-		delete(m.store, key)
+		m.remove(key)
 	}
 	return removedNum, nil
 }
@@ -440,3 +577,13 @@ func (m *Map) Zrank(key, name []byte, desc bool) (int64, error) {
 	}
 	return sortedSet.GetRank(string(name), desc), nil
 }
+
+// copyElements copies elements so they can be used after the store lock
+// is released
+func copyElements(elements []*sortedset.Element) []sortedset.Element {
+	copied := make([]sortedset.Element, len(elements))
+	for i, element := range elements {
+		copied[i] = *element
+	}
+	return copied
+}
@@ -0,0 +1,78 @@
+package datastore
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestKeyspace(t *testing.T) {
+	m := NewMap()
+	hour := time.Now().Add(time.Hour)
+	tests := []struct {
+		name          string
+		op            func()
+		keys, expires int64
+	}{
+		{"set", func() { m.Set([]byte("a"), []byte("1")) }, 1, 0},
+		{"set with deadline", func() { m.SetWithDeadLine([]byte("b"), []byte("2"), hour) }, 2, 1},
+		{"deadline", func() { m.SetDeadLine([]byte("a"), hour) }, 2, 2},
+		{"persist", func() { m.Persist([]byte("a")) }, 2, 1},
+		{"set over a deadline", func() { m.Set([]byte("b"), []byte("3")) }, 2, 0},
+		// an expired key counts until it is deleted, like in redis
+		{"expired", func() { m.SetWithDeadLine([]byte("c"), []byte("4"), time.Now().Add(-time.Second)) }, 3, 1},
+		{"delete expired", func() { m.DelExpired([]byte("c")) }, 2, 0},
+		{"rpush", func() { m.Rpush([]byte("l"), [][]byte{[]byte("x"), []byte("y")}) }, 3, 0},
+		{"ttl", func() { m.SetTTL([]byte("l"), 100) }, 3, 1},
+		{"pop the last element", func() { m.Rpop("l", 2) }, 2, 0},
+		{"zadd", func() { m.Zadd([]float64{1}, []string{"x"}, "z") }, 3, 0},
+		{"zset deadline", func() { m.SetDeadLine([]byte("z"), hour) }, 3, 1},
+		{"remove the last member", func() { m.Zrem("z", [][]byte{[]byte("x")}) }, 2, 0},
+		{"restore", func() {
+			m.Restore(&Entry{Key: "r", Value: []byte("5"), HasTTL: true, DeadLine: hour})
+		}, 3, 1},
+		{"restore over a deadline", func() { m.Restore(&Entry{Key: "r", Value: []byte("6")}) }, 3, 0},
+		{"del", func() { m.Del([]byte("r")) }, 2, 0},
+	}
+	for _, tt := range tests {
+		tt.op()
+		keys, expires, _ := m.Keyspace()
+		if keys != tt.keys || expires != tt.expires {
+			t.Errorf("after %s: keys=%d expires=%d, want keys=%d expires=%d", tt.name, keys, expires, tt.keys, tt.expires)
+		}
+	}
+}
+
+func TestKeyspaceAvgTTL(t *testing.T) {
+	tests := []struct {
+		name string
+		ttls []time.Duration
+		want time.Duration
+	}{
+		{"none", nil, 0},
+		{"one", []time.Duration{time.Hour}, time.Hour},
+		{"average", []time.Duration{time.Hour, 3 * time.Hour}, 2 * time.Hour},
+		{"expired ones left out", []time.Duration{-time.Hour, time.Hour}, time.Hour},
+		{"sampled", repeat(time.Hour, 10*keyspaceTTLSamples), time.Hour},
+	}
+	for _, tt := range tests {
+		m := NewMap()
+		m.Set([]byte("persistent"), []byte("v"))
+		now := time.Now()
+		for i, ttl := range tt.ttls {
+			m.SetWithDeadLine([]byte(strconv.Itoa(i)), []byte("v"), now.Add(ttl))
+		}
+		_, _, got := m.Keyspace()
+		if got > tt.want || got < tt.want-time.Second {
+			t.Errorf("%s: avg ttl %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func repeat(ttl time.Duration, n int) []time.Duration {
+	ttls := make([]time.Duration, n)
+	for i := range ttls {
+		ttls[i] = ttl
+	}
+	return ttls
+}
@@ -0,0 +1,145 @@
+package datastore
+
+import (
+	"kv_storage/datastruct/list"
+	"kv_storage/datastruct/sortedset"
+	"kv_storage/entity"
+)
+
+// Entries is a sequence of entries, consumer is called with each of them
+// until it returns false
+type Entries interface {
+	ForEach(consumer func(entry *Entry) bool)
+}
+
+// EntryList is a sequence of entries held in memory
+type EntryList []*Entry
+
+func (l EntryList) ForEach(consumer func(entry *Entry) bool) {
+	for _, entry := range l {
+		if !consumer(entry) {
+			return
+		}
+	}
+}
+
+// CowSnapshot is a copy-on-write snapshot of a Map. Only the keys are
+// copied when it begins, the values are copied one at a time as it is
+// read, and a key written before being read keeps its value as it was when
+// the snapshot began. The writers only pay for the keys they modify while
+// the snapshot is open, it must be closed once read.
+type CowSnapshot struct {
+	m    *Map
+	keys []string
+	// done are the keys read or written since the snapshot began, saved
+	// holds the values of the ones written before being read
+	done  map[string]struct{}
+	saved map[string]*Entry
+}
+
+// BeginSnapshot starts a snapshot of the live keys. The caller makes sure
+// that no command is half applied when it begins.
+func (m *Map) BeginSnapshot() *CowSnapshot {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	s := &CowSnapshot{
+		m:     m,
+		keys:  make([]string, 0, len(m.store)),
+		done:  make(map[string]struct{}),
+		saved: make(map[string]*Entry),
+	}
+	for k := range m.store {
+		s.keys = append(s.keys, k)
+	}
+	m.snapshots = append(m.snapshots, s)
+	return s
+}
+
+// Len returns the number of keys when the snapshot began, expired ones
+// included
+func (s *CowSnapshot) Len() int {
+	return len(s.keys)
+}
+
+// ForEach calls consumer with the copy of every live key of the snapshot,
+// the store is only locked while a key is copied. ForEach may be called
+// once.
+func (s *CowSnapshot) ForEach(consumer func(entry *Entry) bool) {
+	for _, key := range s.keys {
+		entry := s.read(key)
+		if entry == nil {
+			continue
+		}
+		if !consumer(entry) {
+			return
+		}
+	}
+}
+
+func (s *CowSnapshot) read(key string) *Entry {
+	s.m.mx.Lock()
+	defer s.m.mx.Unlock()
+	if entry, ok := s.saved[key]; ok {
+		delete(s.saved, key)
+		return entry
+	}
+	if _, ok := s.done[key]; ok {
+		return nil
+	}
+	s.done[key] = struct{}{}
+	value, exist := s.m.store[key]
+	if !exist || value.Expired() {
+		return nil
+	}
+	return entryOf(key, value)
+}
+
+// Close stops the copies of the values written
+func (s *CowSnapshot) Close() {
+	s.m.mx.Lock()
+	defer s.m.mx.Unlock()
+	for i, snapshot := range s.m.snapshots {
+		if snapshot == s {
+			s.m.snapshots = append(s.m.snapshots[:i], s.m.snapshots[i+1:]...)
+			break
+		}
+	}
+	s.done, s.saved = nil, nil
+}
+
+// preserve saves the value of key for the open snapshots that have not
+// read it yet, it is called with the store locked before key is written
+func (m *Map) preserve(key string) {
+	for _, s := range m.snapshots {
+		if _, ok := s.done[key]; ok {
+			continue
+		}
+		s.done[key] = struct{}{}
+		if value, exist := m.store[key]; exist && !value.Expired() {
+			if entry := entryOf(key, value); entry != nil {
+				s.saved[key] = entry
+			}
+		}
+	}
+}
+
+// entryOf copies a value, the copy shares the immutable byte slices with
+// the store so only the containers of lists and sorted sets are cloned
+func entryOf(key string, value *entity.Value) *Entry {
+	entry := &Entry{Key: key}
+	switch v := value.V.(type) {
+	case []byte:
+		entry.Value = v
+	case *list.List:
+		entry.Value = v.Lrange(0, -1)
+	case *sortedset.SortedSet:
+		entry.Value = copyElements(v.Range(0, -1, false))
+	default:
+		return nil
+	}
+	if value.HaveLife() {
+		entry.HasTTL = true
+		entry.DeadLine = value.GetDeadLine()
+	}
+	return entry
+}
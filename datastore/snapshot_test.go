@@ -0,0 +1,121 @@
+package datastore
+
+import (
+	"kv_storage/datastruct/sortedset"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSampleMap returns a map holding a string, a list and a sorted set
+func newSampleMap() *Map {
+	m := NewMap()
+	m.Set([]byte("string"), []byte("v1"))
+	m.Rpush([]byte("list"), [][]byte{[]byte("a"), []byte("b")})
+	m.Zadd([]float64{1, 2}, []string{"a", "b"}, "zset")
+	return m
+}
+
+func sampleSnapshot() map[string]interface{} {
+	return map[string]interface{}{
+		"string": []byte("v1"),
+		"list":   [][]byte{[]byte("a"), []byte("b")},
+		"zset":   []sortedset.Element{{Member: "a", Score: 1}, {Member: "b", Score: 2}},
+	}
+}
+
+func readAll(entries Entries) map[string]interface{} {
+	values := make(map[string]interface{})
+	entries.ForEach(func(entry *Entry) bool {
+		values[entry.Key] = entry.Value
+		return true
+	})
+	return values
+}
+
+func TestCowSnapshot(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(m *Map)
+	}{
+		{"set", func(m *Map) { m.Set([]byte("string"), []byte("v2")) }},
+		{"del", func(m *Map) { m.Del([]byte("string")); m.Del([]byte("list")); m.Del([]byte("zset")) }},
+		{"new key", func(m *Map) { m.Set([]byte("new"), []byte("v")) }},
+		{"expire", func(m *Map) { m.SetDeadLine([]byte("string"), time.Now().Add(-time.Second)) }},
+		{"lpush", func(m *Map) { m.Lpush([]byte("list"), [][]byte{[]byte("x")}) }},
+		{"lset", func(m *Map) { m.Lset("list", 0, "x") }},
+		{"lpop", func(m *Map) { m.Lpop("list", 2) }},
+		{"ltrim", func(m *Map) { m.Ltrim("list", 1, 1) }},
+		{"zadd", func(m *Map) { m.Zadd([]float64{5}, []string{"a"}, "zset") }},
+		{"zrem", func(m *Map) { m.Zrem("zset", [][]byte{[]byte("a")}) }},
+		{"restore", func(m *Map) { m.Restore(&Entry{Key: "list", Value: []byte("now a string")}) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newSampleMap()
+			snap := m.BeginSnapshot()
+			tt.write(m)
+			got := readAll(snap)
+			snap.Close()
+			if want := sampleSnapshot(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("snapshot holds %q, want %q", got, want)
+			}
+			if len(m.snapshots) != 0 {
+				t.Fatalf("%d snapshots open after close", len(m.snapshots))
+			}
+		})
+	}
+}
+
+// TestCowSnapshotReadFirst checks that a write after a key has been read
+// is not copied again
+func TestCowSnapshotReadFirst(t *testing.T) {
+	m := newSampleMap()
+	snap := m.BeginSnapshot()
+	defer snap.Close()
+	var got []string
+	snap.ForEach(func(entry *Entry) bool {
+		got = append(got, entry.Key)
+		m.Set([]byte(entry.Key), []byte("changed"))
+		return true
+	})
+	sort.Strings(got)
+	if want := []string{"list", "string", "zset"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshot holds %v, want %v", got, want)
+	}
+	if len(snap.saved) != 0 {
+		t.Fatalf("%d values copied for keys already read", len(snap.saved))
+	}
+}
+
+func TestCowSnapshotConcurrentWrites(t *testing.T) {
+	m := NewMap()
+	for i := 0; i < 1000; i++ {
+		key := []byte("key:" + strconv.Itoa(i))
+		m.Set(key, key)
+	}
+	snap := m.BeginSnapshot()
+	defer snap.Close()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			m.Set([]byte("key:"+strconv.Itoa(i)), []byte("changed"))
+			m.Del([]byte("key:" + strconv.Itoa(999-i)))
+		}
+	}()
+	got := readAll(snap)
+	wg.Wait()
+	if len(got) != 1000 {
+		t.Fatalf("snapshot holds %d keys, want 1000", len(got))
+	}
+	for key, value := range got {
+		if string(value.([]byte)) != key {
+			t.Fatalf("snapshot holds %s = %s", key, value)
+		}
+	}
+}
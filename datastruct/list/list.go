@@ -324,7 +324,7 @@ func (l *List) Ltrim(start, stop int) {
 	}
 	var begin, end *ListNode
 	rank := 0
-	for node := l.head; node.next != nil; node = node.next {
+	for node := l.head; node != nil; node = node.next {
 		if rank == start {
 			begin = node
 		}
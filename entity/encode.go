@@ -0,0 +1,213 @@
+package entity
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+// protocol versions a connection negotiates with HELLO
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// encodable is implemented by the replies of this package, they encode
+// themselves in either protocol version without building intermediate
+// byte slices
+type encodable interface {
+	encode(e *encoder, protocol int)
+}
+
+const (
+	// encoderBufferSize is the size the buffer of an encoder is flushed at
+	encoderBufferSize = 4 * 1024
+	// largeBulkSize bulk strings of this size or larger are written straight
+	// from the reply instead of being copied into the buffer
+	largeBulkSize = 512
+)
+
+// encoder collects the small pieces of a reply such as headers and CRLFs
+// in a pooled buffer and writes them to w in few calls
+type encoder struct {
+	w   io.Writer
+	buf []byte
+	// scratch holds a number formatted before its length is written
+	scratch []byte
+	n       int64
+	err     error
+}
+
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		return &encoder{buf: make([]byte, 0, encoderBufferSize), scratch: make([]byte, 0, 32)}
+	},
+}
+
+func getEncoder(w io.Writer) *encoder {
+	e := encoderPool.Get().(*encoder)
+	e.w = w
+	return e
+}
+
+// release flushes the buffer and puts the encoder back to the pool, it
+// returns the number of bytes written and the first write error
+func (e *encoder) release() (int64, error) {
+	e.flush()
+	n, err := e.n, e.err
+	e.w, e.n, e.err = nil, 0, nil
+	// a buffer grown by a very long status is left to the garbage collector
+	if cap(e.buf) <= 4*encoderBufferSize {
+		e.buf = e.buf[:0]
+		encoderPool.Put(e)
+	}
+	return n, err
+}
+
+func (e *encoder) flush() {
+	if len(e.buf) > 0 {
+		e.write(e.buf)
+		e.buf = e.buf[:0]
+	}
+}
+
+func (e *encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	n, err := e.w.Write(p)
+	e.n += int64(n)
+	e.err = err
+}
+
+// reserve flushes the buffer unless n more bytes fit in it
+func (e *encoder) reserve(n int) {
+	if len(e.buf)+n > encoderBufferSize {
+		e.flush()
+	}
+}
+
+func (e *encoder) writeBytes(p []byte) {
+	if len(p) >= largeBulkSize {
+		e.flush()
+		e.write(p)
+		return
+	}
+	e.reserve(len(p))
+	e.buf = append(e.buf, p...)
+}
+
+func (e *encoder) writeString(s string) {
+	e.reserve(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) writeCRLF() {
+	e.reserve(2)
+	e.buf = append(e.buf, '\r', '\n')
+}
+
+// writeHeader writes a type byte followed by a length or an integer
+func (e *encoder) writeHeader(prefix byte, n int64) {
+	e.reserve(24)
+	e.buf = append(e.buf, prefix)
+	e.buf = strconv.AppendInt(e.buf, n, 10)
+	e.buf = append(e.buf, '\r', '\n')
+}
+
+func (e *encoder) writeLine(prefix byte, s string) {
+	e.reserve(1)
+	e.buf = append(e.buf, prefix)
+	e.writeString(s)
+	e.writeCRLF()
+}
+
+// writeBulk writes a bulk string, nil is the null of the protocol version
+func (e *encoder) writeBulk(arg []byte, protocol int) {
+	if arg == nil {
+		e.writeNull(protocol)
+		return
+	}
+	e.writeHeader('$', int64(len(arg)))
+	e.writeBytes(arg)
+	e.writeCRLF()
+}
+
+func (e *encoder) writeNull(protocol int) {
+	if protocol == RESP3 {
+		e.writeBytes(nullBytes)
+	} else {
+		e.writeBytes(nullBulkBytes)
+	}
+}
+
+// writeReply writes a nested reply, replies of other packages are written
+// as their RESP2 bytes
+func (e *encoder) writeReply(reply redis.Reply, protocol int) {
+	if r, ok := reply.(encodable); ok {
+		r.encode(e, protocol)
+		return
+	}
+	e.writeBytes(reply.ToBytes())
+}
+
+func (e *encoder) writeReplies(replies []redis.Reply, protocol int) {
+	for _, reply := range replies {
+		e.writeReply(reply, protocol)
+	}
+}
+
+// Write writes reply to w in the given protocol version, large replies are
+// written without copying their bulk strings
+func Write(w io.Writer, reply redis.Reply, protocol int) (int64, error) {
+	e := getEncoder(w)
+	e.writeReply(reply, protocol)
+	return e.release()
+}
+
+// writeTo implements the WriteTo methods of the replies, they encode RESP2
+func writeTo(w io.Writer, reply encodable) (int64, error) {
+	e := getEncoder(w)
+	reply.encode(e, RESP2)
+	return e.release()
+}
+
+// Encode marshals reply in the given protocol version
+func Encode(reply redis.Reply, protocol int) []byte {
+	if protocol != RESP3 {
+		return reply.ToBytes()
+	}
+	var buf bytes.Buffer
+	Write(&buf, reply, protocol)
+	return buf.Bytes()
+}
+
+func toBytes(reply encodable) []byte {
+	var buf bytes.Buffer
+	writeTo(&buf, reply)
+	return buf.Bytes()
+}
+
+var nullBytes = []byte("_\r\n")
+
+// FormatDouble formats f the way redis does, the shortest representation
+// that parses back to f and inf, -inf or nan for the special values
+func FormatDouble(f float64) string {
+	return string(appendDouble(nil, f))
+}
+
+func appendDouble(dst []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(dst, "inf"...)
+	case math.IsInf(f, -1):
+		return append(dst, "-inf"...)
+	case math.IsNaN(f):
+		return append(dst, "nan"...)
+	}
+	return strconv.AppendFloat(dst, f, 'g', -1, 64)
+}
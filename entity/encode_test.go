@@ -0,0 +1,223 @@
+package entity
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+var (
+	sampleValue = []byte(strings.Repeat("v", 16))
+	sampleLarge = []byte(strings.Repeat("v", 64*1024))
+	// sampleFields look like the reply of hello
+	sampleFields = []redis.Reply{
+		MakeBulkReply([]byte("server")), MakeBulkReply([]byte("kv_storage")),
+		MakeBulkReply([]byte("proto")), MakeIntReply(3),
+	}
+)
+
+// sampleMget looks like the reply of mget of 100 keys
+func sampleMget() [][]byte {
+	args := make([][]byte, 100)
+	for i := range args {
+		args[i] = sampleValue
+	}
+	return args
+}
+
+// sampleLrange looks like the reply of lrange of 1000 items of 1KB
+func sampleLrange() [][]byte {
+	args := make([][]byte, 1000)
+	for i := range args {
+		args[i] = []byte(strings.Repeat("v", 1024))
+	}
+	return args
+}
+
+// sampleZrange looks like the reply of zrange withscores of 100 members
+func sampleZrange() []redis.Reply {
+	var pairs []redis.Reply
+	for i := 0; i < 100; i++ {
+		pairs = append(pairs, MakeBulkReply([]byte("member:"+strconv.Itoa(i))), MakeDoubleReply(float64(i)+0.5))
+	}
+	return pairs
+}
+
+// sampleReplies are replies of every type
+var sampleReplies = []struct {
+	name  string
+	reply redis.Reply
+}{
+	{"status", MakeStatusReply("Background saving started")},
+	{"ok", MakeOkReply()},
+	{"pong", &PongReply{}},
+	{"queued", MakeQueuedReply()},
+	{"no-reply", &NoReply{}},
+	{"int", MakeIntReply(1234567)},
+	{"error", MakeErrReply("ERR wrong number of arguments for 'get' command")},
+	{"annotation", MakeAnnotationReply("TS:1792424962098:1")},
+	{"bulk", MakeBulkReply(sampleValue)},
+	{"bulk-64k", MakeBulkReply(sampleLarge)},
+	{"null-bulk", MakeNullBulkReply()},
+	{"empty-multi-bulk", MakeEmptyMultiBulkReply()},
+	{"multi-bulk-mget-100", MakeMultiBulkReply(sampleMget())},
+	{"multi-bulk-lrange-1000x1k", MakeMultiBulkReply(sampleLrange())},
+	{"multi-raw", MakeMultiRawReply(sampleFields)},
+	{"map", MakeMapReply(sampleFields)},
+	{"set", MakeSetReply(sampleFields)},
+	{"push", MakePushReply(sampleFields)},
+	{"pairs-zrange-100", MakePairsReply(sampleZrange())},
+	{"double", MakeDoubleReply(3.14159)},
+	{"bool", MakeBoolReply(true)},
+	{"big-number", MakeBigNumberReply("3492890328409238509324850943850943825024385")},
+	{"verbatim", MakeVerbatimReply("txt", sampleValue)},
+}
+
+func TestWrite(t *testing.T) {
+	for _, tt := range sampleReplies {
+		for _, protocol := range []int{RESP2, RESP3} {
+			var buf bytes.Buffer
+			n, err := Write(&buf, tt.reply, protocol)
+			if err != nil {
+				t.Fatalf("%s resp%d: %v", tt.name, protocol, err)
+			}
+			if n != int64(buf.Len()) {
+				t.Errorf("%s resp%d: wrote %d bytes, returned %d", tt.name, protocol, buf.Len(), n)
+			}
+			if want := tt.reply.ToBytes(); protocol == RESP2 && !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%s resp2: wrote %q, want %q", tt.name, buf.Bytes(), want)
+			}
+		}
+	}
+}
+
+// TestWriteAllocs checks that writing a reply to a buffered writer never
+// allocates, whatever its type
+func TestWriteAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not counted under the race detector")
+	}
+	writer := bufio.NewWriterSize(io.Discard, 16*1024)
+	for _, tt := range sampleReplies {
+		for _, protocol := range []int{RESP2, RESP3} {
+			allocs := testing.AllocsPerRun(100, func() {
+				Write(writer, tt.reply, protocol)
+			})
+			if allocs != 0 {
+				t.Errorf("%s resp%d: %v allocations per write, want 0", tt.name, protocol, allocs)
+			}
+		}
+	}
+}
+
+// benchmarkWrite writes reply to a buffered writer in both protocol versions
+func benchmarkWrite(b *testing.B, reply redis.Reply) {
+	writer := bufio.NewWriterSize(io.Discard, 16*1024)
+	for _, protocol := range []int{RESP2, RESP3} {
+		b.Run("resp"+strconv.Itoa(protocol), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				Write(writer, reply, protocol)
+			}
+		})
+	}
+}
+
+func BenchmarkWriteStatus(b *testing.B) {
+	benchmarkWrite(b, MakeStatusReply("Background saving started"))
+}
+
+func BenchmarkWriteOk(b *testing.B) {
+	benchmarkWrite(b, MakeOkReply())
+}
+
+func BenchmarkWritePong(b *testing.B) {
+	benchmarkWrite(b, &PongReply{})
+}
+
+func BenchmarkWriteQueued(b *testing.B) {
+	benchmarkWrite(b, MakeQueuedReply())
+}
+
+func BenchmarkWriteNoReply(b *testing.B) {
+	benchmarkWrite(b, &NoReply{})
+}
+
+func BenchmarkWriteInt(b *testing.B) {
+	benchmarkWrite(b, MakeIntReply(1234567))
+}
+
+func BenchmarkWriteError(b *testing.B) {
+	benchmarkWrite(b, MakeErrReply("ERR wrong number of arguments for 'get' command"))
+}
+
+func BenchmarkWriteAnnotation(b *testing.B) {
+	benchmarkWrite(b, MakeAnnotationReply("TS:1792424962098:1"))
+}
+
+func BenchmarkWriteBulk(b *testing.B) {
+	b.Run("16b", func(b *testing.B) {
+		benchmarkWrite(b, MakeBulkReply(sampleValue))
+	})
+	b.Run("64k", func(b *testing.B) {
+		benchmarkWrite(b, MakeBulkReply(sampleLarge))
+	})
+}
+
+func BenchmarkWriteNullBulk(b *testing.B) {
+	benchmarkWrite(b, MakeNullBulkReply())
+}
+
+func BenchmarkWriteEmptyMultiBulk(b *testing.B) {
+	benchmarkWrite(b, MakeEmptyMultiBulkReply())
+}
+
+func BenchmarkWriteMultiBulk(b *testing.B) {
+	b.Run("mget-100", func(b *testing.B) {
+		benchmarkWrite(b, MakeMultiBulkReply(sampleMget()))
+	})
+	b.Run("lrange-1000x1k", func(b *testing.B) {
+		benchmarkWrite(b, MakeMultiBulkReply(sampleLrange()))
+	})
+}
+
+func BenchmarkWriteMultiRaw(b *testing.B) {
+	benchmarkWrite(b, MakeMultiRawReply(sampleFields))
+}
+
+func BenchmarkWriteMap(b *testing.B) {
+	benchmarkWrite(b, MakeMapReply(sampleFields))
+}
+
+func BenchmarkWriteSet(b *testing.B) {
+	benchmarkWrite(b, MakeSetReply(sampleFields))
+}
+
+func BenchmarkWritePush(b *testing.B) {
+	benchmarkWrite(b, MakePushReply(sampleFields))
+}
+
+func BenchmarkWritePairs(b *testing.B) {
+	benchmarkWrite(b, MakePairsReply(sampleZrange()))
+}
+
+func BenchmarkWriteDouble(b *testing.B) {
+	benchmarkWrite(b, MakeDoubleReply(3.14159))
+}
+
+func BenchmarkWriteBool(b *testing.B) {
+	benchmarkWrite(b, MakeBoolReply(true))
+}
+
+func BenchmarkWriteBigNumber(b *testing.B) {
+	benchmarkWrite(b, MakeBigNumberReply("3492890328409238509324850943850943825024385"))
+}
+
+func BenchmarkWriteVerbatim(b *testing.B) {
+	benchmarkWrite(b, MakeVerbatimReply("txt", sampleValue))
+}
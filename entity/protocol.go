@@ -1,5 +1,7 @@
 package entity
 
+import "io"
+
 // PongReply is +PONG
 type PongReply struct{}
 
@@ -10,6 +12,15 @@ func (r *PongReply) ToBytes() []byte {
 	return pongBytes
 }
 
+// WriteTo writes the reply to w
+func (r *PongReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *PongReply) encode(e *encoder, protocol int) {
+	e.writeBytes(pongBytes)
+}
+
 // OkReply is +OK
 type OkReply struct{}
 
@@ -20,6 +31,15 @@ func (r *OkReply) ToBytes() []byte {
 	return okBytes
 }
 
+// WriteTo writes the reply to w
+func (r *OkReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *OkReply) encode(e *encoder, protocol int) {
+	e.writeBytes(okBytes)
+}
+
 var theOkReply = new(OkReply)
 
 // MakeOkReply returns a ok protocol
@@ -37,6 +57,15 @@ func (r *NullBulkReply) ToBytes() []byte {
 	return nullBulkBytes
 }
 
+// WriteTo writes the reply to w
+func (r *NullBulkReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *NullBulkReply) encode(e *encoder, protocol int) {
+	e.writeNull(protocol)
+}
+
 // MakeNullBulkReply creates a new NullBulkReply
 func MakeNullBulkReply() *NullBulkReply {
 	return &NullBulkReply{}
@@ -52,6 +81,15 @@ func (r *EmptyMultiBulkReply) ToBytes() []byte {
 	return emptyMultiBulkBytes
 }
 
+// WriteTo writes the reply to w
+func (r *EmptyMultiBulkReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *EmptyMultiBulkReply) encode(e *encoder, protocol int) {
+	e.writeBytes(emptyMultiBulkBytes)
+}
+
 // MakeEmptyMultiBulkReply creates EmptyMultiBulkReply
 func MakeEmptyMultiBulkReply() *EmptyMultiBulkReply {
 	return &EmptyMultiBulkReply{}
@@ -67,6 +105,14 @@ func (r *NoReply) ToBytes() []byte {
 	return noBytes
 }
 
+// WriteTo writes nothing
+func (r *NoReply) WriteTo(w io.Writer) (int64, error) {
+	return 0, nil
+}
+
+func (r *NoReply) encode(e *encoder, protocol int) {
+}
+
 // QueuedReply is +QUEUED
 type QueuedReply struct{}
 
@@ -77,6 +123,15 @@ func (r *QueuedReply) ToBytes() []byte {
 	return queuedBytes
 }
 
+// WriteTo writes the reply to w
+func (r *QueuedReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *QueuedReply) encode(e *encoder, protocol int) {
+	e.writeBytes(queuedBytes)
+}
+
 var theQueuedReply = new(QueuedReply)
 
 // MakeQueuedReply returns a QUEUED protocol
@@ -0,0 +1,7 @@
+//go:build race
+
+package entity
+
+// raceEnabled tells that the race detector is on, sync.Pool then drops
+// pooled buffers at random and allocation counts are meaningless
+const raceEnabled = true
@@ -1,8 +1,7 @@
 package entity
 
 import (
-	"bytes"
-	"strconv"
+	"io"
 
 	"github.com/hdt3213/godis/interface/redis"
 )
@@ -13,7 +12,7 @@ type Reply interface {
 
 var (
 	NullBulkBytes = []byte("$-1\r\n")
-	CRLF = "\r\n"
+	CRLF          = "\r\n"
 )
 
 /* ---- Bulk Reply ---- */
@@ -35,7 +34,16 @@ func (r *BulkReply) ToBytes() []byte {
 	if r.Arg == nil {
 		return NullBulkBytes
 	}
-	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w without copying Arg
+func (r *BulkReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *BulkReply) encode(e *encoder, protocol int) {
+	e.writeBulk(r.Arg, protocol)
 }
 
 /* ---- Multi Bulk Reply ---- */
@@ -54,17 +62,19 @@ func MakeMultiBulkReply(args [][]byte) *MultiBulkReply {
 
 // ToBytes marshal redis.Reply
 func (r *MultiBulkReply) ToBytes() []byte {
-	argLen := len(r.Args)
-	var buf bytes.Buffer
-	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w without copying Args
+func (r *MultiBulkReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *MultiBulkReply) encode(e *encoder, protocol int) {
+	e.writeHeader('*', int64(len(r.Args)))
 	for _, arg := range r.Args {
-		if arg == nil {
-			buf.WriteString("$-1" + CRLF)
-		} else {
-			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
-		}
+		e.writeBulk(arg, protocol)
 	}
-	return buf.Bytes()
 }
 
 /* ---- Multi Raw Reply ---- */
@@ -83,13 +93,17 @@ func MakeMultiRawReply(replies []redis.Reply) *MultiRawReply {
 
 // ToBytes marshal redis.Reply
 func (r *MultiRawReply) ToBytes() []byte {
-	argLen := len(r.Replies)
-	var buf bytes.Buffer
-	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
-	for _, arg := range r.Replies {
-		buf.Write(arg.ToBytes())
-	}
-	return buf.Bytes()
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *MultiRawReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *MultiRawReply) encode(e *encoder, protocol int) {
+	e.writeHeader('*', int64(len(r.Replies)))
+	e.writeReplies(r.Replies, protocol)
 }
 
 /* ---- Status Reply ---- */
@@ -108,14 +122,60 @@ func MakeStatusReply(status string) *StatusReply {
 
 // ToBytes marshal redis.Reply
 func (r *StatusReply) ToBytes() []byte {
-	return []byte("+" + r.Status + CRLF)
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *StatusReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *StatusReply) encode(e *encoder, protocol int) {
+	e.writeLine('+', r.Status)
 }
 
 // IsOKReply returns true if the given protocol is +OK
 func IsOKReply(reply redis.Reply) bool {
+	switch r := reply.(type) {
+	case *OkReply:
+		return true
+	case *StatusReply:
+		return r.Status == "OK"
+	case encodable:
+		return false
+	}
 	return string(reply.ToBytes()) == "+OK\r\n"
 }
 
+/* ---- Annotation Reply ---- */
+
+// AnnotationReply is a line starting with '#', the aof keeps the time and
+// the sequence number of every command in one
+type AnnotationReply struct {
+	Text string
+}
+
+// MakeAnnotationReply creates AnnotationReply
+func MakeAnnotationReply(text string) *AnnotationReply {
+	return &AnnotationReply{
+		Text: text,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *AnnotationReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *AnnotationReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *AnnotationReply) encode(e *encoder, protocol int) {
+	e.writeLine('#', r.Text)
+}
+
 /* ---- Int Reply ---- */
 
 // IntReply stores an int64 number
@@ -132,7 +192,16 @@ func MakeIntReply(code int64) *IntReply {
 
 // ToBytes marshal redis.Reply
 func (r *IntReply) ToBytes() []byte {
-	return []byte(":" + strconv.FormatInt(r.Code, 10) + CRLF)
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *IntReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *IntReply) encode(e *encoder, protocol int) {
+	e.writeHeader(':', r.Code)
 }
 
 /* ---- Error Reply ---- */
@@ -157,12 +226,28 @@ func MakeErrReply(status string) *StandardErrReply {
 
 // IsErrorReply returns true if the given protocol is error
 func IsErrorReply(reply redis.Reply) bool {
-	return reply.ToBytes()[0] == '-'
+	switch reply.(type) {
+	case ErrorReply:
+		return true
+	case encodable:
+		return false
+	}
+	data := reply.ToBytes()
+	return len(data) > 0 && data[0] == '-'
 }
 
 // ToBytes marshal redis.Reply
 func (r *StandardErrReply) ToBytes() []byte {
-	return []byte("-" + r.Status + CRLF)
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *StandardErrReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *StandardErrReply) encode(e *encoder, protocol int) {
+	e.writeLine('-', r.Status)
 }
 
 func (r *StandardErrReply) Error() string {
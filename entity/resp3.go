@@ -0,0 +1,293 @@
+package entity
+
+import (
+	"io"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+/* ---- Map Reply ---- */
+
+// MapReply stores keys and values alternately, RESP2 clients get them as
+// a flat array
+type MapReply struct {
+	Pairs []redis.Reply
+}
+
+// MakeMapReply creates MapReply
+func MakeMapReply(pairs []redis.Reply) *MapReply {
+	return &MapReply{
+		Pairs: pairs,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *MapReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *MapReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *MapReply) encode(e *encoder, protocol int) {
+	if protocol == RESP3 {
+		e.writeHeader('%', int64(len(r.Pairs)/2))
+	} else {
+		e.writeHeader('*', int64(len(r.Pairs)))
+	}
+	e.writeReplies(r.Pairs, protocol)
+}
+
+/* ---- Set Reply ---- */
+
+// SetReply stores unordered unique elements, RESP2 clients get an array
+type SetReply struct {
+	Members []redis.Reply
+}
+
+// MakeSetReply creates SetReply
+func MakeSetReply(members []redis.Reply) *SetReply {
+	return &SetReply{
+		Members: members,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *SetReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *SetReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *SetReply) encode(e *encoder, protocol int) {
+	if protocol == RESP3 {
+		e.writeHeader('~', int64(len(r.Members)))
+	} else {
+		e.writeHeader('*', int64(len(r.Members)))
+	}
+	e.writeReplies(r.Members, protocol)
+}
+
+/* ---- Push Reply ---- */
+
+// PushReply is an out of band message, RESP2 clients get an array
+type PushReply struct {
+	Items []redis.Reply
+}
+
+// MakePushReply creates PushReply
+func MakePushReply(items []redis.Reply) *PushReply {
+	return &PushReply{
+		Items: items,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *PushReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *PushReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *PushReply) encode(e *encoder, protocol int) {
+	if protocol == RESP3 {
+		e.writeHeader('>', int64(len(r.Items)))
+	} else {
+		e.writeHeader('*', int64(len(r.Items)))
+	}
+	e.writeReplies(r.Items, protocol)
+}
+
+/* ---- Pairs Reply ---- */
+
+// PairsReply stores pairs such as members and scores alternately, RESP2
+// clients get a flat array and RESP3 clients an array of pairs
+type PairsReply struct {
+	Pairs []redis.Reply
+}
+
+// MakePairsReply creates PairsReply
+func MakePairsReply(pairs []redis.Reply) *PairsReply {
+	return &PairsReply{
+		Pairs: pairs,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *PairsReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *PairsReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *PairsReply) encode(e *encoder, protocol int) {
+	if protocol != RESP3 {
+		e.writeHeader('*', int64(len(r.Pairs)))
+		e.writeReplies(r.Pairs, protocol)
+		return
+	}
+	e.writeHeader('*', int64(len(r.Pairs)/2))
+	for i := 0; i+1 < len(r.Pairs); i += 2 {
+		e.writeHeader('*', 2)
+		e.writeReply(r.Pairs[i], protocol)
+		e.writeReply(r.Pairs[i+1], protocol)
+	}
+}
+
+/* ---- Double Reply ---- */
+
+// DoubleReply stores a floating point number, RESP2 clients get a bulk string
+type DoubleReply struct {
+	Value float64
+}
+
+// MakeDoubleReply creates DoubleReply
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *DoubleReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *DoubleReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *DoubleReply) encode(e *encoder, protocol int) {
+	e.scratch = appendDouble(e.scratch[:0], r.Value)
+	if protocol == RESP3 {
+		e.reserve(1)
+		e.buf = append(e.buf, ',')
+	} else {
+		e.writeHeader('$', int64(len(e.scratch)))
+	}
+	e.writeBytes(e.scratch)
+	e.writeCRLF()
+}
+
+/* ---- Bool Reply ---- */
+
+// BoolReply stores a boolean, RESP2 clients get 1 or 0
+type BoolReply struct {
+	Value bool
+}
+
+// MakeBoolReply creates BoolReply
+func MakeBoolReply(value bool) *BoolReply {
+	return &BoolReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *BoolReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *BoolReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *BoolReply) encode(e *encoder, protocol int) {
+	switch {
+	case protocol == RESP3 && r.Value:
+		e.writeString("#t\r\n")
+	case protocol == RESP3:
+		e.writeString("#f\r\n")
+	case r.Value:
+		e.writeString(":1\r\n")
+	default:
+		e.writeString(":0\r\n")
+	}
+}
+
+/* ---- Big Number Reply ---- */
+
+// BigNumberReply stores an integer out of the int64 range in decimal, RESP2
+// clients get a bulk string
+type BigNumberReply struct {
+	Value string
+}
+
+// MakeBigNumberReply creates BigNumberReply
+func MakeBigNumberReply(value string) *BigNumberReply {
+	return &BigNumberReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *BigNumberReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w
+func (r *BigNumberReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *BigNumberReply) encode(e *encoder, protocol int) {
+	if protocol == RESP3 {
+		e.writeLine('(', r.Value)
+		return
+	}
+	e.writeHeader('$', int64(len(r.Value)))
+	e.writeString(r.Value)
+	e.writeCRLF()
+}
+
+/* ---- Verbatim Reply ---- */
+
+// VerbatimReply stores a text with its format, txt or mkd, RESP2 clients
+// get the text as a bulk string
+type VerbatimReply struct {
+	Format string
+	Text   []byte
+}
+
+// MakeVerbatimReply creates VerbatimReply
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{
+		Format: format,
+		Text:   text,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *VerbatimReply) ToBytes() []byte {
+	return toBytes(r)
+}
+
+// WriteTo writes the reply to w without copying Text
+func (r *VerbatimReply) WriteTo(w io.Writer) (int64, error) {
+	return writeTo(w, r)
+}
+
+func (r *VerbatimReply) encode(e *encoder, protocol int) {
+	if protocol != RESP3 {
+		e.writeBulk(r.Text, protocol)
+		return
+	}
+	e.writeHeader('=', int64(len(r.Format)+1+len(r.Text)))
+	e.writeString(r.Format)
+	e.writeString(":")
+	e.writeBytes(r.Text)
+	e.writeCRLF()
+}
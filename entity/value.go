@@ -41,6 +41,10 @@ func (v *Value) GetLeftLife() int64 {
 	return int64(time.Until(v.ttl.DeadLine) / time.Second)
 }
 
+func (v *Value) GetDeadLine() time.Time {
+	return v.ttl.DeadLine
+}
+
 func (v *Value) Persist() {
 	v.ttl = nil
 }
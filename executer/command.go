@@ -0,0 +1,217 @@
+package executer
+
+import (
+	"kv_storage/entity"
+	"sort"
+	"strings"
+)
+
+// Flag describes how a command behaves, commands combine several flags
+type Flag uint32
+
+const (
+	// FlagWrite commands modify the dataset and are appended to the aof
+	FlagWrite Flag = 1 << iota
+	// FlagReadonly commands only read the dataset
+	FlagReadonly
+	// FlagAdmin commands act on the server itself and are never forwarded
+	FlagAdmin
+	// FlagPubsub commands belong to publish/subscribe
+	FlagPubsub
+	// FlagBlocking commands may block the client
+	FlagBlocking
+	// FlagNoSlowlog commands are never logged by SLOWLOG, their arguments
+	// may hold passwords
+	FlagNoSlowlog
+)
+
+var flagNames = []struct {
+	flag Flag
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadonly, "readonly"},
+	{FlagAdmin, "admin"},
+	{FlagPubsub, "pubsub"},
+	{FlagBlocking, "blocking"},
+	{FlagNoSlowlog, "no-slowlog"},
+}
+
+// Names returns the names of the flags set in f
+func (f Flag) Names() []string {
+	var names []string
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	return names
+}
+
+// ExecFunc executes a command whose arity was already checked, args[0] is
+// the command name as sent by the client
+type ExecFunc func(e *Executer, args [][]byte) entity.Reply
+
+// Command is an entry of the command table
+type Command struct {
+	Name string
+	// Arity counts the command name, a negative arity -n means at least n
+	// arguments, like redis
+	Arity int
+	Flags Flag
+	// FirstKey, LastKey and KeyStep give the positions of the keys in args,
+	// a negative LastKey counts from the end and FirstKey 0 means no keys
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	// exec is nil for the commands handled by the server instead of the executer
+	exec ExecFunc
+	// subcommands of a container command like CLIENT that have flags of
+	// their own, named command|subcommand
+	parent      *Command
+	subcommands map[string]*Command
+}
+
+var cmdTable = make(map[string]*Command)
+
+// RegisterCommand adds a command to the table, the name is case-insensitive
+func RegisterCommand(name string, arity int, flags Flag, firstKey, lastKey, keyStep int, exec ExecFunc) *Command {
+	name = strings.ToLower(name)
+	cmd := &Command{
+		Name:     name,
+		Arity:    arity,
+		Flags:    flags,
+		FirstKey: firstKey,
+		LastKey:  lastKey,
+		KeyStep:  keyStep,
+		exec:     exec,
+	}
+	cmdTable[name] = cmd
+	return cmd
+}
+
+// RegisterSubcommand adds a subcommand to a container command, its flags add
+// to the ones of the command so that the acl categories of the subcommand
+// include the ones of the command. The container runs the subcommand, the
+// entry only describes it.
+func (cmd *Command) RegisterSubcommand(name string, arity int, flags Flag) *Command {
+	name = cmd.Name + "|" + strings.ToLower(name)
+	sub := &Command{
+		Name:   name,
+		Arity:  arity,
+		Flags:  cmd.Flags | flags,
+		parent: cmd,
+	}
+	if cmd.subcommands == nil {
+		cmd.subcommands = make(map[string]*Command)
+	}
+	cmd.subcommands[name] = sub
+	return sub
+}
+
+// Subcommands returns the registered subcommands of cmd sorted by name
+func (cmd *Command) Subcommands() []*Command {
+	subs := make([]*Command, 0, len(cmd.subcommands))
+	for _, sub := range cmd.subcommands {
+		subs = append(subs, sub)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].Name < subs[j].Name
+	})
+	return subs
+}
+
+// LookupCommand finds a command by its case-insensitive name
+func LookupCommand(name []byte) (*Command, bool) {
+	cmd, ok := cmdTable[strings.ToLower(string(name))]
+	return cmd, ok
+}
+
+// Commands returns every registered command
+func Commands() []*Command {
+	commands := make([]*Command, 0, len(cmdTable))
+	for _, cmd := range cmdTable {
+		commands = append(commands, cmd)
+	}
+	return commands
+}
+
+func (cmd *Command) HasFlag(flag Flag) bool {
+	return cmd.Flags&flag != 0
+}
+
+// CheckArity reports whether args has a valid number of arguments for cmd
+func (cmd *Command) CheckArity(args [][]byte) bool {
+	if cmd.Arity >= 0 {
+		return len(args) == cmd.Arity
+	}
+	return len(args) >= -cmd.Arity
+}
+
+// Keys returns the keys of args, args must have a valid arity
+func (cmd *Command) Keys(args [][]byte) [][]byte {
+	if cmd.FirstKey <= 0 || cmd.FirstKey >= len(args) {
+		return nil
+	}
+	last := cmd.LastKey
+	if last < 0 {
+		last += len(args)
+	}
+	if last >= len(args) {
+		last = len(args) - 1
+	}
+	var keys [][]byte
+	for i := cmd.FirstKey; i <= last; i += cmd.KeyStep {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
+
+// maxErrorArgLen bounds the part of a client argument quoted by an error
+const maxErrorArgLen = 128
+
+// ErrorArg returns a client argument to quote in an error reply, cut to
+// maxErrorArgLen bytes and with its control bytes replaced by spaces so
+// that a crafted argument can not inject a reply
+func ErrorArg(arg []byte) string {
+	if len(arg) > maxErrorArgLen {
+		arg = arg[:maxErrorArgLen]
+	}
+	safe := []byte(string(arg))
+	for i, b := range safe {
+		if b < ' ' || b == 0x7f {
+			safe[i] = ' '
+		}
+	}
+	return string(safe)
+}
+
+func MakeUnknownCommandErr(name []byte) entity.Reply {
+	return entity.MakeErrReply("ERR unknown command '" + ErrorArg(name) + "'")
+}
+
+func MakeArityErr(name string) entity.Reply {
+	return entity.MakeErrReply("ERR wrong number of arguments for '" + name + "' command")
+}
+
+// IsWriteCommand reports whether the command named by args[0] modifies the dataset
+func IsWriteCommand(args [][]byte) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := LookupCommand(args[0])
+	return ok && cmd.HasFlag(FlagWrite)
+}
+
+// CommandKeys returns the keys of a command, nil for unknown commands and
+// commands without keys
+func CommandKeys(args [][]byte) [][]byte {
+	if len(args) == 0 {
+		return nil
+	}
+	cmd, ok := LookupCommand(args[0])
+	if !ok || !cmd.CheckArity(args) {
+		return nil
+	}
+	return cmd.Keys(args)
+}
@@ -0,0 +1,56 @@
+package executer
+
+import (
+	"kv_storage/datastore"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+)
+
+func TestLookupCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"get", "get"},
+		{"GET", "get"},
+		{"gEt", "get"},
+		{"ZRangeByScore", "zrangebyscore"},
+		{"bogus", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if cmd, ok := LookupCommand([]byte(tt.name)); ok {
+			got = cmd.Name
+		}
+		if got != tt.want {
+			t.Errorf("LookupCommand(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestExecuteDispatch(t *testing.T) {
+	e := NewExecuter(datastore.NewMap())
+	e.Execute([][]byte{[]byte("SET"), []byte("k"), []byte("v")})
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GET", "k"}, "$1\r\nv\r\n"},
+		{[]string{"Get", "k"}, "$1\r\nv\r\n"},
+		{[]string{"get"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"GET", "k", "x"}, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{[]string{"bogus"}, "-ERR unknown command 'bogus'\r\n"},
+		{[]string{"bo\r\n+OK"}, "-ERR unknown command 'bo  +OK'\r\n"},
+		{[]string{"bo\x00\x1b\x7fgus"}, "-ERR unknown command 'bo   gus'\r\n"},
+		{[]string{strings.Repeat("x", 200)}, "-ERR unknown command '" + strings.Repeat("x", maxErrorArgLen) + "'\r\n"},
+		{[]string{"command", "bo\r\ngus"}, "-ERR unknown subcommand 'bo  gus'. Try COMMAND HELP.\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(e.Execute(utils.ToCmdLine(tt.args...)).ToBytes()); got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
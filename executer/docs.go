@@ -0,0 +1,216 @@
+package executer
+
+import (
+	"strings"
+)
+
+// commandDoc documents a command for COMMAND DOCS, syntax lists the
+// arguments after the command name in the notation of the redis manual:
+// [optional], a|b for one of, (a b) for a block, trailing ... for repeated
+// arguments and upper case words for literal tokens
+type commandDoc struct {
+	group   string
+	summary string
+	syntax  string
+}
+
+var commandDocs = map[string]commandDoc{
+	"set":              {"string", "Sets the string value of a key, optionally with an expiration time", "key value [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds]"},
+	"setex":            {"string", "Sets the string value and expiration time of a key", "key seconds value"},
+	"psetex":           {"string", "Sets both string value and expiration time in milliseconds of a key", "key milliseconds value"},
+	"get":              {"string", "Returns the string value of a key", "key"},
+	"getex":            {"string", "Returns the string value of a key after setting its expiration time", "key [EX seconds|PX milliseconds|EXAT unix-time-seconds|PXAT unix-time-milliseconds|PERSIST]"},
+	"mset":             {"string", "Atomically creates or modifies the string values of one or more keys", "key value [key value ...]"},
+	"mget":             {"string", "Atomically returns the string values of one or more keys", "key [key ...]"},
+	"msetnx":           {"string", "Atomically modifies the string values of one or more keys only when all keys don't exist", "key value [key value ...]"},
+	"ping":             {"connection", "Returns the server's liveliness response", "[message]"},
+	"client":           {"connection", "A container for client connection commands", "ID|INFO|GETNAME|UNPAUSE|(SETNAME connection-name)|(LIST [TYPE client-type] [ID client-id ...])|(KILL ip:port|([ID client-id] [ADDR ip:port] [LADDR ip:port] [USER username] [SKIPME yes/no] [MAXAGE maxage]))|(PAUSE timeout [WRITE|ALL])|(NO-EVICT on/off)"},
+	"hello":            {"connection", "Handshakes with the server", "[protover [AUTH username password] [SETNAME clientname]]"},
+	"auth":             {"connection", "Authenticates the connection", "[username] password"},
+	"quit":             {"connection", "Closes the connection", ""},
+	"del":              {"generic", "Deletes one or more keys", "key [key ...]"},
+	"keys":             {"generic", "Returns all key names that match a pattern", "pattern"},
+	"exists":           {"generic", "Determines whether a key exists", "key"},
+	"expire":           {"generic", "Sets the expiration time of a key in seconds", "key seconds"},
+	"pexpire":          {"generic", "Sets the expiration time of a key in milliseconds", "key milliseconds"},
+	"expireat":         {"generic", "Sets the expiration time of a key to a Unix timestamp", "key unix-time-seconds"},
+	"pexpireat":        {"generic", "Sets the expiration time of a key to a Unix milliseconds timestamp", "key unix-time-milliseconds"},
+	"ttl":              {"generic", "Returns the expiration time in seconds of a key", "key"},
+	"persist":          {"generic", "Removes the expiration time of a key", "key"},
+	"lpush":            {"list", "Prepends one or more elements to a list, creates the key if it doesn't exist", "key element [element ...]"},
+	"rpush":            {"list", "Appends one or more elements to a list, creates the key if it doesn't exist", "key element [element ...]"},
+	"lrange":           {"list", "Returns a range of elements from a list", "key start stop"},
+	"llen":             {"list", "Returns the length of a list", "key"},
+	"lindex":           {"list", "Returns an element from a list by its index", "key index"},
+	"linsert":          {"list", "Inserts an element before or after another element in a list", "key (BEFORE|AFTER) pivot element"},
+	"lrem":             {"list", "Removes elements from a list", "key count element"},
+	"ltrim":            {"list", "Removes elements from both ends a list", "key start stop"},
+	"lset":             {"list", "Sets the value of an element in a list by its index", "key index element"},
+	"lpop":             {"list", "Returns the first elements in a list after removing it", "key [count]"},
+	"rpop":             {"list", "Returns and removes the last elements of a list", "key [count]"},
+	"zadd":             {"sorted-set", "Adds one or more members to a sorted set, or updates their scores", "key score member [score member ...]"},
+	"zrange":           {"sorted-set", "Returns members in a sorted set within a range of indexes", "key start stop [WITHSCORES]"},
+	"zrevrange":        {"sorted-set", "Returns members in a sorted set within a range of indexes in reverse order", "key start stop [WITHSCORES]"},
+	"zrem":             {"sorted-set", "Removes one or more members from a sorted set", "key member [member ...]"},
+	"zcard":            {"sorted-set", "Returns the number of members in a sorted set", "key"},
+	"zcount":           {"sorted-set", "Returns the count of members in a sorted set that have scores within a range", "key min max"},
+	"zrangebyscore":    {"sorted-set", "Returns members in a sorted set within a range of scores", "key min max [WITHSCORES] [LIMIT offset count]"},
+	"zrevrangebyscore": {"sorted-set", "Returns members in a sorted set within a range of scores in reverse order", "key max min [WITHSCORES] [LIMIT offset count]"},
+	"zrank":            {"sorted-set", "Returns the index of a member in a sorted set ordered by ascending scores", "key member"},
+	"zrevrank":         {"sorted-set", "Returns the index of a member in a sorted set ordered by descending scores", "key member"},
+	"bgrewriteaof":     {"server", "Asynchronously rewrites the append-only file to disk", ""},
+	"save":             {"server", "Synchronously saves the database to disk", ""},
+	"bgsave":           {"server", "Asynchronously saves the database to disk", ""},
+	"lastsave":         {"server", "Returns the Unix timestamp of the last successful save to disk", ""},
+	"info":             {"server", "Returns information and statistics about the server", "[section [section ...]]"},
+	"monitor":          {"server", "Listen for all requests received by the server in real time", ""},
+	"slowlog":          {"server", "A container for slow log commands", "(GET [count])|LEN|RESET"},
+	"latency":          {"server", "A container for latency diagnostics commands", "LATEST|(HISTORY event)|(RESET [event ...])|(HISTOGRAM [command ...])|HELP"},
+	"shutdown":         {"server", "Synchronously saves the database(s) to disk and shuts down the server", "[NOSAVE|SAVE]"},
+	"acl":              {"server", "A container for Access List Control commands", "WHOAMI|LIST|SAVE|LOAD|(SETUSER username [rule ...])|(GETUSER username)|(DELUSER username [username ...])|(CAT [category])|(LOG [count|RESET])"},
+	"command":          {"server", "Returns detailed information about all commands", "[COUNT|(INFO [command-name ...])|(DOCS [command-name ...])|(GETKEYS command [arg ...])]"},
+}
+
+// argument describes an argument of a command like the arguments of the
+// redis COMMAND DOCS reply
+type argument struct {
+	name     string
+	typ      string
+	token    string
+	optional bool
+	multiple bool
+	// arguments holds the alternatives of a oneof or the parts of a block
+	arguments []*argument
+}
+
+// parseSyntax turns the syntax of a commandDoc into its arguments
+func parseSyntax(syntax string) []*argument {
+	replacer := strings.NewReplacer("[", " [ ", "]", " ] ", "(", " ( ", ")", " ) ", "|", " | ")
+	p := &syntaxParser{fields: strings.Fields(replacer.Replace(syntax))}
+	args, _ := p.parseSequence()
+	return args
+}
+
+type syntaxParser struct {
+	fields []string
+	pos    int
+}
+
+func (p *syntaxParser) peek() string {
+	if p.pos < len(p.fields) {
+		return p.fields[p.pos]
+	}
+	return ""
+}
+
+// parseSequence parses arguments up to a closing bracket or parenthesis and
+// reports whether the sequence ends with ...
+func (p *syntaxParser) parseSequence() ([]*argument, bool) {
+	var seq []*argument
+	repeated := false
+	for field := p.peek(); field != "" && field != "]" && field != ")"; field = p.peek() {
+		if field == "..." {
+			p.pos++
+			repeated = true
+			continue
+		}
+		arg, repeats := p.parseItem()
+		if n := len(repeats); n > 0 && n <= len(seq) && sameNames(seq[len(seq)-n:], repeats) {
+			// key [key ...] repeats the arguments before the bracket
+			arg = blockOf(append([]*argument(nil), seq[len(seq)-n:]...))
+			arg.multiple = true
+			seq = seq[:len(seq)-n]
+		}
+		for p.peek() == "|" {
+			// a|b|c are alternatives of a single argument
+			p.pos++
+			next, _ := p.parseItem()
+			if arg.typ != "oneof" || arg.optional {
+				arg = &argument{typ: "oneof", arguments: []*argument{arg}}
+			}
+			arg.arguments = append(arg.arguments, next)
+		}
+		if arg.typ == "oneof" && arg.name == "" {
+			names := make([]string, len(arg.arguments))
+			for i, alternative := range arg.arguments {
+				names[i] = alternative.name
+			}
+			arg.name = strings.Join(names, "-")
+		}
+		seq = append(seq, arg)
+	}
+	return seq, repeated
+}
+
+// parseItem parses a bracket, a parenthesized block, a token with its
+// optional value or a plain argument, repeats are the arguments of a
+// bracket ending with ...
+func (p *syntaxParser) parseItem() (arg *argument, repeats []*argument) {
+	field := p.peek()
+	p.pos++
+	switch {
+	case field == "[" || field == "(":
+		inner, repeated := p.parseSequence()
+		p.pos++ // the closing ] or )
+		arg = blockOf(inner)
+		if field == "[" {
+			arg.optional = true
+			arg.multiple = repeated
+		}
+		if repeated {
+			repeats = inner
+		}
+		return arg, repeats
+	case isToken(field):
+		arg = &argument{name: strings.ToLower(field), typ: "pure-token", token: field}
+		if next := p.peek(); next != "" && !isToken(next) && !isSyntax(next) {
+			// EX seconds is a token followed by its value
+			p.pos++
+			arg.name, arg.typ = next, argumentType(next)
+		}
+		return arg, nil
+	}
+	return &argument{name: field, typ: argumentType(field)}, nil
+}
+
+func sameNames(a, b []*argument) bool {
+	for i := range a {
+		if a[i].name != b[i].name {
+			return false
+		}
+	}
+	return true
+}
+
+// blockOf wraps several arguments into a block, a single argument is kept
+func blockOf(args []*argument) *argument {
+	if len(args) == 1 {
+		return args[0]
+	}
+	return &argument{name: args[0].name + "-block", typ: "block", arguments: args}
+}
+
+func isToken(field string) bool {
+	return field == strings.ToUpper(field) && strings.ToUpper(field) != strings.ToLower(field)
+}
+
+func isSyntax(field string) bool {
+	return field == "[" || field == "]" || field == "(" || field == ")" || field == "|" || field == "..."
+}
+
+func argumentType(name string) string {
+	switch {
+	case name == "key":
+		return "key"
+	case name == "pattern":
+		return "pattern"
+	case strings.HasPrefix(name, "unix-time"):
+		return "unix-time"
+	case name == "score" || name == "min" || name == "max":
+		return "double"
+	case name == "seconds" || name == "milliseconds" || name == "count" || name == "index" ||
+		name == "start" || name == "stop" || name == "offset":
+		return "integer"
+	}
+	return "string"
+}
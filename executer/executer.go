@@ -3,7 +3,9 @@ package executer
 import (
 	"kv_storage/datastore"
 	"kv_storage/entity"
-	"strconv"
+	"kv_storage/latency"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -13,342 +15,163 @@ const (
 	ParamNotImplementedErr = "current commonds can not implemented"
 	NoErr                  = "+OK"
 	ParamUncorrect         = "args uncorrect"
+	InvalidExpireErr       = "invalid expire time"
 )
 
 type Executer struct {
 	db *datastore.Map
+	// mu is held shared around every client command and exclusively while
+	// a consistent snapshot of the dataset is taken
+	mu sync.RWMutex
+	// dirty counts the successful write commands since startup
+	dirty int64
+	// expiredKeys counts the keys deleted because they expired, the
+	// keyspace counters the keys found or not by the read commands
+	expiredKeys    int64
+	keyspaceHits   int64
+	keyspaceMisses int64
+	// loading is set while the aof is replayed, deadlines that passed
+	// since the commands were logged must not delete keys which later
+	// commands of the log still saw alive
+	loading bool
 }
 
 func NewExecuter(store *datastore.Map) *Executer {
 	return &Executer{db: store}
 }
 
+// SetLoading switches the executer into replay mode, it must not be called
+// while clients are served
+func (e *Executer) SetLoading(loading bool) {
+	e.loading = loading
+}
+
+func (e *Executer) RLock() {
+	e.mu.RLock()
+}
+
+func (e *Executer) RUnlock() {
+	e.mu.RUnlock()
+}
+
+// Lock waits for the running commands to finish and blocks new ones
+func (e *Executer) Lock() {
+	e.mu.Lock()
+}
+
+func (e *Executer) Unlock() {
+	e.mu.Unlock()
+}
+
+// Snapshot begins a copy-on-write snapshot of the dataset at a point no
+// command is half way through, together with the dirty counter at that
+// point. The caller closes the snapshot once read.
+func (e *Executer) Snapshot() (*datastore.CowSnapshot, int64) {
+	e.Lock()
+	defer e.Unlock()
+	return e.db.BeginSnapshot(), e.Dirty()
+}
+
+func (e *Executer) Dirty() int64 {
+	return atomic.LoadInt64(&e.dirty)
+}
+
+// Execute looks up the command named by args[0] in the command table,
+// checks its arity and runs its handler
 func (e *Executer) Execute(args [][]byte) entity.Reply {
 	if len(args) == 0 {
 		return entity.MakeErrReply(ParamNotFoundErr)
 	}
-	switch string(args[0]) {
-	case "ping":
-		return entity.MakeBulkReply([]byte("pong"))
-	case "set": // string
-		if len(args) < 3 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		e.db.Set(args[1], args[2])
-		return entity.MakeStatusReply(NoErr)
-	case "get":
-		if len(args) < 2 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		value, _, err := e.db.Get(args[1])
-		if err == datastore.ErrKeyExpired {
-			e.db.Del(args[1])
-		}
-		return entity.MakeBulkReply(value)
-	case "mset":
-		for i := 1; i < len(args)-1; {
-			e.db.Set(args[i], args[i+1])
-			i += 2
-		}
-		return entity.MakeStatusReply(NoErr)
-	case "mget":
-		var values [][]byte
-		for i := 1; i < len(args); i++ {
-			v, _, err := e.db.Get(args[i])
-			if err == datastore.ErrKeyExpired {
-				e.db.Del(args[i])
-			}
-			values = append(values, v)
-		}
-		return entity.MakeMultiBulkReply(values)
-	case "msetnx":
-		for i := 1; i < len(args)-1; {
-			_, exists, _ := e.db.Get(args[i])
-			if exists {
-				return entity.MakeErrReply("0")
-			}
-			i += 2
-		}
-		for i := 1; i < len(args)-1; {
-			e.db.Set(args[i], args[i+1])
-			i += 2
-		}
-		return entity.MakeStatusReply(NoErr)
-	case "del": // key
-		if len(args) < 2 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		var deletedNum int64
-		for i := 1; i < len(args); i++ {
-			_, exist, _ := e.db.Get(args[i])
-			if exist {
-				e.db.Del(args[i])
-				deletedNum++
-			}
-		}
-		return entity.MakeIntReply(deletedNum)
-	case "keys":
-		if len(args) < 2 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		switch string(args[1]) {
-		case "*":
-			keys := e.db.Keys()
-			return entity.MakeMultiBulkReply(keys)
-		default:
-			return entity.MakeErrReply(ParamNotImplementedErr)
-		}
-	case "exists":
-		if len(args) < 2 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		_, exists, _ := e.db.Get(args[1])
-		if exists {
-			return entity.MakeIntReply(1)
-		}
-		return entity.MakeIntReply(0)
-	case "expire":
-		if len(args) < 3 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		_, exists, _ := e.db.Get(args[1])
-		if !exists {
-			return entity.MakeIntReply(0)
-		}
-		n, err := strconv.Atoi(string(args[2]))
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		e.db.SetTTL(args[1], n)
-		return entity.MakeIntReply(1)
-	case "ttl":
-		if len(args) < 2 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		return entity.MakeIntReply(e.db.GetLeftLife(args[1]))
-	case "persist":
-		if len(args) < 2 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		_, exist, _ := e.db.Get(args[1])
-		if !exist {
-			return entity.MakeIntReply(0)
-		}
-		return entity.MakeIntReply(e.db.Persist(args[1]))
-	case "expireat":
-		if len(args) < 3 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		_, exist, _ := e.db.Get(args[1])
-		if !exist {
-			return entity.MakeIntReply(0)
-		}
-		sec, err := strconv.Atoi(string(args[2]))
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		e.db.SetDeadLine(args[1], time.Unix(int64(sec), 0))
-		return entity.MakeIntReply(1)
-	case "lpush": // List
-		values, err := prePush(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if num := e.db.Lpush(args[1], values); num == -1 {
-			return entity.MakeErrReply(datastore.ErrTypeNotMatched.Error())
-		} else {
-			return entity.MakeIntReply(num)
-		}
-	case "rpush":
-		values, err := prePush(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if num := e.db.Rpush(args[1], values); num == -1 {
-			return entity.MakeErrReply(datastore.ErrTypeNotMatched.Error())
-		} else {
-			return entity.MakeIntReply(num)
-		}
-	case "lrange":
-		key, start, stop, err := preLrange(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		values, err := e.db.Lrange(key, start, stop)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		return entity.MakeMultiBulkReply(values)
-	case "llen":
-		if len(args) < 2 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		return entity.MakeIntReply(int64(e.db.Llen(args[1])))
-	case "lindex":
-		key, index, err := preLindex(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if value, err := e.db.Lindex(key, index); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeBulkReply(value)
-		}
-	case "linsert":
-		key, before, pivot, value, err := preLinsert(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if length, err := e.db.Linsert(key, before, pivot, value); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeIntReply(int64(length))
-		}
-	case "lrem":
-		key, count, value, err := preLrem(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if removedNum, err := e.db.Lrem(key, count, value); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeIntReply(int64(removedNum))
-		}
-	case "ltrim":
-		key, start, stop, err := preLrange(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if err := e.db.Ltrim(key, start, stop); err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		return entity.MakeOkReply()
-	case "lset":
-		key, index, value, err := preLset(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if e.db.Lset(key, index, value); err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		return entity.MakeOkReply()
-	case "lpop":
-		key, count, err := prePop(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if poped, err := e.db.Lpop(key, count); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeMultiBulkReply(poped)
-		}
-	case "rpop":
-		key, count, err := prePop(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if poped, err := e.db.Rpop(key, count); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeMultiBulkReply(poped)
-		}
-	case "zadd": // zset
-		names, scores, err := preZadd(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if insertedNum, err := e.db.Zadd(scores, names, string(args[1])); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeIntReply(int64(insertedNum))
-		}
-	case "zrange":
-		start, stop, withScore, err := preZrevrange(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if rs, err := e.db.Zrange(string(args[1]), start, stop, false, withScore); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeMultiBulkReply(rs)
-		}
-	case "zrevrange":
-		start, stop, withScore, err := preZrevrange(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if rs, err := e.db.Zrange(string(args[1]), start, stop, true, withScore); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeMultiBulkReply(rs)
-		}
-	case "zrem":
-		if len(args) < 3 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		if removedNum, err := e.db.Zrem(string(args[1]), args[2:]); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeIntReply(removedNum)
-		}
-	case "zcard":
-		if len(args) < 2 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		if num, err := e.db.Zcard(string(args[1])); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeIntReply(num)
-		}
-	case "zcount":
-		if len(args) < 4 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		if num, err := e.db.Zcount(string(args[1]), args[2], args[3]); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeIntReply(num)
-		}
-	case "zrangebyscore":
-		key, min, max, withScore, offset, count, err := preZrankByScore(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if rs, err := e.db.ZrangeByScore(key, min, max, withScore, false, offset, count); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeMultiBulkReply(rs)
-		}
-	case "zrevrangebyscore":
-		key, min, max, withScore, offset, count, err := preZrankByScore(args)
-		if err != nil {
-			return entity.MakeErrReply(err.Error())
-		}
-		if rs, err := e.db.ZrangeByScore(key, min, max, withScore, true, offset, count); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeMultiBulkReply(rs)
-		}
-	case "zrank":
-		if len(args) < 3 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		if rank, err := e.db.Zrank(args[1], args[2], false); err != nil {
-			return entity.MakeErrReply(err.Error())
-		} else {
-			return entity.MakeIntReply(rank)
-		}
-	case "zrevrank":
-		if len(args) < 3 {
-			return entity.MakeErrReply(MissParamErr)
-		}
-		if rank, err := e.db.Zrank(args[1], args[2], true); err != nil {
-			return entity.MakeErrReply(err.Error())
+	cmd, ok := LookupCommand(args[0])
+	if !ok || cmd.exec == nil {
+		return MakeUnknownCommandErr(args[0])
+	}
+	if !cmd.CheckArity(args) {
+		return MakeArityErr(cmd.Name)
+	}
+	if cmd.HasFlag(FlagReadonly) {
+		e.countLookups(cmd.Keys(args))
+	}
+	reply := cmd.exec(e, args)
+	if cmd.HasFlag(FlagWrite) && !entity.IsErrorReply(reply) {
+		atomic.AddInt64(&e.dirty, 1)
+	}
+	return reply
+}
+
+// Stats are the keyspace counters reported by INFO
+type Stats struct {
+	ExpiredKeys    int64
+	KeyspaceHits   int64
+	KeyspaceMisses int64
+}
+
+func (e *Executer) Stats() Stats {
+	return Stats{
+		ExpiredKeys:    atomic.LoadInt64(&e.expiredKeys),
+		KeyspaceHits:   atomic.LoadInt64(&e.keyspaceHits),
+		KeyspaceMisses: atomic.LoadInt64(&e.keyspaceMisses),
+	}
+}
+
+// Keyspace counts the keys and the ones with a deadline without scanning
+// them, avgTTL is the average time left to a sample of the keys with a
+// deadline
+func (e *Executer) Keyspace() (keys, expires int64, avgTTL time.Duration) {
+	return e.db.Keyspace()
+}
+
+// countLookups counts the keys a read command finds as hits and the others
+// as misses
+func (e *Executer) countLookups(keys [][]byte) {
+	for _, key := range keys {
+		if e.exists(key) {
+			atomic.AddInt64(&e.keyspaceHits, 1)
 		} else {
-			return entity.MakeIntReply(rank)
+			atomic.AddInt64(&e.keyspaceMisses, 1)
 		}
-	// case "zincrby":
-	default:
-		return entity.MakeErrReply(ParamNotImplementedErr)
 	}
 }
+
+// deleteExpired deletes key if it expired, which a read command does when
+// it finds an expired key
+func (e *Executer) deleteExpired(key []byte) {
+	start := time.Now()
+	if e.db.DelExpired(key) {
+		atomic.AddInt64(&e.expiredKeys, 1)
+		latency.AddSample(latency.EventExpireDel, time.Since(start))
+	}
+}
+
+// exists reports whether key is alive, while loading a key whose deadline
+// passed in the meantime still counts as alive
+func (e *Executer) exists(key []byte) bool {
+	if e.loading {
+		return e.db.Contains(key)
+	}
+	_, exists, _ := e.db.Get(key)
+	return exists
+}
+
+// expireAt sets the deadline of an existing key, a deadline that already
+// passed deletes the key like redis does. While loading the deadline is
+// kept instead, the key stays invisible unless a later command persists it.
+func (e *Executer) expireAt(key []byte, deadLine time.Time) int64 {
+	if !e.exists(key) {
+		return 0
+	}
+	if !e.loading && !deadLine.After(time.Now()) {
+		e.db.Del(key)
+	} else {
+		e.db.SetDeadLine(key, deadLine)
+	}
+	return 1
+}
+
+func (e *Executer) setWithDeadLine(key, value []byte, deadLine time.Time) {
+	if !e.loading && !deadLine.After(time.Now()) {
+		e.db.Del(key)
+		return
+	}
+	e.db.SetWithDeadLine(key, value, deadLine)
+}
@@ -0,0 +1,220 @@
+package executer
+
+import (
+	"kv_storage/entity"
+	"sort"
+	"strings"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+func init() {
+	RegisterCommand("command", -1, 0, 0, 0, 0, execCommand)
+}
+
+// execCommand implements COMMAND and its subcommands from the command table
+func execCommand(e *Executer, args [][]byte) entity.Reply {
+	if len(args) == 1 {
+		return commandInfos(sortedCommands())
+	}
+	sub := strings.ToLower(string(args[1]))
+	switch sub {
+	case "count":
+		if len(args) != 2 {
+			return MakeArityErr("command|count")
+		}
+		return entity.MakeIntReply(int64(len(cmdTable)))
+	case "info", "docs":
+		var commands []*Command
+		if len(args) == 2 {
+			commands = sortedCommands()
+		} else {
+			for _, name := range args[2:] {
+				cmd, _ := LookupCommand(name)
+				commands = append(commands, cmd)
+			}
+		}
+		if sub == "info" {
+			return commandInfos(commands)
+		}
+		return commandDocsReply(commands)
+	case "getkeys":
+		if len(args) < 3 {
+			return MakeArityErr("command|getkeys")
+		}
+		cmd, ok := LookupCommand(args[2])
+		if !ok {
+			return entity.MakeErrReply("ERR Invalid command specified")
+		}
+		if !cmd.CheckArity(args[2:]) {
+			return entity.MakeErrReply("ERR Invalid number of arguments specified for command")
+		}
+		keys := cmd.Keys(args[2:])
+		if len(keys) == 0 {
+			return entity.MakeErrReply("ERR The command has no key arguments")
+		}
+		return entity.MakeMultiBulkReply(keys)
+	}
+	return entity.MakeErrReply("ERR unknown subcommand '" + ErrorArg(args[1]) + "'. Try COMMAND HELP.")
+}
+
+func sortedCommands() []*Command {
+	commands := Commands()
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].Name < commands[j].Name
+	})
+	return commands
+}
+
+// commandInfos replies the COMMAND INFO of commands, unknown commands are nil
+func commandInfos(commands []*Command) entity.Reply {
+	replies := make([]redis.Reply, len(commands))
+	for i, cmd := range commands {
+		if cmd == nil {
+			replies[i] = entity.MakeNullBulkReply()
+			continue
+		}
+		replies[i] = commandInfo(cmd)
+	}
+	return entity.MakeMultiRawReply(replies)
+}
+
+// commandInfo has the layout of redis 7: name, arity, flags, first key,
+// last key, key step, acl categories, tips, key specs and subcommands
+func commandInfo(cmd *Command) entity.Reply {
+	flags := cmd.Flags.Names()
+	flagReplies := make([]redis.Reply, len(flags))
+	for i, flag := range flags {
+		flagReplies[i] = entity.MakeStatusReply(flag)
+	}
+	categories := []redis.Reply{}
+	for _, category := range cmd.Categories() {
+		categories = append(categories, entity.MakeStatusReply("@"+category))
+	}
+	return entity.MakeMultiRawReply([]redis.Reply{
+		entity.MakeBulkReply([]byte(cmd.Name)),
+		entity.MakeIntReply(int64(cmd.Arity)),
+		entity.MakeSetReply(flagReplies),
+		entity.MakeIntReply(int64(cmd.FirstKey)),
+		entity.MakeIntReply(int64(cmd.LastKey)),
+		entity.MakeIntReply(int64(cmd.KeyStep)),
+		entity.MakeSetReply(categories),
+		entity.MakeEmptyMultiBulkReply(),
+		entity.MakeEmptyMultiBulkReply(),
+		subcommandInfos(cmd),
+	})
+}
+
+func subcommandInfos(cmd *Command) entity.Reply {
+	subs := cmd.Subcommands()
+	if len(subs) == 0 {
+		return entity.MakeEmptyMultiBulkReply()
+	}
+	replies := make([]redis.Reply, len(subs))
+	for i, sub := range subs {
+		replies[i] = commandInfo(sub)
+	}
+	return entity.MakeMultiRawReply(replies)
+}
+
+// Categories returns the acl categories of cmd, derived from its flags and
+// the group of its docs like redis does, the admin commands are dangerous
+// too. A subcommand belongs to the group of its command.
+func (cmd *Command) Categories() []string {
+	var categories []string
+	for _, flag := range cmd.Flags.Names() {
+		if flag == "readonly" {
+			flag = "read"
+		}
+		if isCategory(flag) {
+			categories = append(categories, flag)
+		}
+		if flag == "admin" {
+			categories = append(categories, "dangerous")
+		}
+	}
+	name := cmd.Name
+	if cmd.parent != nil {
+		name = cmd.parent.Name
+	}
+	switch group := commandDocs[name].group; group {
+	case "generic":
+		categories = append(categories, "keyspace")
+	case "sorted-set":
+		categories = append(categories, "sortedset")
+	case "string", "list", "connection":
+		categories = append(categories, group)
+	}
+	return categories
+}
+
+// categoryNames are the acl categories Categories may return
+var categoryNames = []string{
+	"keyspace", "read", "write", "sortedset", "list", "string",
+	"admin", "dangerous", "pubsub", "blocking", "connection",
+}
+
+func isCategory(name string) bool {
+	for _, category := range categoryNames {
+		if category == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CategoryNames returns the names of the acl categories
+func CategoryNames() []string {
+	return append([]string(nil), categoryNames...)
+}
+
+// commandDocsReply replies a map from command names to their docs
+func commandDocsReply(commands []*Command) entity.Reply {
+	var replies []redis.Reply
+	for _, cmd := range commands {
+		if cmd == nil {
+			continue
+		}
+		doc := commandDocs[cmd.Name]
+		fields := []redis.Reply{
+			entity.MakeBulkReply([]byte("summary")), entity.MakeBulkReply([]byte(doc.summary)),
+			entity.MakeBulkReply([]byte("group")), entity.MakeBulkReply([]byte(doc.group)),
+		}
+		if args := parseSyntax(doc.syntax); len(args) > 0 {
+			fields = append(fields, entity.MakeBulkReply([]byte("arguments")), argumentsReply(args))
+		}
+		replies = append(replies, entity.MakeBulkReply([]byte(cmd.Name)), entity.MakeMapReply(fields))
+	}
+	return entity.MakeMapReply(replies)
+}
+
+func argumentsReply(args []*argument) entity.Reply {
+	replies := make([]redis.Reply, len(args))
+	for i, arg := range args {
+		fields := []redis.Reply{
+			entity.MakeBulkReply([]byte("name")), entity.MakeBulkReply([]byte(arg.name)),
+			entity.MakeBulkReply([]byte("type")), entity.MakeBulkReply([]byte(arg.typ)),
+		}
+		if arg.typ != "oneof" && arg.typ != "block" && arg.typ != "pure-token" {
+			fields = append(fields, entity.MakeBulkReply([]byte("display_text")), entity.MakeBulkReply([]byte(arg.name)))
+		}
+		if arg.token != "" {
+			fields = append(fields, entity.MakeBulkReply([]byte("token")), entity.MakeBulkReply([]byte(arg.token)))
+		}
+		var flags []redis.Reply
+		if arg.optional {
+			flags = append(flags, entity.MakeStatusReply("optional"))
+		}
+		if arg.multiple {
+			flags = append(flags, entity.MakeStatusReply("multiple"))
+		}
+		if len(flags) > 0 {
+			fields = append(fields, entity.MakeBulkReply([]byte("flags")), entity.MakeSetReply(flags))
+		}
+		if len(arg.arguments) > 0 {
+			fields = append(fields, entity.MakeBulkReply([]byte("arguments")), argumentsReply(arg.arguments))
+		}
+		replies[i] = entity.MakeMapReply(fields)
+	}
+	return entity.MakeMultiRawReply(replies)
+}
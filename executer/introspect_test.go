@@ -0,0 +1,53 @@
+package executer
+
+import (
+	"kv_storage/datastore"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+)
+
+func TestCommand(t *testing.T) {
+	e := NewExecuter(datastore.NewMap())
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"command count", ":" + strconv.Itoa(len(Commands())) + "\r\n"},
+		{"COMMAND Count", ":" + strconv.Itoa(len(Commands())) + "\r\n"},
+		{"command count x", "-ERR wrong number of arguments for 'command|count' command\r\n"},
+		{"command info get bogus", "*2\r\n" +
+			"*10\r\n$3\r\nget\r\n:2\r\n*1\r\n+readonly\r\n:1\r\n:1\r\n:1\r\n*2\r\n+@read\r\n+@string\r\n*0\r\n*0\r\n*0\r\n" +
+			"$-1\r\n"},
+		{"command info mset", "*1\r\n" +
+			"*10\r\n$4\r\nmset\r\n:-3\r\n*1\r\n+write\r\n:1\r\n:-1\r\n:2\r\n*2\r\n+@write\r\n+@string\r\n*0\r\n*0\r\n*0\r\n"},
+		{"command docs get", "*2\r\n$3\r\nget\r\n*6\r\n" +
+			"$7\r\nsummary\r\n$33\r\nReturns the string value of a key\r\n$5\r\ngroup\r\n$6\r\nstring\r\n" +
+			"$9\r\narguments\r\n*1\r\n*6\r\n$4\r\nname\r\n$3\r\nkey\r\n$4\r\ntype\r\n$3\r\nkey\r\n$12\r\ndisplay_text\r\n$3\r\nkey\r\n"},
+		{"command docs bogus", "*0\r\n"},
+		{"command getkeys mset a 1 b 2", "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"command getkeys GET k", "*1\r\n$1\r\nk\r\n"},
+		{"command getkeys ping", "-ERR The command has no key arguments\r\n"},
+		{"command getkeys bogus", "-ERR Invalid command specified\r\n"},
+		{"command getkeys get", "-ERR Invalid number of arguments specified for command\r\n"},
+		{"command getkeys", "-ERR wrong number of arguments for 'command|getkeys' command\r\n"},
+		{"command bogus", "-ERR unknown subcommand 'bogus'. Try COMMAND HELP.\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(e.Execute(utils.ToCmdLine(strings.Fields(tt.cmd)...)).ToBytes()); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+// TestCommandDocs checks that every command of the table is documented
+func TestCommandDocs(t *testing.T) {
+	for _, cmd := range Commands() {
+		doc, ok := commandDocs[cmd.Name]
+		if !ok || doc.group == "" || doc.summary == "" {
+			t.Errorf("%s is not documented", cmd.Name)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package executer
+
+import (
+	"kv_storage/entity"
+	"time"
+)
+
+func init() {
+	RegisterCommand("ping", -1, 0, 0, 0, 0, execPing)
+	RegisterCommand("del", -2, FlagWrite, 1, -1, 1, execDel)
+	RegisterCommand("keys", 2, FlagReadonly, 0, 0, 0, execKeys)
+	RegisterCommand("exists", 2, FlagReadonly, 1, 1, 1, execExists)
+	RegisterCommand("expire", 3, FlagWrite, 1, 1, 1, execExpire("ex"))
+	RegisterCommand("pexpire", 3, FlagWrite, 1, 1, 1, execExpire("px"))
+	RegisterCommand("expireat", 3, FlagWrite, 1, 1, 1, execExpire("exat"))
+	RegisterCommand("pexpireat", 3, FlagWrite, 1, 1, 1, execExpire("pxat"))
+	RegisterCommand("ttl", 2, FlagReadonly, 1, 1, 1, execTTL)
+	RegisterCommand("persist", 2, FlagWrite, 1, 1, 1, execPersist)
+}
+
+// execExpire returns the handler of an expire command, unit is the expiry
+// option of set matching the unit of its time argument
+func execExpire(unit string) ExecFunc {
+	return func(e *Executer, args [][]byte) entity.Reply {
+		deadLine, err := DeadLine(unit, args[2], time.Now())
+		if err != nil {
+			return entity.MakeErrReply(err.Error())
+		}
+		return entity.MakeIntReply(e.expireAt(args[1], deadLine))
+	}
+}
+
+func execPing(e *Executer, args [][]byte) entity.Reply {
+	return entity.MakeBulkReply([]byte("pong"))
+}
+
+func execDel(e *Executer, args [][]byte) entity.Reply {
+	var deletedNum int64
+	for i := 1; i < len(args); i++ {
+		if e.exists(args[i]) {
+			e.db.Del(args[i])
+			deletedNum++
+		}
+	}
+	return entity.MakeIntReply(deletedNum)
+}
+
+func execKeys(e *Executer, args [][]byte) entity.Reply {
+	switch string(args[1]) {
+	case "*":
+		keys := e.db.Keys()
+		return entity.MakeMultiBulkReply(keys)
+	default:
+		return entity.MakeErrReply(ParamNotImplementedErr)
+	}
+}
+
+func execExists(e *Executer, args [][]byte) entity.Reply {
+	_, exists, _ := e.db.Get(args[1])
+	if exists {
+		return entity.MakeIntReply(1)
+	}
+	return entity.MakeIntReply(0)
+}
+
+func execTTL(e *Executer, args [][]byte) entity.Reply {
+	return entity.MakeIntReply(e.db.GetLeftLife(args[1]))
+}
+
+func execPersist(e *Executer, args [][]byte) entity.Reply {
+	if !e.exists(args[1]) {
+		return entity.MakeIntReply(0)
+	}
+	return entity.MakeIntReply(e.db.Persist(args[1]))
+}
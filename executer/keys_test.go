@@ -0,0 +1,44 @@
+package executer
+
+import (
+	"kv_storage/datastore"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/godis/lib/utils"
+)
+
+func TestExpire(t *testing.T) {
+	e := NewExecuter(datastore.NewMap())
+	// want is empty for the commands only preparing the key
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"set k v", ""},
+		{"expire k 0", ":1\r\n"},
+		{"exists k", ":0\r\n"},
+		{"set k v", ""},
+		{"pexpire k -1", ":1\r\n"},
+		{"exists k", ":0\r\n"},
+		{"expire k 10", ":0\r\n"},
+		{"set k v", ""},
+		{"expireat k 1", ":1\r\n"},
+		{"exists k", ":0\r\n"},
+		{"set k v", ""},
+		{"expire k 100", ":1\r\n"},
+		{"set k v ex 0", "-invalid expire time\r\n"},
+		{"set k v px -1", "-invalid expire time\r\n"},
+		{"set k v exat 0", "-invalid expire time\r\n"},
+		{"setex k 0 v", "-invalid expire time\r\n"},
+		{"psetex k -1 v", "-invalid expire time\r\n"},
+		{"getex k ex 0", "-invalid expire time\r\n"},
+		{"persist k", ":1\r\n"},
+	}
+	for _, tt := range tests {
+		got := string(e.Execute(utils.ToCmdLine(strings.Fields(tt.cmd)...)).ToBytes())
+		if tt.want != "" && got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package executer
+
+import (
+	"kv_storage/datastore"
+	"kv_storage/entity"
+)
+
+func init() {
+	RegisterCommand("lpush", -3, FlagWrite, 1, 1, 1, execLpush)
+	RegisterCommand("rpush", -3, FlagWrite, 1, 1, 1, execRpush)
+	RegisterCommand("lrange", 4, FlagReadonly, 1, 1, 1, execLrange)
+	RegisterCommand("llen", 2, FlagReadonly, 1, 1, 1, execLlen)
+	RegisterCommand("lindex", 3, FlagReadonly, 1, 1, 1, execLindex)
+	RegisterCommand("linsert", 5, FlagWrite, 1, 1, 1, execLinsert)
+	RegisterCommand("lrem", 4, FlagWrite, 1, 1, 1, execLrem)
+	RegisterCommand("ltrim", 4, FlagWrite, 1, 1, 1, execLtrim)
+	RegisterCommand("lset", 4, FlagWrite, 1, 1, 1, execLset)
+	RegisterCommand("lpop", -2, FlagWrite, 1, 1, 1, execLpop)
+	RegisterCommand("rpop", -2, FlagWrite, 1, 1, 1, execRpop)
+}
+
+func execLpush(e *Executer, args [][]byte) entity.Reply {
+	values, err := prePush(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if num := e.db.Lpush(args[1], values); num == -1 {
+		return entity.MakeErrReply(datastore.ErrTypeNotMatched.Error())
+	} else {
+		return entity.MakeIntReply(num)
+	}
+}
+
+func execRpush(e *Executer, args [][]byte) entity.Reply {
+	values, err := prePush(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if num := e.db.Rpush(args[1], values); num == -1 {
+		return entity.MakeErrReply(datastore.ErrTypeNotMatched.Error())
+	} else {
+		return entity.MakeIntReply(num)
+	}
+}
+
+func execLrange(e *Executer, args [][]byte) entity.Reply {
+	key, start, stop, err := preLrange(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	values, err := e.db.Lrange(key, start, stop)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	return entity.MakeMultiBulkReply(values)
+}
+
+func execLlen(e *Executer, args [][]byte) entity.Reply {
+	return entity.MakeIntReply(int64(e.db.Llen(args[1])))
+}
+
+func execLindex(e *Executer, args [][]byte) entity.Reply {
+	key, index, err := preLindex(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if value, err := e.db.Lindex(key, index); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeBulkReply(value)
+	}
+}
+
+func execLinsert(e *Executer, args [][]byte) entity.Reply {
+	key, before, pivot, value, err := preLinsert(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if length, err := e.db.Linsert(key, before, pivot, value); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeIntReply(int64(length))
+	}
+}
+
+func execLrem(e *Executer, args [][]byte) entity.Reply {
+	key, count, value, err := preLrem(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if removedNum, err := e.db.Lrem(key, count, value); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeIntReply(int64(removedNum))
+	}
+}
+
+func execLtrim(e *Executer, args [][]byte) entity.Reply {
+	key, start, stop, err := preLrange(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if err := e.db.Ltrim(key, start, stop); err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	return entity.MakeOkReply()
+}
+
+func execLset(e *Executer, args [][]byte) entity.Reply {
+	key, index, value, err := preLset(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if e.db.Lset(key, index, value); err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	return entity.MakeOkReply()
+}
+
+func execLpop(e *Executer, args [][]byte) entity.Reply {
+	key, count, err := prePop(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if poped, err := e.db.Lpop(key, count); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeMultiBulkReply(poped)
+	}
+}
+
+func execRpop(e *Executer, args [][]byte) entity.Reply {
+	key, count, err := prePop(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if poped, err := e.db.Rpop(key, count); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeMultiBulkReply(poped)
+	}
+}
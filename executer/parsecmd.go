@@ -6,8 +6,86 @@ import (
 	"kv_storage/entity"
 	"strconv"
 	"strings"
+	"time"
 )
 
+// ExpireUnits maps the expire commands to the expiry option of set with
+// the same unit
+var ExpireUnits = map[string]string{
+	"expire":    "ex",
+	"pexpire":   "px",
+	"expireat":  "exat",
+	"pexpireat": "pxat",
+}
+
+// DeadLine converts the argument of an expiry option, ex and px are relative
+// to now in seconds and milliseconds, exat and pxat are unix time in seconds
+// and milliseconds. The deadline may already have passed, the expire
+// commands then delete the key.
+func DeadLine(option string, arg []byte, now time.Time) (time.Time, error) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch strings.ToLower(option) {
+	case "ex":
+		return now.Add(time.Duration(n) * time.Second), nil
+	case "px":
+		return now.Add(time.Duration(n) * time.Millisecond), nil
+	case "exat":
+		return time.Unix(n, 0), nil
+	case "pxat":
+		return time.UnixMilli(n), nil
+	}
+	return time.Time{}, errors.New(ParamUncorrect)
+}
+
+// lifetimeDeadLine converts the expiry option of set, setex, psetex and
+// getex, unlike the argument of the expire commands it must be positive
+func lifetimeDeadLine(option string, arg []byte, now time.Time) (time.Time, error) {
+	if n, err := strconv.ParseInt(string(arg), 10, 64); err == nil && n <= 0 {
+		return time.Time{}, errors.New(InvalidExpireErr)
+	}
+	return DeadLine(option, arg, now)
+}
+
+// IsExpiryOption reports whether option is one of ex, px, exat and pxat
+func IsExpiryOption(option []byte) bool {
+	switch strings.ToLower(string(option)) {
+	case "ex", "px", "exat", "pxat":
+		return true
+	}
+	return false
+}
+
+// string
+func preSet(args [][]byte, now time.Time) (deadLine time.Time, hasTTL bool, err error) {
+	for i := 3; i < len(args); i += 2 {
+		if hasTTL || !IsExpiryOption(args[i]) || i+1 >= len(args) {
+			err = errors.New(ParamUncorrect)
+			return
+		}
+		if deadLine, err = lifetimeDeadLine(string(args[i]), args[i+1], now); err != nil {
+			return
+		}
+		hasTTL = true
+	}
+	return
+}
+
+func preGetex(args [][]byte, now time.Time) (persist bool, deadLine time.Time, err error) {
+	switch {
+	case len(args) == 2:
+	case len(args) == 3 && strings.EqualFold(string(args[2]), "persist"):
+		persist = true
+	case len(args) == 4 && IsExpiryOption(args[2]):
+		deadLine, err = lifetimeDeadLine(string(args[2]), args[3], now)
+	default:
+		err = errors.New(ParamUncorrect)
+	}
+	return
+}
+
 // list
 func prePush(args [][]byte) ([][]byte, error) {
 	if len(args) < 3 {
@@ -168,7 +246,7 @@ func preZrankByScore(args [][]byte) (key string, min, max *sortedset.ScoreBorder
 			err = entity.MakeErrReply(ParamUncorrect)
 		}
 		return
-	} 
+	}
 	args5 := string(args[5])
 	if argsNum == 7 {
 		if strings.EqualFold(args4, "limit") {
@@ -203,12 +281,3 @@ func preZrankByScore(args [][]byte) (key string, min, max *sortedset.ScoreBorder
 	}
 	return
 }
-
-func GetKey(args [][]byte) (key string, isHeartbeat bool) {
-	if len(args) == 1 && string(args[0]) == "ping" {
-		isHeartbeat = true
-	} else if len(args) >= 2 {
-		key = string(args[1])
-	}
-	return
-}
\ No newline at end of file
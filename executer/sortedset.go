@@ -0,0 +1,138 @@
+package executer
+
+import (
+	"kv_storage/datastruct/sortedset"
+	"kv_storage/entity"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+func init() {
+	RegisterCommand("zadd", -4, FlagWrite, 1, 1, 1, execZadd)
+	RegisterCommand("zrange", -4, FlagReadonly, 1, 1, 1, execZrange)
+	RegisterCommand("zrevrange", -4, FlagReadonly, 1, 1, 1, execZrevrange)
+	RegisterCommand("zrem", -3, FlagWrite, 1, 1, 1, execZrem)
+	RegisterCommand("zcard", 2, FlagReadonly, 1, 1, 1, execZcard)
+	RegisterCommand("zcount", 4, FlagReadonly, 1, 1, 1, execZcount)
+	RegisterCommand("zrangebyscore", -4, FlagReadonly, 1, 1, 1, execZrangeByScore)
+	RegisterCommand("zrevrangebyscore", -4, FlagReadonly, 1, 1, 1, execZrevrangeByScore)
+	RegisterCommand("zrank", 3, FlagReadonly, 1, 1, 1, execZrank)
+	RegisterCommand("zrevrank", 3, FlagReadonly, 1, 1, 1, execZrevrank)
+}
+
+func execZadd(e *Executer, args [][]byte) entity.Reply {
+	names, scores, err := preZadd(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if insertedNum, err := e.db.Zadd(scores, names, string(args[1])); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeIntReply(int64(insertedNum))
+	}
+}
+
+func execZrange(e *Executer, args [][]byte) entity.Reply {
+	start, stop, withScore, err := preZrevrange(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if rs, err := e.db.Zrange(string(args[1]), start, stop, false); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return makeElementsReply(rs, withScore)
+	}
+}
+
+func execZrevrange(e *Executer, args [][]byte) entity.Reply {
+	start, stop, withScore, err := preZrevrange(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if rs, err := e.db.Zrange(string(args[1]), start, stop, true); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return makeElementsReply(rs, withScore)
+	}
+}
+
+func execZrem(e *Executer, args [][]byte) entity.Reply {
+	if removedNum, err := e.db.Zrem(string(args[1]), args[2:]); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeIntReply(removedNum)
+	}
+}
+
+func execZcard(e *Executer, args [][]byte) entity.Reply {
+	if num, err := e.db.Zcard(string(args[1])); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeIntReply(num)
+	}
+}
+
+func execZcount(e *Executer, args [][]byte) entity.Reply {
+	if num, err := e.db.Zcount(string(args[1]), args[2], args[3]); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeIntReply(num)
+	}
+}
+
+func execZrangeByScore(e *Executer, args [][]byte) entity.Reply {
+	key, min, max, withScore, offset, count, err := preZrankByScore(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if rs, err := e.db.ZrangeByScore(key, min, max, false, offset, count); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return makeElementsReply(rs, withScore)
+	}
+}
+
+func execZrevrangeByScore(e *Executer, args [][]byte) entity.Reply {
+	key, min, max, withScore, offset, count, err := preZrankByScore(args)
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if rs, err := e.db.ZrangeByScore(key, min, max, true, offset, count); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return makeElementsReply(rs, withScore)
+	}
+}
+
+func execZrank(e *Executer, args [][]byte) entity.Reply {
+	if rank, err := e.db.Zrank(args[1], args[2], false); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeIntReply(rank)
+	}
+}
+
+func execZrevrank(e *Executer, args [][]byte) entity.Reply {
+	if rank, err := e.db.Zrank(args[1], args[2], true); err != nil {
+		return entity.MakeErrReply(err.Error())
+	} else {
+		return entity.MakeIntReply(rank)
+	}
+}
+
+// makeElementsReply replies the members of elements, with their scores as
+// member score pairs when withScore is set
+func makeElementsReply(elements []sortedset.Element, withScore bool) entity.Reply {
+	if !withScore {
+		members := make([][]byte, len(elements))
+		for i, element := range elements {
+			members[i] = []byte(element.Member)
+		}
+		return entity.MakeMultiBulkReply(members)
+	}
+	pairs := make([]redis.Reply, 0, 2*len(elements))
+	for _, element := range elements {
+		pairs = append(pairs, entity.MakeBulkReply([]byte(element.Member)), entity.MakeDoubleReply(element.Score))
+	}
+	return entity.MakePairsReply(pairs)
+}
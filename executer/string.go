@@ -0,0 +1,107 @@
+package executer
+
+import (
+	"kv_storage/datastore"
+	"kv_storage/entity"
+	"time"
+)
+
+func init() {
+	RegisterCommand("set", -3, FlagWrite, 1, 1, 1, execSet)
+	RegisterCommand("setex", 4, FlagWrite, 1, 1, 1, execSetex("ex"))
+	RegisterCommand("psetex", 4, FlagWrite, 1, 1, 1, execSetex("px"))
+	RegisterCommand("get", 2, FlagReadonly, 1, 1, 1, execGet)
+	RegisterCommand("getex", -2, FlagWrite, 1, 1, 1, execGetex)
+	RegisterCommand("mset", -3, FlagWrite, 1, -1, 2, execMset)
+	RegisterCommand("mget", -2, FlagReadonly, 1, -1, 1, execMget)
+	RegisterCommand("msetnx", -3, FlagWrite, 1, -1, 2, execMsetnx)
+}
+
+// execSetex returns the handler of setex and psetex, unit is the expiry
+// option of set matching the unit of their lifetime argument
+func execSetex(unit string) ExecFunc {
+	return func(e *Executer, args [][]byte) entity.Reply {
+		deadLine, err := lifetimeDeadLine(unit, args[2], time.Now())
+		if err != nil {
+			return entity.MakeErrReply(err.Error())
+		}
+		e.setWithDeadLine(args[1], args[3], deadLine)
+		return entity.MakeStatusReply(NoErr)
+	}
+}
+
+func execSet(e *Executer, args [][]byte) entity.Reply {
+	deadLine, hasTTL, err := preSet(args, time.Now())
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	if hasTTL {
+		e.setWithDeadLine(args[1], args[2], deadLine)
+	} else {
+		e.db.Set(args[1], args[2])
+	}
+	return entity.MakeStatusReply(NoErr)
+}
+
+func execGet(e *Executer, args [][]byte) entity.Reply {
+	value, _, err := e.db.Get(args[1])
+	if err == datastore.ErrKeyExpired {
+		e.deleteExpired(args[1])
+	}
+	return entity.MakeBulkReply(value)
+}
+
+func execGetex(e *Executer, args [][]byte) entity.Reply {
+	persist, deadLine, err := preGetex(args, time.Now())
+	if err != nil {
+		return entity.MakeErrReply(err.Error())
+	}
+	value, exists, err := e.db.Get(args[1])
+	if err == datastore.ErrKeyExpired {
+		e.deleteExpired(args[1])
+	}
+	if err == datastore.ErrTypeNotMatched {
+		return entity.MakeErrReply(err.Error())
+	}
+	if exists && persist {
+		e.db.Persist(args[1])
+	} else if exists && !deadLine.IsZero() {
+		e.expireAt(args[1], deadLine)
+	}
+	return entity.MakeBulkReply(value)
+}
+
+func execMset(e *Executer, args [][]byte) entity.Reply {
+	for i := 1; i < len(args)-1; {
+		e.db.Set(args[i], args[i+1])
+		i += 2
+	}
+	return entity.MakeStatusReply(NoErr)
+}
+
+func execMget(e *Executer, args [][]byte) entity.Reply {
+	var values [][]byte
+	for i := 1; i < len(args); i++ {
+		v, _, err := e.db.Get(args[i])
+		if err == datastore.ErrKeyExpired {
+			e.deleteExpired(args[i])
+		}
+		values = append(values, v)
+	}
+	return entity.MakeMultiBulkReply(values)
+}
+
+func execMsetnx(e *Executer, args [][]byte) entity.Reply {
+	for i := 1; i < len(args)-1; {
+		_, exists, _ := e.db.Get(args[i])
+		if exists {
+			return entity.MakeErrReply("0")
+		}
+		i += 2
+	}
+	for i := 1; i < len(args)-1; {
+		e.db.Set(args[i], args[i+1])
+		i += 2
+	}
+	return entity.MakeStatusReply(NoErr)
+}
@@ -0,0 +1,128 @@
+package latency
+
+import (
+	"math"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+// The histogram counts durations in nanoseconds in log-linear buckets like
+// HdrHistogram: the values below subBucketCount have a bucket each, above
+// that every power of two is split into subBucketCount/2 buckets, so a value
+// is known within about 3%.
+const (
+	subBucketBits  = 6
+	subBucketCount = 1 << subBucketBits
+	subBucketHalf  = subBucketCount / 2
+	// maxValue is about 18 minutes, longer durations are counted as it
+	maxValueBits = 40
+	maxValue     = 1<<maxValueBits - 1
+	bucketCount  = (maxValueBits - subBucketBits + 2) * subBucketHalf
+)
+
+// Histogram counts durations, it is safe for concurrent use and recording
+// does not lock
+type Histogram struct {
+	total  int64
+	counts [bucketCount]int64
+}
+
+// Record counts a duration
+func (h *Histogram) Record(d time.Duration) {
+	v := uint64(d)
+	if d < 0 {
+		v = 0
+	}
+	if v > maxValue {
+		v = maxValue
+	}
+	atomic.AddInt64(&h.counts[bucketIndex(v)], 1)
+	atomic.AddInt64(&h.total, 1)
+}
+
+// Count returns the number of recorded durations
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.total)
+}
+
+func bucketIndex(v uint64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(v) - subBucketBits
+	return shift*subBucketHalf + int(v>>shift)
+}
+
+// bucketHighest returns the highest value counted in the bucket at index
+func bucketHighest(index int) uint64 {
+	if index < subBucketCount {
+		return uint64(index)
+	}
+	shift := index/subBucketHalf - 1
+	sub := uint64(index%subBucketHalf + subBucketHalf)
+	return (sub+1)<<shift - 1
+}
+
+// snapshot copies the counts, the total is their sum so that it agrees with
+// them while durations are being recorded
+func (h *Histogram) snapshot() (counts [bucketCount]int64, total int64) {
+	for i := range h.counts {
+		counts[i] = atomic.LoadInt64(&h.counts[i])
+		total += counts[i]
+	}
+	return counts, total
+}
+
+// Percentiles returns the durations below which the given percentages of the
+// recorded durations are, zero when nothing was recorded
+func (h *Histogram) Percentiles(percents ...float64) []time.Duration {
+	counts, total := h.snapshot()
+	durations := make([]time.Duration, len(percents))
+	if total == 0 {
+		return durations
+	}
+	for i, percent := range percents {
+		rank := int64(math.Ceil(percent / 100 * float64(total)))
+		if rank < 1 {
+			rank = 1
+		}
+		var seen int64
+		for index, count := range counts {
+			seen += count
+			if seen >= rank {
+				durations[i] = time.Duration(bucketHighest(index))
+				break
+			}
+		}
+	}
+	return durations
+}
+
+// Bucket is a power of two number of microseconds and the number of
+// recorded durations up to it
+type Bucket struct {
+	Usec  int64
+	Count int64
+}
+
+// Cumulative returns the recorded durations counted in power of two
+// microseconds buckets, a bucket is only returned when it counts more
+// durations than the previous one
+func (h *Histogram) Cumulative() []Bucket {
+	counts, total := h.snapshot()
+	var buckets []Bucket
+	var seen int64
+	index := 0
+	// the first bucket is 1024ns like in redis
+	for usec, bound := int64(1), uint64(1024); seen < total; usec, bound = usec*2, bound*2 {
+		previous := seen
+		for ; index < bucketCount && bucketHighest(index) < bound; index++ {
+			seen += counts[index]
+		}
+		if seen > previous {
+			buckets = append(buckets, Bucket{Usec: usec, Count: seen})
+		}
+	}
+	return buckets
+}
@@ -0,0 +1,82 @@
+package latency
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBucketIndex(t *testing.T) {
+	previous := -1
+	for _, v := range []uint64{0, 1, 63, 64, 65, 127, 128, 1000, 1023, 1024, 1025, 4095, 1 << 20, 1<<20 + 1, 123456789, maxValue} {
+		index := bucketIndex(v)
+		if index < previous || index >= bucketCount {
+			t.Errorf("bucketIndex(%d) = %d, previous %d, bucket count %d", v, index, previous, bucketCount)
+		}
+		previous = index
+		highest := bucketHighest(index)
+		// a value is known within 1/subBucketHalf
+		if highest < v || highest-v > v/subBucketHalf {
+			t.Errorf("bucketHighest(bucketIndex(%d)) = %d", v, highest)
+		}
+		if index > 0 && bucketHighest(index-1) >= v {
+			t.Errorf("%d counted in bucket %d, the previous one ends at %d", v, index, bucketHighest(index-1))
+		}
+	}
+}
+
+// record returns a histogram of the given durations
+func record(durations ...time.Duration) *Histogram {
+	h := &Histogram{}
+	for _, d := range durations {
+		h.Record(d)
+	}
+	return h
+}
+
+func TestPercentiles(t *testing.T) {
+	var hundred []time.Duration
+	for i := 1; i <= 100; i++ {
+		hundred = append(hundred, time.Duration(i)*time.Microsecond)
+	}
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		percents  []float64
+		want      []time.Duration
+	}{
+		{"empty", nil, []float64{50, 99}, []time.Duration{0, 0}},
+		{"one", []time.Duration{10}, []float64{0, 50, 100}, []time.Duration{10, 10, 10}},
+		{"negative", []time.Duration{-time.Second}, []float64{50}, []time.Duration{0}},
+		{"too long", []time.Duration{time.Hour}, []float64{50}, []time.Duration{maxValue}},
+		{"hundred", hundred, []float64{1, 50, 99, 100}, []time.Duration{1007, 50175, 100351, 100351}},
+	}
+	for _, tt := range tests {
+		h := record(tt.durations...)
+		if got := h.Percentiles(tt.percents...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: percentiles %v = %v, want %v", tt.name, tt.percents, got, tt.want)
+		}
+		if h.Count() != int64(len(tt.durations)) {
+			t.Errorf("%s: count %d, want %d", tt.name, h.Count(), len(tt.durations))
+		}
+	}
+}
+
+func TestCumulative(t *testing.T) {
+	tests := []struct {
+		name      string
+		durations []time.Duration
+		want      []Bucket
+	}{
+		{"empty", nil, nil},
+		{"first bucket", []time.Duration{0, 1023, 1024}, []Bucket{{1, 2}, {2, 3}}},
+		{"doubling", []time.Duration{500, 1500, 3000, 3000}, []Bucket{{1, 1}, {2, 2}, {4, 4}}},
+		// the buckets that count nothing more are left out
+		{"gap", []time.Duration{500, 100 * time.Microsecond}, []Bucket{{1, 1}, {128, 2}}},
+	}
+	for _, tt := range tests {
+		if got := record(tt.durations...).Cumulative(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: cumulative %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Package latency keeps a histogram of the durations of each command and
+// monitors the events slower than a threshold, like the latency monitor of
+// redis
+package latency
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The events reported by the server
+const (
+	EventCommand        = "command"
+	EventAofWrite       = "aof-write"
+	EventAofFsync       = "aof-fsync"
+	EventExpireDel      = "expire-del"
+	EventClusterForward = "cluster-forward"
+)
+
+// historyLen is the number of samples kept by event, one per second at most
+const historyLen = 160
+
+var (
+	// threshold is the duration from which the events are monitored, 0
+	// disables the monitor
+	threshold int64
+	// tracking enables the command histograms
+	tracking int32 = 1
+
+	commands sync.Map // command name to *Histogram
+
+	eventsMu sync.Mutex
+	events   = make(map[string]*event)
+)
+
+// Sample is a duration of an event at a time
+type Sample struct {
+	Time     time.Time
+	Duration time.Duration
+}
+
+type event struct {
+	history []Sample // oldest first
+	max     time.Duration
+}
+
+// Setup sets the threshold of the monitor, 0 disables it, and whether the
+// command histograms are recorded
+func Setup(monitorThreshold time.Duration, trackCommands bool) {
+	atomic.StoreInt64(&threshold, int64(monitorThreshold))
+	if trackCommands {
+		atomic.StoreInt32(&tracking, 1)
+	} else {
+		atomic.StoreInt32(&tracking, 0)
+	}
+}
+
+// Threshold returns the threshold of the monitor
+func Threshold() time.Duration {
+	return time.Duration(atomic.LoadInt64(&threshold))
+}
+
+// Tracking reports whether the command histograms are recorded
+func Tracking() bool {
+	return atomic.LoadInt32(&tracking) == 1
+}
+
+// RecordCommand counts a run of a command in its histogram
+func RecordCommand(name string, d time.Duration) {
+	if !Tracking() {
+		return
+	}
+	h, ok := commands.Load(name)
+	if !ok {
+		h, _ = commands.LoadOrStore(name, &Histogram{})
+	}
+	h.(*Histogram).Record(d)
+}
+
+// CommandHistogram returns the histogram of a command, nil when it never ran
+func CommandHistogram(name string) *Histogram {
+	h, ok := commands.Load(name)
+	if !ok {
+		return nil
+	}
+	return h.(*Histogram)
+}
+
+// Commands returns the names of the commands having a histogram, sorted
+func Commands() []string {
+	var names []string
+	commands.Range(func(name, _ interface{}) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	return names
+}
+
+// AddSample records a duration of an event when it reaches the threshold,
+// the samples of the same second are merged keeping the longest
+func AddSample(name string, d time.Duration) {
+	limit := Threshold()
+	if limit <= 0 || d < limit {
+		return
+	}
+	now := time.Now()
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	e := events[name]
+	if e == nil {
+		e = &event{}
+		events[name] = e
+	}
+	if d > e.max {
+		e.max = d
+	}
+	if n := len(e.history); n > 0 && e.history[n-1].Time.Unix() == now.Unix() {
+		if d > e.history[n-1].Duration {
+			e.history[n-1].Duration = d
+		}
+		return
+	}
+	e.history = append(e.history, Sample{Time: now, Duration: d})
+	if len(e.history) > historyLen {
+		e.history = e.history[len(e.history)-historyLen:]
+	}
+}
+
+// Latest is the last sample of an event and its longest duration
+type Latest struct {
+	Event string
+	Sample
+	Max time.Duration
+}
+
+// LatestSamples returns the last sample of every event, sorted by event
+func LatestSamples() []Latest {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	latest := make([]Latest, 0, len(events))
+	for name, e := range events {
+		latest = append(latest, Latest{Event: name, Sample: e.history[len(e.history)-1], Max: e.max})
+	}
+	sort.Slice(latest, func(i, j int) bool {
+		return latest[i].Event < latest[j].Event
+	})
+	return latest
+}
+
+// History returns a copy of the samples of an event, oldest first
+func History(name string) []Sample {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	e := events[name]
+	if e == nil {
+		return nil
+	}
+	return append([]Sample(nil), e.history...)
+}
+
+// Reset drops the samples of the given events, of all of them when no event
+// is given, and returns the number of events dropped
+func Reset(names ...string) int {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if len(names) == 0 {
+		n := len(events)
+		events = make(map[string]*event)
+		return n
+	}
+	n := 0
+	for _, name := range names {
+		if _, ok := events[name]; ok {
+			delete(events, name)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,256 @@
+// Package logger writes leveled log lines in logfmt or json to stdout or to
+// a file, which is reopened on demand so that it can be rotated
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+// Level is the severity of a log line, the lines below the configured level
+// are dropped
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+	// LevelNothing drops every line
+	LevelNothing
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l >= LevelNothing {
+		return "nothing"
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a loglevel, the redis levels verbose and notice are
+// taken as info
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "verbose", "notice":
+		return LevelInfo, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	case "nothing":
+		return LevelNothing, nil
+	}
+	return LevelInfo, errors.New("invalid loglevel " + s + ", must be one of debug, info, warning, error or nothing")
+}
+
+// Format is how the lines are written
+type Format int
+
+const (
+	FormatLogfmt Format = iota
+	FormatJSON
+)
+
+// ParseFormat parses logfmt or json
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "logfmt":
+		return FormatLogfmt, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return FormatLogfmt, errors.New("invalid log-format " + s + ", must be logfmt or json")
+}
+
+// Logger writes the lines of the level and above
+type Logger struct {
+	level  int32 // a Level, read without locking on the hot path
+	format Format
+
+	mu       sync.Mutex
+	fileName string
+	out      io.Writer
+	file     *os.File
+}
+
+// std is the logger of the package functions, it writes to stdout until
+// Setup is called
+var std = &Logger{level: int32(LevelInfo), out: os.Stdout}
+
+// Setup configures the package logger, an empty fileName logs to stdout
+func Setup(level Level, format Format, fileName string) error {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	atomic.StoreInt32(&std.level, int32(level))
+	std.format = format
+	std.fileName = fileName
+	return std.open()
+}
+
+// Reopen closes and reopens the log file, after logrotate moved it away the
+// lines go to a new file of the same name
+func Reopen() error {
+	std.mu.Lock()
+	defer std.mu.Unlock()
+	return std.open()
+}
+
+// open opens the log file, the caller must hold mu
+func (l *Logger) open() error {
+	if l.fileName == "" {
+		if l.file != nil {
+			l.file.Close()
+			l.file = nil
+		}
+		l.out = os.Stdout
+		return nil
+	}
+	file, err := os.OpenFile(l.fileName, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		// keep logging to the previous output
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file = file
+	l.out = file
+	return nil
+}
+
+// Enabled reports whether the lines of level are written, callers check it
+// before building costly fields
+func Enabled(level Level) bool {
+	return level >= Level(atomic.LoadInt32(&std.level))
+}
+
+// Debug, Info, Warning and Error log msg with fields given as alternating
+// names and values
+func Debug(msg string, fields ...interface{}) {
+	std.log(LevelDebug, msg, fields)
+}
+
+func Info(msg string, fields ...interface{}) {
+	std.log(LevelInfo, msg, fields)
+}
+
+func Warning(msg string, fields ...interface{}) {
+	std.log(LevelWarning, msg, fields)
+}
+
+func Error(msg string, fields ...interface{}) {
+	std.log(LevelError, msg, fields)
+}
+
+func (l *Logger) log(level Level, msg string, fields []interface{}) {
+	if level < Level(atomic.LoadInt32(&l.level)) {
+		return
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var b strings.Builder
+	if l.format == FormatJSON {
+		writeJSON(&b, now, level, msg, fields)
+	} else {
+		writeLogfmt(&b, now, level, msg, fields)
+	}
+	io.WriteString(l.out, b.String())
+}
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+func writeLogfmt(b *strings.Builder, now time.Time, level Level, msg string, fields []interface{}) {
+	b.WriteString("time=" + now.Format(timeFormat))
+	b.WriteString(" level=" + level.String())
+	b.WriteString(" msg=" + logfmtValue(msg))
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteString(" " + fieldName(fields, i) + "=" + logfmtValue(fieldValue(fields, i)))
+	}
+	b.WriteByte('\n')
+}
+
+// logfmtValue quotes s when it is empty or has spaces, quotes, equal signs
+// or unprintable characters
+func logfmtValue(s string) string {
+	needsQuote := strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r)
+	}) >= 0
+	if s == "" || needsQuote {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func writeJSON(b *strings.Builder, now time.Time, level Level, msg string, fields []interface{}) {
+	b.WriteString(`{"time":` + jsonString(now.Format(timeFormat)))
+	b.WriteString(`,"level":` + jsonString(level.String()))
+	b.WriteString(`,"msg":` + jsonString(msg))
+	for i := 0; i < len(fields); i += 2 {
+		b.WriteString("," + jsonString(fieldName(fields, i)) + ":" + jsonValue(fields, i))
+	}
+	b.WriteString("}\n")
+}
+
+// jsonValue writes the numbers and the booleans as such, the other values
+// as strings
+func jsonValue(fields []interface{}, i int) string {
+	if i+1 < len(fields) {
+		switch v := fields[i+1].(type) {
+		case int, int32, int64, uint, uint32, uint64, bool:
+			return fmt.Sprint(v)
+		}
+	}
+	return jsonString(fieldValue(fields, i))
+}
+
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// fieldName returns the name of the field at i, a value given without a
+// name is logged under a name telling so
+func fieldName(fields []interface{}, i int) string {
+	if i+1 >= len(fields) {
+		return "!BADKEY"
+	}
+	if name, ok := fields[i].(string); ok {
+		return name
+	}
+	return fmt.Sprint(fields[i])
+}
+
+func fieldValue(fields []interface{}, i int) string {
+	if i+1 >= len(fields) {
+		return formatValue(fields[i])
+	}
+	return formatValue(fields[i+1])
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprint(v)
+}
@@ -1,17 +1,19 @@
 package parser
 
 import (
-	"time"
 	"bufio"
 	"bytes"
 	"errors"
-	"fmt"
 	"io"
+	"kv_storage/logger"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"time"
 
 	"kv_storage/entity"
+
+	"github.com/hdt3213/godis/interface/redis"
 )
 
 // Payload stores redis.Reply or error
@@ -20,6 +22,116 @@ type Payload struct {
 	Err  error
 }
 
+// ProtocolError reports malformed input, the stream can still be read after
+// it unless it is fatal
+type ProtocolError struct {
+	Msg string
+	// Fatal errors leave the stream in an unknown position, the connection
+	// is closed after them like redis does
+	Fatal bool
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Msg
+}
+
+func protocolError(msg []byte) error {
+	return &ProtocolError{Msg: string(bytes.TrimSuffix(msg, []byte("\r\n")))}
+}
+
+// IsProtocolError reports whether err is caused by malformed input rather
+// than by the underlying reader, fatal tells whether reading must stop
+func IsProtocolError(err error) (ok, fatal bool) {
+	var protocolErr *ProtocolError
+	if !errors.As(err, &protocolErr) {
+		return false, false
+	}
+	return true, protocolErr.Fatal
+}
+
+// maxInlineSize limits the length of a line, like PROTO_INLINE_MAX_SIZE of redis
+const maxInlineSize = 64 * 1024
+
+// bulkPreallocSize is the most memory allocated for a bulk string before its
+// content arrives, larger ones grow as they are read so that a client can't
+// reserve memory by only sending a length
+const bulkPreallocSize = 1024 * 1024
+
+// multiBulkPreallocLen is the most elements allocated for an aggregate
+// before they arrive, like redis larger ones grow as the elements are read
+const multiBulkPreallocLen = 1024
+
+// Limits bounds the requests a client may send
+type Limits struct {
+	// MaxMultiBulkLen limits the number of elements of an aggregate and the
+	// number of arguments of an inline command
+	MaxMultiBulkLen int64
+	// MaxBulkLen limits the size of a bulk string, like proto-max-bulk-len
+	MaxBulkLen int64
+	// MaxQueryBuffer limits the number of bytes of a request, like
+	// client-query-buffer-limit
+	MaxQueryBuffer int64
+	// MaxDepth limits the nesting of the aggregates of a reply, the
+	// commands of the clients are never nested
+	MaxDepth int
+	// MaxElements limits the number of elements of a request or a reply,
+	// those of the nested aggregates included
+	MaxElements int64
+}
+
+// DefaultLimits are the limits of redis
+var DefaultLimits = Limits{
+	MaxMultiBulkLen: 1024 * 1024,
+	MaxBulkLen:      512 * 1024 * 1024,
+	MaxQueryBuffer:  1024 * 1024 * 1024,
+	MaxDepth:        32,
+	MaxElements:     16 * 1024 * 1024,
+}
+
+var (
+	errTooBigInline     = &ProtocolError{Msg: "too big inline request", Fatal: true}
+	errInvalidMultiBulk = &ProtocolError{Msg: "invalid multibulk length", Fatal: true}
+	errInvalidBulk      = &ProtocolError{Msg: "invalid bulk length", Fatal: true}
+	errTooDeep          = &ProtocolError{Msg: "too deeply nested reply", Fatal: true}
+	errTooManyElements  = &ProtocolError{Msg: "too many elements", Fatal: true}
+	errUnbalancedQuotes = &ProtocolError{Msg: "unbalanced quotes in request"}
+
+	// ErrQueryBufferLimit is returned when a request is larger than
+	// MaxQueryBuffer, the client is disconnected without a reply
+	ErrQueryBufferLimit = errors.New("max query buffer length reached")
+)
+
+// requestReader reads a request within limits, size counts the bytes read
+// and elements the elements of the aggregates
+type requestReader struct {
+	*bufio.Reader
+	limits   Limits
+	size     int64
+	elements int64
+}
+
+// consume accounts n bytes of the request against the query buffer limit
+func (r *requestReader) consume(n int64) error {
+	r.size += n
+	if r.size > r.limits.MaxQueryBuffer {
+		return ErrQueryBufferLimit
+	}
+	return nil
+}
+
+// newElements accounts count elements of an aggregate against the element
+// limit and returns the capacity to allocate for them
+func (r *requestReader) newElements(count int64) (int, error) {
+	r.elements += count
+	if r.elements > r.limits.MaxElements {
+		return 0, errTooManyElements
+	}
+	if count > multiBulkPreallocLen {
+		return multiBulkPreallocLen, nil
+	}
+	return int(count), nil
+}
+
 // ParseStream reads data from io.Reader and send payloads through channel
 func ParseStream(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload)
@@ -60,117 +172,26 @@ func ParseOne(data []byte) (entity.Reply, error) {
 	return payload.Data, payload.Err
 }
 
-type readState struct {
-	readingMultiLine  bool
-	expectedArgsCount int
-	msgType           byte
-	args              [][]byte
-	bulkLen           int64
-	readingRepl       bool
-}
-
-func (s *readState) finished() bool {
-	return s.expectedArgsCount > 0 && len(s.args) == s.expectedArgsCount
-}
-
 func Parse0(reader io.Reader, ch chan<- *Payload) {
 	defer func() {
 		if err := recover(); err != nil {
-			fmt.Println(err, string(debug.Stack()))
+			logger.Error("parser panic", "err", err, "stack", string(debug.Stack()))
 			close(ch)
 		}
 	}()
 
 	bufReader := bufio.NewReader(reader)
-	var state readState
-	var err error
-	var msg []byte
 	for {
-		// read line
-		var ioErr bool
-		msg, ioErr, err = readLine(bufReader, &state)
+		reply, err := ReadReply(bufReader)
 		if err != nil {
 			ch <- &Payload{Err: err}
-			if ioErr { // encounter io err, stop read
+			if ok, fatal := IsProtocolError(err); !ok || fatal { // encounter io err, stop read
 				close(ch)
 				return
 			}
-			// protocol err, reset read state
-			state = readState{}
 			continue
 		}
-
-		// parse line
-		if !state.readingMultiLine {
-			// receive new response
-			if msg[0] == '*' {
-				// multi bulk protocol
-				err = parseMultiBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{} // reset state
-					continue
-				}
-				if state.expectedArgsCount == 0 {
-					ch <- &Payload{
-						Data: &entity.EmptyMultiBulkReply{},
-					}
-					state = readState{} // reset state
-					continue
-				}
-			} else if msg[0] == '$' { // bulk protocol
-				err = parseBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					state = readState{} // reset state
-					continue
-				}
-				if state.bulkLen == -1 { // null bulk protocol
-					ch <- &Payload{
-						Data: &entity.NullBulkReply{},
-					}
-					state = readState{} // reset state
-					continue
-				}
-			} else {
-				// single line protocol
-				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{} // reset state
-				continue
-			}
-		} else {
-			// receive following bulk protocol
-			err = readBody(msg, &state)
-			if err != nil {
-				ch <- &Payload{
-					Err: errors.New("protocol error: " + string(msg)),
-				}
-				state = readState{} // reset state
-				continue
-			}
-			// if sending finished
-			if state.finished() {
-				var result entity.Reply
-				if state.msgType == '*' {
-					result = entity.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					result = entity.MakeBulkReply(state.args[0])
-				}
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				state = readState{}
-			}
-		}
+		ch <- &Payload{Data: reply}
 	}
 }
 
@@ -178,7 +199,7 @@ func WaitReplyWithTime(reader io.Reader) (entity.Reply, bool) {
 	select {
 	case payload := <-ParseSingleReply(reader):
 		return payload.Data, false
-	case <- time.After(time.Duration(3)*time.Second):
+	case <-time.After(time.Duration(3) * time.Second):
 		return nil, true
 	}
 }
@@ -187,207 +208,444 @@ func ParseSingleReply(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload, 1)
 	defer func() {
 		if err := recover(); err != nil {
-			fmt.Println(err, string(debug.Stack()))
+			logger.Error("parser panic", "err", err, "stack", string(debug.Stack()))
 		}
 	}()
+	reply, err := ReadReply(bufio.NewReader(reader))
+	ch <- &Payload{Data: reply, Err: err}
+	return ch
+}
 
-	bufReader := bufio.NewReader(reader)
-	var state readState
-	var err error
-	var msg []byte
+// ReadCommand reads the next command of a client within limits, either an
+// array of bulk strings or an inline command. Unlike a reply a command is
+// never nested, so a client can't make the parser recurse. Empty lines and
+// arrays are skipped like redis does
+func ReadCommand(bufReader *bufio.Reader, limits Limits) ([][]byte, error) {
+	r := requestReader{Reader: bufReader, limits: limits}
 	for {
-		// read line
-		// var ioErr bool
-		msg, _, err = readLine(bufReader, &state)
+		line, err := r.readRawLine()
 		if err != nil {
-			ch <- &Payload{Err: err}
-			return ch
-		}
-
-		// parse line
-		if !state.readingMultiLine {
-			// receive new response
-			if msg[0] == '*' {
-				// multi bulk protocol
-				err = parseMultiBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					return ch
-				}
-				if state.expectedArgsCount == 0 {
-					ch <- &Payload{
-						Data: &entity.EmptyMultiBulkReply{},
-					}
-					return ch
-				}
-			} else if msg[0] == '$' { // bulk protocol
-				err = parseBulkHeader(msg, &state)
-				if err != nil {
-					ch <- &Payload{
-						Err: errors.New("protocol error: " + string(msg)),
-					}
-					return ch
-				}
-				if state.bulkLen == -1 { // null bulk protocol
-					ch <- &Payload{
-						Data: &entity.NullBulkReply{},
-					}
-					return ch
-				}
-			} else {
-				// single line protocol
-				result, err := parseSingleLineReply(msg)
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				return ch
-			}
+			return nil, err
+		}
+		var args [][]byte
+		if firstByte(line) == '*' {
+			args, err = r.readMultiBulk(line)
 		} else {
-			// receive following bulk protocol
-			err = readBody(msg, &state)
-			if err != nil {
-				ch <- &Payload{
-					Err: errors.New("protocol error: " + string(msg)),
-				}
-				return ch
-			}
-			// if sending finished
-			if state.finished() {
-				var result entity.Reply
-				if state.msgType == '*' {
-					result = entity.MakeMultiBulkReply(state.args)
-				} else if state.msgType == '$' {
-					result = entity.MakeBulkReply(state.args[0])
-				}
-				ch <- &Payload{
-					Data: result,
-					Err:  err,
-				}
-				return ch
-			}
+			// like redis any other line is an inline command, even one
+			// starting with a type byte
+			args, err = splitArgs(bytes.TrimSuffix(line, []byte{'\r'}), limits.MaxMultiBulkLen)
+		}
+		if err != nil || len(args) > 0 {
+			return args, err
 		}
 	}
 }
 
-func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
+// ReadReply reads the next reply or aof record from bufReader within the
+// default limits, the caller may keep reading after a protocol error that
+// is not fatal
+func ReadReply(bufReader *bufio.Reader) (entity.Reply, error) {
+	return ReadReplyWithLimits(bufReader, DefaultLimits)
+}
+
+// ReadReplyWithLimits is ReadReply with the given limits, a reply over them
+// is a fatal protocol error or ErrQueryBufferLimit
+func ReadReplyWithLimits(bufReader *bufio.Reader, limits Limits) (entity.Reply, error) {
+	r := requestReader{Reader: bufReader, limits: limits}
+	return r.readReply(0)
+}
+
+// readRawLine reads a line up to maxInlineSize bytes without its LF, inline
+// commands typed in telnet or netcat may end with a bare LF so the CR is
+// left to the caller
+func (r *requestReader) readRawLine() ([]byte, error) {
 	var msg []byte
-	var err error
-	if state.bulkLen == 0 { // read normal line
-		msg, err = bufReader.ReadBytes('\n')
+	for {
+		line, err := r.ReadSlice('\n')
+		msg = append(msg, line...)
+		if len(msg) > maxInlineSize {
+			return nil, errTooBigInline
+		}
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+	}
+	if err := r.consume(int64(len(msg))); err != nil {
+		return nil, err
+	}
+	return msg[:len(msg)-1], nil
+}
+
+// readLine reads a line of the protocol, it must end with a CRLF which is
+// removed
+func (r *requestReader) readLine() ([]byte, error) {
+	line, err := r.readRawLine()
+	if err != nil {
+		return nil, err
+	}
+	return trimCRLF(line)
+}
+
+// trimCRLF removes the CR ending a line read by readRawLine
+func trimCRLF(line []byte) ([]byte, error) {
+	if len(line) == 0 || line[len(line)-1] != '\r' {
+		return nil, protocolError(line)
+	}
+	return line[:len(line)-1], nil
+}
+
+func isTypeByte(b byte) bool {
+	return strings.IndexByte("*%~>|$=!+-#:,(_", b) >= 0
+}
+
+// readMultiBulk reads the arguments of a command sent as an array, every
+// element must be a bulk string
+func (r *requestReader) readMultiBulk(line []byte) ([][]byte, error) {
+	msg, err := trimCRLF(line)
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.ParseInt(string(msg[1:]), 10, 64)
+	if err != nil || count > r.limits.MaxMultiBulkLen {
+		return nil, errInvalidMultiBulk
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+	capacity, err := r.newElements(count)
+	if err != nil {
+		return nil, err
+	}
+	args := make([][]byte, 0, capacity)
+	for i := int64(0); i < count; i++ {
+		msg, err := r.readLine()
 		if err != nil {
-			return nil, true, err
+			return nil, err
 		}
-		if len(msg) == 0 || msg[len(msg)-2] != '\r' {
-			return nil, false, errors.New("protocol error: " + string(msg))
+		if firstByte(msg) != '$' {
+			return nil, &ProtocolError{Msg: "expected '$', got '" + string(firstByte(msg)) + "'", Fatal: true}
 		}
-	} else { // read bulk line (binary safe)
-		// there is CRLF between BulkReply in normal stream
-		// but there is no CRLF between RDB and following AOF
-		bulkLen := state.bulkLen + 2
-		if state.readingRepl {
-			bulkLen -= 2
+		size, err := strconv.ParseInt(string(msg[1:]), 10, 64)
+		if err != nil || size < 0 || size > r.limits.MaxBulkLen {
+			return nil, errInvalidBulk
 		}
-		msg = make([]byte, bulkLen)
-		_, err = io.ReadFull(bufReader, msg)
+		body, err := r.readBulkBody(msg, size)
 		if err != nil {
-			return nil, true, err
+			return nil, err
 		}
-		//if len(msg) == 0 ||
-		//	msg[len(msg)-2] != '\r' ||
-		//	msg[len(msg)-1] != '\n' {
-		//	return nil, false, errors.New("protocol error: " + string(msg))
-		//}
-		state.bulkLen = 0
+		args = append(args, body)
 	}
-	return msg, false, nil
+	return args, nil
 }
 
-func parseMultiBulkHeader(msg []byte, state *readState) error {
-	var err error
-	var expectedLine uint64
-	expectedLine, err = strconv.ParseUint(string(msg[1:len(msg)-2]), 10, 32)
+// readReply reads a whole RESP2 or RESP3 value, its aggregates may nest up
+// to MaxDepth levels
+func (r *requestReader) readReply(depth int) (entity.Reply, error) {
+	if depth > r.limits.MaxDepth {
+		return nil, errTooDeep
+	}
+	for {
+		msg, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		switch firstByte(msg) {
+		case '*', '%', '~', '>':
+			return r.readAggregate(msg, depth)
+		case '$', '=', '!':
+			return r.readBlob(msg)
+		case '|':
+			// attributes are out of band metadata of the following reply
+			if _, err = r.readAggregate(msg, depth); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !isTypeByte(firstByte(msg)) {
+			return nil, protocolError(msg)
+		}
+		return parseSingleLineReply(msg)
+	}
+}
+
+// readBlob reads a bulk string, a verbatim string or a blob error
+func (r *requestReader) readBlob(msg []byte) (entity.Reply, error) {
+	size, err := strconv.ParseInt(string(msg[1:]), 10, 64)
+	if err != nil || size < -1 {
+		return nil, protocolError(msg)
+	}
+	if size > r.limits.MaxBulkLen {
+		return nil, errInvalidBulk
+	}
+	if size == -1 { // null bulk protocol
+		return &entity.NullBulkReply{}, nil
+	}
+	body, err := r.readBulkBody(msg, size)
+	if err != nil {
+		return nil, err
+	}
+	switch msg[0] {
+	case '=':
+		if len(body) < 4 || body[3] != ':' {
+			return nil, protocolError(msg)
+		}
+		return entity.MakeVerbatimReply(string(body[:3]), body[4:]), nil
+	case '!':
+		return entity.MakeErrReply(string(body)), nil
+	}
+	return entity.MakeBulkReply(body), nil
+}
+
+// readBulkBody reads the size bytes of a bulk string of header msg and the
+// CRLF after them
+func (r *requestReader) readBulkBody(msg []byte, size int64) ([]byte, error) {
+	if err := r.consume(size + 2); err != nil {
+		return nil, err
+	}
+	body, err := r.readBody(size + 2)
 	if err != nil {
-		return errors.New("protocol error: " + string(msg))
+		return nil, err
+	}
+	if body[size] != '\r' || body[size+1] != '\n' {
+		return nil, protocolError(msg)
 	}
-	if expectedLine == 0 {
-		state.expectedArgsCount = 0
-		return nil
-	} else if expectedLine > 0 {
-		// first line of multi bulk protocol
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = int(expectedLine)
-		state.args = make([][]byte, 0, expectedLine)
-		return nil
+	return body[:size], nil
+}
+
+// readBody reads n bytes, the buffer of a large body grows as it is read
+func (r *requestReader) readBody(n int64) ([]byte, error) {
+	if n <= bulkPreallocSize {
+		body := make([]byte, n)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		return body, nil
+	}
+	body := make([]byte, 0, bulkPreallocSize)
+	for int64(len(body)) < n {
+		if len(body) == cap(body) {
+			body = append(body, 0)[:len(body)]
+		}
+		end := cap(body)
+		if int64(end) > n {
+			end = int(n)
+		}
+		m, err := io.ReadFull(r, body[len(body):end])
+		body = body[:len(body)+m]
+		if err != nil {
+			return nil, err
+		}
 	}
-	return errors.New("protocol error: " + string(msg))
+	return body, nil
 }
 
-func parseBulkHeader(msg []byte, state *readState) error {
-	var err error
-	state.bulkLen, err = strconv.ParseInt(string(msg[1:len(msg)-2]), 10, 64)
+// readAggregate reads the elements of an array, a map, a set or a push,
+// arrays of bulk strings such as commands become a MultiBulkReply
+func (r *requestReader) readAggregate(msg []byte, depth int) (entity.Reply, error) {
+	count, err := strconv.ParseInt(string(msg[1:]), 10, 64)
+	if err != nil || count < -1 {
+		return nil, protocolError(msg)
+	}
+	if count > r.limits.MaxMultiBulkLen {
+		return nil, errInvalidMultiBulk
+	}
+	if count == -1 {
+		return &entity.NullBulkReply{}, nil
+	}
+	if count == 0 && msg[0] == '*' {
+		return &entity.EmptyMultiBulkReply{}, nil
+	}
+	if msg[0] == '%' || msg[0] == '|' {
+		count *= 2
+	}
+	capacity, err := r.newElements(count)
 	if err != nil {
-		return errors.New("protocol error: " + string(msg))
+		return nil, err
 	}
-	if state.bulkLen == -1 { // null bulk
-		return nil
-	} else if state.bulkLen >= 0 {
-		state.msgType = msg[0]
-		state.readingMultiLine = true
-		state.expectedArgsCount = 1
-		state.args = make([][]byte, 0, 1)
-		return nil
+	replies := make([]redis.Reply, 0, capacity)
+	allBulk := true
+	for i := int64(0); i < count; i++ {
+		reply, err := r.readReply(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		switch reply.(type) {
+		case *entity.BulkReply, *entity.NullBulkReply:
+		default:
+			allBulk = false
+		}
+		replies = append(replies, reply)
 	}
-	return errors.New("protocol error: " + string(msg))
+	switch msg[0] {
+	case '%', '|':
+		return entity.MakeMapReply(replies), nil
+	case '~':
+		return entity.MakeSetReply(replies), nil
+	case '>':
+		return entity.MakePushReply(replies), nil
+	}
+	if !allBulk {
+		return entity.MakeMultiRawReply(replies), nil
+	}
+	args := make([][]byte, len(replies))
+	for i, reply := range replies {
+		if bulk, ok := reply.(*entity.BulkReply); ok {
+			args[i] = bulk.Arg
+		}
+	}
+	return entity.MakeMultiBulkReply(args), nil
 }
 
 func parseSingleLineReply(msg []byte) (entity.Reply, error) {
-	str := strings.TrimSuffix(string(msg), "\r\n")
+	str := string(msg)
 	var result entity.Reply
 	switch msg[0] {
 	case '+': // status protocol
 		result = entity.MakeStatusReply(str[1:])
 	case '-': // err protocol
 		result = entity.MakeErrReply(str[1:])
+	case '#': // boolean, or an annotation of the aof
+		switch str {
+		case "#t":
+			result = entity.MakeBoolReply(true)
+		case "#f":
+			result = entity.MakeBoolReply(false)
+		default:
+			result = entity.MakeAnnotationReply(str[1:])
+		}
 	case ':': // int protocol
 		val, err := strconv.ParseInt(str[1:], 10, 64)
 		if err != nil {
-			return nil, errors.New("protocol error: " + string(msg))
+			return nil, protocolError(msg)
 		}
 		result = entity.MakeIntReply(val)
-	default:
-		// parse as text protocol
-		strs := strings.Split(str, " ")
-		args := make([][]byte, len(strs))
-		for i, s := range strs {
-			args[i] = []byte(s)
+	case ',': // double
+		val, err := strconv.ParseFloat(str[1:], 64)
+		if err != nil {
+			return nil, protocolError(msg)
 		}
-		result = entity.MakeMultiBulkReply(args)
+		result = entity.MakeDoubleReply(val)
+	case '(': // big number
+		result = entity.MakeBigNumberReply(str[1:])
+	case '_': // null
+		result = &entity.NullBulkReply{}
 	}
 	return result, nil
 }
 
-// read the non-first lines of multi bulk protocol or bulk protocol
-func readBody(msg []byte, state *readState) error {
-	line := msg[0 : len(msg)-2]
-	var err error
-	if len(line) > 0 && line[0] == '$' {
-		// bulk protocol
-		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
-			return errors.New("protocol error: " + string(msg))
+func firstByte(msg []byte) byte {
+	if len(msg) == 0 {
+		return 0
+	}
+	return msg[0]
+}
+
+// splitArgs splits an inline command into arguments separated by spaces
+// like sdssplitargs of redis. In double quotes \n, \r, \t, \b, \a and \xhh
+// are unescaped, in single quotes only \' is. A closing quote must be
+// followed by a space or the end of the line. There are at most
+// maxArgs arguments
+func splitArgs(line []byte, maxArgs int64) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
 		}
-		if state.bulkLen < 0 { // null bulk in multi bulks
-			state.args = append(state.args, []byte{})
-			state.bulkLen = 0
+		if i == len(line) {
+			return args, nil
 		}
-	} else {
-		state.args = append(state.args, line)
+		if int64(len(args)) == maxArgs {
+			return nil, errInvalidMultiBulk
+		}
+		var arg []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			switch {
+			case inDouble:
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				if line[i] == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					b, _ := strconv.ParseUint(string(line[i+2:i+4]), 16, 8)
+					arg = append(arg, byte(b))
+					i += 3
+				} else if line[i] == '\\' && i+1 < len(line) {
+					i++
+					arg = append(arg, unescape(line[i]))
+				} else if line[i] == '"' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			case inSingle:
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				if line[i] == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if line[i] == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, line[i])
+				}
+			default:
+				if i == len(line) || isSpace(line[i]) {
+					done = true
+					continue
+				}
+				switch line[i] {
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					arg = append(arg, line[i])
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		if arg == nil {
+			arg = []byte{}
+		}
+		args = append(args, arg)
 	}
-	return nil
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
+
+func isHex(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+func unescape(b byte) byte {
+	switch b {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	}
+	return b
 }
 
 func CmdBytesToString(args []byte) string {
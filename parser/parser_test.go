@@ -0,0 +1,190 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"kv_storage/entity"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+func readCommand(input string, limits Limits) ([][]byte, error) {
+	return ReadCommand(bufio.NewReader(strings.NewReader(input)), limits)
+}
+
+func TestReadCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"multi bulk", "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n", []string{"SET", "key", "value"}},
+		{"empty bulk", "*2\r\n$3\r\nGET\r\n$0\r\n\r\n", []string{"GET", ""}},
+		{"binary bulk", "*2\r\n$4\r\nECHO\r\n$4\r\na\r\nb\r\n", []string{"ECHO", "a\r\nb"}},
+		{"inline", "SET key value\r\n", []string{"SET", "key", "value"}},
+		{"inline with bare lf", "PING\n", []string{"PING"}},
+		{"inline quotes", `SET "a b" 'c\'d' "\x41\n"` + "\r\n", []string{"SET", "a b", "c'd", "A\n"}},
+		{"empty lines skipped", "\r\n\r\nPING\r\n", []string{"PING"}},
+		{"empty arrays skipped", "*0\r\n*-1\r\nPING\r\n", []string{"PING"}},
+		{"type byte is inline", "+PING\r\n", []string{"+PING"}},
+		{"bulk header is inline", "$3\r\n", []string{"$3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := readCommand(tt.input, DefaultLimits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(args) != len(tt.want) {
+				t.Fatalf("got %q, want %q", args, tt.want)
+			}
+			for i := range args {
+				if string(args[i]) != tt.want[i] {
+					t.Fatalf("got %q, want %q", args, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadCommandErrors(t *testing.T) {
+	small := Limits{MaxMultiBulkLen: 8, MaxBulkLen: 16, MaxQueryBuffer: 64, MaxDepth: 4, MaxElements: 8}
+	tests := []struct {
+		name   string
+		input  string
+		limits Limits
+		// want is the error, fatal tells a protocol error closing the connection
+		want  error
+		fatal bool
+	}{
+		{"nested array", "*1\r\n*1\r\n$4\r\nPING\r\n", DefaultLimits, nil, true},
+		{"endless nested arrays", strings.Repeat("*1\r\n", 1<<20), DefaultLimits, nil, true},
+		{"integer element", "*1\r\n:1\r\n", DefaultLimits, nil, true},
+		{"invalid count", "*x\r\n", DefaultLimits, errInvalidMultiBulk, true},
+		{"count over the limit", "*9\r\n", small, errInvalidMultiBulk, true},
+		{"negative bulk", "*1\r\n$-1\r\n", DefaultLimits, errInvalidBulk, true},
+		{"bulk over the limit", "*1\r\n$17\r\n", small, errInvalidBulk, true},
+		{"bulk without crlf", "*1\r\n$4\r\nPINGxx", DefaultLimits, nil, false},
+		{"header without cr", "*1\n$4\r\nPING\r\n", DefaultLimits, nil, false},
+		{"too big inline", strings.Repeat("a", maxInlineSize+1), DefaultLimits, errTooBigInline, true},
+		{"unbalanced quotes", "SET \"a\r\n", DefaultLimits, errUnbalancedQuotes, false},
+		{"query buffer limit", "*8\r\n" + strings.Repeat("$16\r\n"+strings.Repeat("a", 16)+"\r\n", 8), small, ErrQueryBufferLimit, false},
+		{"truncated", "*2\r\n$3\r\nGET\r\n", DefaultLimits, io.EOF, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := readCommand(tt.input, tt.limits)
+			if err == nil {
+				t.Fatalf("got %q, want an error", args)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+			if tt.want == nil || tt.fatal {
+				ok, fatal := IsProtocolError(err)
+				if !ok || fatal != tt.fatal {
+					t.Fatalf("got %v, want a protocol error with fatal %v", err, tt.fatal)
+				}
+			}
+		})
+	}
+}
+
+// TestReadCommandPrealloc checks that the length headers of a client do not
+// reserve memory before the content arrives
+func TestReadCommandPrealloc(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"huge count", "*1048576\r\n$1\r\na\r\n"},
+		{"huge bulk", "*1\r\n$536870912\r\naaaa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			if _, err := readCommand(tt.input, DefaultLimits); err == nil {
+				t.Fatal("truncated command read")
+			}
+			runtime.ReadMemStats(&after)
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 4<<20 {
+				t.Fatalf("%d bytes allocated for a truncated command", allocated)
+			}
+		})
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  redis.Reply
+	}{
+		{"status", "+OK\r\n", entity.MakeStatusReply("OK")},
+		{"error", "-ERR bad\r\n", entity.MakeErrReply("ERR bad")},
+		{"int", ":42\r\n", entity.MakeIntReply(42)},
+		{"bulk", "$3\r\nabc\r\n", entity.MakeBulkReply([]byte("abc"))},
+		{"null bulk", "$-1\r\n", &entity.NullBulkReply{}},
+		{"null", "_\r\n", &entity.NullBulkReply{}},
+		{"empty array", "*0\r\n", &entity.EmptyMultiBulkReply{}},
+		{"bulk array", "*2\r\n$1\r\na\r\n$1\r\nb\r\n", entity.MakeMultiBulkReply([][]byte{[]byte("a"), []byte("b")})},
+		{"double", ",1.5\r\n", entity.MakeDoubleReply(1.5)},
+		{"bool", "#t\r\n", entity.MakeBoolReply(true)},
+		{"annotation", "#TS:1:2\r\n", entity.MakeAnnotationReply("TS:1:2")},
+		{"big number", "(12345678901234567890\r\n", entity.MakeBigNumberReply("12345678901234567890")},
+		{"verbatim", "=7\r\ntxt:abc\r\n", entity.MakeVerbatimReply("txt", []byte("abc"))},
+		{"map", "%1\r\n+a\r\n:1\r\n", entity.MakeMapReply([]redis.Reply{entity.MakeStatusReply("a"), entity.MakeIntReply(1)})},
+		{"attribute skipped", "|1\r\n+a\r\n:1\r\n:2\r\n", entity.MakeIntReply(2)},
+		{"nested", "*2\r\n*1\r\n:1\r\n+b\r\n", entity.MakeMultiRawReply([]redis.Reply{
+			entity.MakeMultiRawReply([]redis.Reply{entity.MakeIntReply(1)}), entity.MakeStatusReply("b"),
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := ReadReply(bufio.NewReader(strings.NewReader(tt.input)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, protocol := range []int{entity.RESP2, entity.RESP3} {
+				if got, want := entity.Encode(reply, protocol), entity.Encode(tt.want, protocol); !bytes.Equal(got, want) {
+					t.Fatalf("resp%d: got %q, want %q", protocol, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestReadReplyErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		limits Limits
+		want   error
+	}{
+		{"too deep", strings.Repeat("*1\r\n", 33) + ":1\r\n", DefaultLimits, errTooDeep},
+		{"endless nesting", strings.Repeat("*1\r\n", 1<<20), DefaultLimits, errTooDeep},
+		{"too many nested elements", strings.Repeat("*1048576\r\n", 20), DefaultLimits, errTooManyElements},
+		{"count over the limit", "*1048577\r\n", DefaultLimits, errInvalidMultiBulk},
+		{"bulk over the limit", "$536870913\r\n", DefaultLimits, errInvalidBulk},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := ReadReplyWithLimits(bufio.NewReader(strings.NewReader(tt.input)), tt.limits)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, %v, want %v", reply, err, tt.want)
+			}
+		})
+	}
+	for _, input := range []string{"?\r\n", ":x\r\n", "+OK\n", "$3\r\nabcd\r\n", "=3\r\nabc\r\n"} {
+		_, err := ReadReply(bufio.NewReader(strings.NewReader(input)))
+		if ok, _ := IsProtocolError(err); !ok {
+			t.Errorf("%q: got %v, want a protocol error", input, err)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package rdb
+
+// redis checksums rdb files with the reflected crc-64-jones, it has no
+// initial value or final xor so hash/crc64 can not be used
+const crc64JonesPoly = 0x95AC9329AC4BC9B5
+
+var crc64Table = makeCrc64Table()
+
+func makeCrc64Table() *[256]uint64 {
+	table := new([256]uint64)
+	for i := range table {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ crc64JonesPoly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+func crc64Update(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
+
+// crc64Writer accumulates the checksum of everything written to it
+type crc64Writer struct {
+	sum uint64
+}
+
+func (w *crc64Writer) Write(p []byte) (int, error) {
+	w.sum = crc64Update(w.sum, p)
+	return len(p), nil
+}
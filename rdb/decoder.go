@@ -0,0 +1,498 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+)
+
+// maxPrealloc bounds what is allocated up front for a length read from the
+// file, a corrupt length then runs into the end of the file instead of
+// allocating all of it
+const maxPrealloc = 1024
+
+// Decoder reads objects from a redis rdb file
+type Decoder struct {
+	reader  *bufio.Reader
+	crc     crc64Writer
+	version int
+	buf     [8]byte
+}
+
+func NewDecoder(reader io.Reader) *Decoder {
+	return &Decoder{reader: bufio.NewReader(reader)}
+}
+
+// Parse calls consumer with every object in the file, it stops early when
+// consumer returns false. Aux fields, module data and functions are skipped.
+func (dec *Decoder) Parse(consumer func(object *Object) bool) error {
+	if err := dec.readHeader(); err != nil {
+		return err
+	}
+	db := 0
+	var expiration *time.Time
+	for {
+		op, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case opEOF:
+			return dec.verifyChecksum()
+		case opAux:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opSelectDB:
+			n, _, err := dec.readLength()
+			if err != nil {
+				return err
+			}
+			db = int(n)
+		case opResizeDB:
+			if _, _, err = dec.readLength(); err != nil {
+				return err
+			}
+			if _, _, err = dec.readLength(); err != nil {
+				return err
+			}
+		case opExpireTimeMs:
+			if err = dec.readFull(dec.buf[:8]); err != nil {
+				return err
+			}
+			t := time.UnixMilli(int64(binary.LittleEndian.Uint64(dec.buf[:8])))
+			expiration = &t
+		case opExpireTime:
+			if err = dec.readFull(dec.buf[:4]); err != nil {
+				return err
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(dec.buf[:4])), 0)
+			expiration = &t
+		case opFreq:
+			if _, err = dec.readByte(); err != nil {
+				return err
+			}
+		case opIdle:
+			if _, _, err = dec.readLength(); err != nil {
+				return err
+			}
+		case opFunction2:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opModuleAux:
+			return errors.New("rdb module data is not supported")
+		default:
+			object, err := dec.readObject(op)
+			if err != nil {
+				return err
+			}
+			object.DB = db
+			object.Expiration = expiration
+			expiration = nil
+			if !consumer(object) {
+				return nil
+			}
+		}
+	}
+}
+
+func (dec *Decoder) readHeader() error {
+	header := make([]byte, 9)
+	if err := dec.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:5]) != "REDIS" {
+		return ErrBadMagic
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return ErrBadMagic
+	}
+	if version < 1 || version > maxReadVersion {
+		return ErrBadVersion
+	}
+	dec.version = version
+	return nil
+}
+
+// verifyChecksum checks the crc64 that follows the EOF opcode since version
+// 5, a zero checksum means the writer disabled it
+func (dec *Decoder) verifyChecksum() error {
+	if dec.version < 5 {
+		return nil
+	}
+	sum := dec.crc.sum
+	if _, err := io.ReadFull(dec.reader, dec.buf[:8]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	expected := binary.LittleEndian.Uint64(dec.buf[:8])
+	if expected != 0 && expected != sum {
+		return ErrBadChecksum
+	}
+	return nil
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	b, err := dec.reader.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			return 0, io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	dec.crc.Write([]byte{b})
+	return b, nil
+}
+
+func (dec *Decoder) readFull(p []byte) error {
+	if _, err := io.ReadFull(dec.reader, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	dec.crc.Write(p)
+	return nil
+}
+
+// readLength reads a length, or the encoding of a special string when
+// encoded is true
+func (dec *Decoder) readLength() (length uint64, encoded bool, err error) {
+	first, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit:
+		next, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case lenEnc:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case len32Bit:
+		if err = dec.readFull(dec.buf[:4]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(dec.buf[:4])), false, nil
+	case len64Bit:
+		if err = dec.readFull(dec.buf[:8]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(dec.buf[:8]), false, nil
+	}
+	return 0, false, fmt.Errorf("invalid length encoding %#x", first)
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, encoded, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return dec.readBytes(length)
+	}
+	switch length {
+	case encInt8:
+		b, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int8(b)))), nil
+	case encInt16:
+		if err = dec.readFull(dec.buf[:2]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int16(binary.LittleEndian.Uint16(dec.buf[:2]))))), nil
+	case encInt32:
+		if err = dec.readFull(dec.buf[:4]); err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(int(int32(binary.LittleEndian.Uint32(dec.buf[:4]))))), nil
+	case encLZF:
+		compressedLen, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, _, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		compressed, err := dec.readBytes(compressedLen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(rawLen))
+	}
+	return nil, fmt.Errorf("invalid string encoding %d", length)
+}
+
+// readBytes reads n bytes, growing the buffer as they are read
+func (dec *Decoder) readBytes(n uint64) ([]byte, error) {
+	s := make([]byte, 0, prealloc(n))
+	for uint64(len(s)) < n {
+		chunk := n - uint64(len(s))
+		if chunk > uint64(len(s))+maxPrealloc {
+			chunk = uint64(len(s)) + maxPrealloc
+		}
+		start := len(s)
+		s = append(s, make([]byte, chunk)...)
+		if err := dec.readFull(s[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func prealloc(n uint64) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return int(n)
+}
+
+// readFloat reads the string encoded score of the old zset type
+func (dec *Decoder) readFloat() (float64, error) {
+	n, err := dec.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	s := make([]byte, n)
+	if err = dec.readFull(s); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(s), 64)
+}
+
+func (dec *Decoder) readBinaryFloat() (float64, error) {
+	if err := dec.readFull(dec.buf[:8]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(dec.buf[:8])), nil
+}
+
+func (dec *Decoder) readStrings() ([][]byte, error) {
+	n, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	items := make([][]byte, 0, prealloc(n))
+	for i := uint64(0); i < n; i++ {
+		item, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (dec *Decoder) readObject(valueType byte) (*Object, error) {
+	key, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	object := &Object{Key: string(key)}
+	switch valueType {
+	case typeString:
+		object.Kind = StringKind
+		object.String, err = dec.readString()
+	case typeList:
+		object.Kind = ListKind
+		object.List, err = dec.readStrings()
+	case typeSet:
+		object.Kind = SetKind
+		object.Set, err = dec.readStrings()
+	case typeZset, typeZset2:
+		object.Kind = ZSetKind
+		object.ZSet, err = dec.readZSet(valueType == typeZset2)
+	case typeHash:
+		object.Kind = HashKind
+		var pairs [][]byte
+		if pairs, err = dec.readPairs(); err == nil {
+			object.Hash = pairsToHash(pairs)
+		}
+	case typeHashZipmap:
+		object.Kind = HashKind
+		var blob []byte
+		if blob, err = dec.readString(); err == nil {
+			var pairs [][]byte
+			if pairs, err = parseZipmap(blob); err == nil {
+				object.Hash = pairsToHash(pairs)
+			}
+		}
+	case typeListZiplist:
+		object.Kind = ListKind
+		object.List, err = dec.readPacked(parseZiplist)
+	case typeSetIntset:
+		object.Kind = SetKind
+		object.Set, err = dec.readPacked(parseIntset)
+	case typeSetListpack:
+		object.Kind = SetKind
+		object.Set, err = dec.readPacked(parseListpack)
+	case typeZsetZiplist, typeZsetListpack:
+		object.Kind = ZSetKind
+		parse := parseZiplist
+		if valueType == typeZsetListpack {
+			parse = parseListpack
+		}
+		var items [][]byte
+		if items, err = dec.readPacked(parse); err == nil {
+			object.ZSet, err = pairsToZSet(items)
+		}
+	case typeHashZiplist, typeHashListpack:
+		object.Kind = HashKind
+		parse := parseZiplist
+		if valueType == typeHashListpack {
+			parse = parseListpack
+		}
+		var items [][]byte
+		if items, err = dec.readPacked(parse); err == nil {
+			object.Hash = pairsToHash(items)
+		}
+	case typeListQuicklist, typeListQuicklist2:
+		object.Kind = ListKind
+		object.List, err = dec.readQuicklist(valueType == typeListQuicklist2)
+	default:
+		return nil, fmt.Errorf("rdb value type %d of key %s is not supported", valueType, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+func (dec *Decoder) readZSet(binaryScore bool) ([]ZSetEntry, error) {
+	n, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ZSetEntry, 0, prealloc(n))
+	for i := uint64(0); i < n; i++ {
+		member, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		var score float64
+		if binaryScore {
+			score, err = dec.readBinaryFloat()
+		} else {
+			score, err = dec.readFloat()
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, ZSetEntry{Member: string(member), Score: score})
+	}
+	return entries, nil
+}
+
+func (dec *Decoder) readPairs() ([][]byte, error) {
+	n, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	items := make([][]byte, 0, 2*prealloc(n))
+	for i := uint64(0); i < n; i++ {
+		field, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		value, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, field, value)
+	}
+	return items, nil
+}
+
+// readPacked reads a string holding a ziplist, listpack or intset
+func (dec *Decoder) readPacked(parse func([]byte) ([][]byte, error)) ([][]byte, error) {
+	blob, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	return parse(blob)
+}
+
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+func (dec *Decoder) readQuicklist(listpackNodes bool) ([][]byte, error) {
+	n, _, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	var items [][]byte
+	for i := uint64(0); i < n; i++ {
+		container := uint64(quicklistNodePacked)
+		if listpackNodes {
+			if container, _, err = dec.readLength(); err != nil {
+				return nil, err
+			}
+		}
+		blob, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if container == quicklistNodePlain {
+			items = append(items, blob)
+			continue
+		}
+		parse := parseZiplist
+		if listpackNodes {
+			parse = parseListpack
+		}
+		nodeItems, err := parse(blob)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, nodeItems...)
+	}
+	return items, nil
+}
+
+func pairsToHash(items [][]byte) map[string][]byte {
+	hash := make(map[string][]byte, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		hash[string(items[i])] = items[i+1]
+	}
+	return hash
+}
+
+func pairsToZSet(items [][]byte) ([]ZSetEntry, error) {
+	entries := make([]ZSetEntry, 0, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		score, err := strconv.ParseFloat(string(items[i+1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, ZSetEntry{Member: string(items[i]), Score: score})
+	}
+	return entries, nil
+}
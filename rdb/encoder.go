@@ -0,0 +1,172 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Encoder writes objects as a redis rdb file, values use the plain
+// encodings every redis since 5.0 can load
+type Encoder struct {
+	writer *bufio.Writer
+	crc    *crc64Writer
+	db     int
+	buf    [9]byte
+}
+
+func NewEncoder(writer io.Writer) *Encoder {
+	crc := &crc64Writer{}
+	return &Encoder{
+		writer: bufio.NewWriter(io.MultiWriter(writer, crc)),
+		crc:    crc,
+		db:     -1,
+	}
+}
+
+func (enc *Encoder) WriteHeader() error {
+	if _, err := fmt.Fprintf(enc.writer, "REDIS%04d", writeVersion); err != nil {
+		return err
+	}
+	enc.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	enc.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	return nil
+}
+
+func (enc *Encoder) writeAux(key, value string) {
+	enc.writer.WriteByte(opAux)
+	enc.writeString([]byte(key))
+	enc.writeString([]byte(value))
+}
+
+// WriteObject writes a key, objects of the same db have to be written together
+func (enc *Encoder) WriteObject(object *Object) error {
+	if object.DB != enc.db {
+		enc.writer.WriteByte(opSelectDB)
+		enc.writeLength(uint64(object.DB))
+		enc.db = object.DB
+	}
+	if object.Expiration != nil {
+		enc.writer.WriteByte(opExpireTimeMs)
+		binary.LittleEndian.PutUint64(enc.buf[:8], uint64(object.Expiration.UnixMilli()))
+		enc.writer.Write(enc.buf[:8])
+	}
+	switch object.Kind {
+	case StringKind:
+		enc.writer.WriteByte(typeString)
+		enc.writeString([]byte(object.Key))
+		enc.writeString(object.String)
+	case ListKind:
+		enc.writer.WriteByte(typeList)
+		enc.writeString([]byte(object.Key))
+		enc.writeStrings(object.List)
+	case SetKind:
+		enc.writer.WriteByte(typeSet)
+		enc.writeString([]byte(object.Key))
+		enc.writeStrings(object.Set)
+	case ZSetKind:
+		enc.writer.WriteByte(typeZset2)
+		enc.writeString([]byte(object.Key))
+		enc.writeLength(uint64(len(object.ZSet)))
+		for _, entry := range object.ZSet {
+			enc.writeString([]byte(entry.Member))
+			binary.LittleEndian.PutUint64(enc.buf[:8], math.Float64bits(entry.Score))
+			enc.writer.Write(enc.buf[:8])
+		}
+	case HashKind:
+		enc.writer.WriteByte(typeHash)
+		enc.writeString([]byte(object.Key))
+		enc.writeLength(uint64(len(object.Hash)))
+		fields := make([]string, 0, len(object.Hash))
+		for field := range object.Hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			enc.writeString([]byte(field))
+			enc.writeString(object.Hash[field])
+		}
+	default:
+		return fmt.Errorf("unknown kind %q of key %s", object.Kind, object.Key)
+	}
+	return nil
+}
+
+// WriteFooter ends the file with the EOF opcode and the checksum
+func (enc *Encoder) WriteFooter() error {
+	enc.writer.WriteByte(opEOF)
+	if err := enc.writer.Flush(); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(enc.buf[:8], enc.crc.sum)
+	enc.writer.Write(enc.buf[:8])
+	return enc.writer.Flush()
+}
+
+func (enc *Encoder) writeLength(n uint64) {
+	switch {
+	case n < 1<<6:
+		enc.writer.WriteByte(byte(n))
+	case n < 1<<14:
+		enc.writer.WriteByte(byte(n>>8) | len14Bit<<6)
+		enc.writer.WriteByte(byte(n))
+	case n <= math.MaxUint32:
+		enc.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(enc.buf[1:5], uint32(n))
+		enc.writer.Write(enc.buf[:5])
+	default:
+		enc.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(enc.buf[1:9], n)
+		enc.writer.Write(enc.buf[:9])
+	}
+}
+
+// writeString writes s as an integer or lzf compressed when that is
+// smaller, like redis does
+func (enc *Encoder) writeString(s []byte) {
+	if len(s) <= 11 {
+		if v, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(v, 10) == string(s) {
+			enc.writeIntString(v)
+			return
+		}
+	}
+	if len(s) > 20 {
+		if compressed := lzfCompress(s); compressed != nil {
+			enc.writer.WriteByte(lenEnc<<6 | encLZF)
+			enc.writeLength(uint64(len(compressed)))
+			enc.writeLength(uint64(len(s)))
+			enc.writer.Write(compressed)
+			return
+		}
+	}
+	enc.writeLength(uint64(len(s)))
+	enc.writer.Write(s)
+}
+
+func (enc *Encoder) writeIntString(v int64) {
+	switch {
+	case v >= math.MinInt8 && v <= math.MaxInt8:
+		enc.writer.WriteByte(lenEnc<<6 | encInt8)
+		enc.writer.WriteByte(byte(int8(v)))
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		enc.writer.WriteByte(lenEnc<<6 | encInt16)
+		binary.LittleEndian.PutUint16(enc.buf[:2], uint16(int16(v)))
+		enc.writer.Write(enc.buf[:2])
+	default:
+		enc.writer.WriteByte(lenEnc<<6 | encInt32)
+		binary.LittleEndian.PutUint32(enc.buf[:4], uint32(int32(v)))
+		enc.writer.Write(enc.buf[:4])
+	}
+}
+
+func (enc *Encoder) writeStrings(items [][]byte) {
+	enc.writeLength(uint64(len(items)))
+	for _, item := range items {
+		enc.writeString(item)
+	}
+}
@@ -0,0 +1,128 @@
+package rdb
+
+import "errors"
+
+var errLZFCorrupted = errors.New("corrupted lzf data")
+
+// lzfMaxExpansion is the most bytes one byte of lzf data expands to, a back
+// reference of 3 bytes copies at most 264 bytes
+const lzfMaxExpansion = 88
+
+// lzfDecompress expands data produced by liblzf into a buffer of outLen bytes
+func lzfDecompress(in []byte, outLen int) ([]byte, error) {
+	if outLen < 0 || outLen > len(in)*lzfMaxExpansion {
+		return nil, errLZFCorrupted
+	}
+	out := make([]byte, 0, outLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 { // literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > outLen {
+				return nil, errLZFCorrupted
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+		// back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errLZFCorrupted
+			}
+			n += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZFCorrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		n += 2
+		if ref < 0 || len(out)+n > outLen {
+			return nil, errLZFCorrupted
+		}
+		// the reference may overlap the bytes being produced
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != outLen {
+		return nil, errLZFCorrupted
+	}
+	return out, nil
+}
+
+const (
+	lzfHashLog    = 14
+	lzfMaxLiteral = 1 << 5
+	lzfMaxOffset  = 1 << 13
+	lzfMaxRef     = 1<<8 + 1<<3
+)
+
+// lzfCompress compresses in the way liblzf does, it returns nil when the
+// output would not be smaller than the input
+func lzfCompress(in []byte) []byte {
+	if len(in) < 4 {
+		return nil
+	}
+	var table [1 << lzfHashLog]int
+	out := make([]byte, 0, len(in))
+	literal := make([]byte, 0, lzfMaxLiteral)
+	flush := func() {
+		if len(literal) > 0 {
+			out = append(out, byte(len(literal)-1))
+			out = append(out, literal...)
+			literal = literal[:0]
+		}
+	}
+	hash := func(i int) int {
+		v := uint32(in[i])<<16 | uint32(in[i+1])<<8 | uint32(in[i+2])
+		return int((v * 2654435761) >> (32 - lzfHashLog))
+	}
+	i := 0
+	for i < len(in)-2 {
+		h := hash(i)
+		ref := table[h] - 1
+		table[h] = i + 1
+		off := i - ref - 1
+		if ref >= 0 && off < lzfMaxOffset &&
+			in[ref] == in[i] && in[ref+1] == in[i+1] && in[ref+2] == in[i+2] {
+			n := 3
+			for n < lzfMaxRef && i+n < len(in) && in[ref+n] == in[i+n] {
+				n++
+			}
+			flush()
+			n -= 2
+			if n < 7 {
+				out = append(out, byte(off>>8+n<<5))
+			} else {
+				out = append(out, byte(off>>8+7<<5), byte(n-7))
+			}
+			out = append(out, byte(off))
+			i += n + 2
+			if len(out) >= len(in) {
+				return nil
+			}
+			continue
+		}
+		literal = append(literal, in[i])
+		i++
+		if len(literal) == lzfMaxLiteral {
+			flush()
+		}
+	}
+	for ; i < len(in); i++ {
+		literal = append(literal, in[i])
+		if len(literal) == lzfMaxLiteral {
+			flush()
+		}
+	}
+	flush()
+	if len(out) >= len(in) {
+		return nil
+	}
+	return out
+}
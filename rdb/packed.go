@@ -0,0 +1,261 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+// the compact encodings redis stores small collections in
+
+var errPackedCorrupted = errors.New("corrupted packed encoding")
+
+// parseZiplist returns the entries of a ziplist, integers are formatted as strings
+func parseZiplist(blob []byte) ([][]byte, error) {
+	if len(blob) < 11 {
+		return nil, errPackedCorrupted
+	}
+	var items [][]byte
+	p := 10
+	for {
+		if p >= len(blob) {
+			return nil, errPackedCorrupted
+		}
+		if blob[p] == 0xFF {
+			return items, nil
+		}
+		// previous entry length
+		if blob[p] == 0xFE {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(blob) {
+			return nil, errPackedCorrupted
+		}
+		enc := blob[p]
+		p++
+		var item []byte
+		var err error
+		switch enc >> 6 {
+		case 0:
+			item, p, err = slice(blob, p, int(enc&0x3f))
+		case 1:
+			if p >= len(blob) {
+				return nil, errPackedCorrupted
+			}
+			n := int(enc&0x3f)<<8 | int(blob[p])
+			item, p, err = slice(blob, p+1, n)
+		case 2:
+			if p+4 > len(blob) {
+				return nil, errPackedCorrupted
+			}
+			n := int(binary.BigEndian.Uint32(blob[p:]))
+			item, p, err = slice(blob, p+4, n)
+		default:
+			var v int64
+			switch enc {
+			case 0xC0:
+				v, p, err = readInt(blob, p, 2)
+			case 0xD0:
+				v, p, err = readInt(blob, p, 4)
+			case 0xE0:
+				v, p, err = readInt(blob, p, 8)
+			case 0xF0:
+				v, p, err = readInt(blob, p, 3)
+			case 0xFE:
+				v, p, err = readInt(blob, p, 1)
+			default:
+				if enc < 0xF1 || enc > 0xFD {
+					return nil, errPackedCorrupted
+				}
+				v = int64(enc&0x0f) - 1
+			}
+			item = []byte(strconv.FormatInt(v, 10))
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// parseListpack returns the entries of a listpack, integers are formatted as strings
+func parseListpack(blob []byte) ([][]byte, error) {
+	if len(blob) < 7 {
+		return nil, errPackedCorrupted
+	}
+	var items [][]byte
+	p := 6
+	for {
+		if p >= len(blob) {
+			return nil, errPackedCorrupted
+		}
+		enc := blob[p]
+		if enc == 0xFF {
+			return items, nil
+		}
+		start := p
+		p++
+		var item []byte
+		var v int64
+		var isInt bool
+		var err error
+		switch {
+		case enc&0x80 == 0: // 7 bit unsigned int
+			v, isInt = int64(enc&0x7f), true
+		case enc&0xC0 == 0x80: // 6 bit string length
+			item, p, err = slice(blob, p, int(enc&0x3f))
+		case enc&0xE0 == 0xC0: // 13 bit signed int
+			if p >= len(blob) {
+				return nil, errPackedCorrupted
+			}
+			v, isInt = int64(enc&0x1f)<<8|int64(blob[p]), true
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			p++
+		case enc&0xF0 == 0xE0: // 12 bit string length
+			if p >= len(blob) {
+				return nil, errPackedCorrupted
+			}
+			n := int(enc&0x0f)<<8 | int(blob[p])
+			item, p, err = slice(blob, p+1, n)
+		case enc == 0xF0: // 32 bit string length
+			if p+4 > len(blob) {
+				return nil, errPackedCorrupted
+			}
+			n := int(binary.LittleEndian.Uint32(blob[p:]))
+			item, p, err = slice(blob, p+4, n)
+		case enc == 0xF1:
+			v, p, err = readInt(blob, p, 2)
+			isInt = true
+		case enc == 0xF2:
+			v, p, err = readInt(blob, p, 3)
+			isInt = true
+		case enc == 0xF3:
+			v, p, err = readInt(blob, p, 4)
+			isInt = true
+		case enc == 0xF4:
+			v, p, err = readInt(blob, p, 8)
+			isInt = true
+		default:
+			return nil, errPackedCorrupted
+		}
+		if err != nil {
+			return nil, err
+		}
+		if isInt {
+			item = []byte(strconv.FormatInt(v, 10))
+		}
+		items = append(items, item)
+		p += listpackBacklenSize(p - start)
+	}
+}
+
+// listpackBacklenSize returns the number of bytes the back length of an
+// entry of n bytes takes
+func listpackBacklenSize(n int) int {
+	switch {
+	case n <= 127:
+		return 1
+	case n < 16383:
+		return 2
+	case n < 2097151:
+		return 3
+	case n < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// parseIntset returns the members of an intset formatted as strings
+func parseIntset(blob []byte) ([][]byte, error) {
+	if len(blob) < 8 {
+		return nil, errPackedCorrupted
+	}
+	width := int(binary.LittleEndian.Uint32(blob))
+	n := int(binary.LittleEndian.Uint32(blob[4:]))
+	if (width != 2 && width != 4 && width != 8) || 8+n*width > len(blob) {
+		return nil, errPackedCorrupted
+	}
+	items := make([][]byte, 0, n)
+	p := 8
+	for i := 0; i < n; i++ {
+		var v int64
+		v, p, _ = readInt(blob, p, width)
+		items = append(items, []byte(strconv.FormatInt(v, 10)))
+	}
+	return items, nil
+}
+
+// parseZipmap returns the fields and values of the zipmap of old hashes
+func parseZipmap(blob []byte) ([][]byte, error) {
+	if len(blob) < 2 {
+		return nil, errPackedCorrupted
+	}
+	var items [][]byte
+	p := 1
+	readLen := func() (int, bool) {
+		if p >= len(blob) {
+			return 0, false
+		}
+		switch b := blob[p]; {
+		case b < 254:
+			p++
+			return int(b), true
+		case b == 254:
+			if p+5 > len(blob) {
+				return 0, false
+			}
+			n := int(binary.LittleEndian.Uint32(blob[p+1:]))
+			p += 5
+			return n, true
+		}
+		return 0, false
+	}
+	for p < len(blob) && blob[p] != 0xFF {
+		n, ok := readLen()
+		if !ok {
+			return nil, errPackedCorrupted
+		}
+		field, next, err := slice(blob, p, n)
+		if err != nil {
+			return nil, err
+		}
+		p = next
+		if n, ok = readLen(); !ok || p >= len(blob) {
+			return nil, errPackedCorrupted
+		}
+		free := int(blob[p])
+		value, next, err := slice(blob, p+1, n)
+		if err != nil {
+			return nil, err
+		}
+		p = next + free
+		items = append(items, field, value)
+	}
+	return items, nil
+}
+
+func slice(blob []byte, p, n int) ([]byte, int, error) {
+	if n < 0 || p+n > len(blob) {
+		return nil, p, errPackedCorrupted
+	}
+	item := make([]byte, n)
+	copy(item, blob[p:p+n])
+	return item, p + n, nil
+}
+
+// readInt reads a little endian signed integer of width bytes
+func readInt(blob []byte, p, width int) (int64, int, error) {
+	if p+width > len(blob) {
+		return 0, p, errPackedCorrupted
+	}
+	var u uint64
+	for i := width - 1; i >= 0; i-- {
+		u = u<<8 | uint64(blob[p+i])
+	}
+	shift := uint(64 - 8*width)
+	return int64(u<<shift) >> shift, p + width, nil
+}
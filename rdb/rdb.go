@@ -0,0 +1,90 @@
+// Package rdb reads and writes the RDB snapshot files of redis so that
+// datasets can be moved between this server and redis.
+package rdb
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	typeString         = 0
+	typeList           = 1
+	typeSet            = 2
+	typeZset           = 3
+	typeHash           = 4
+	typeZset2          = 5
+	typeHashZipmap     = 9
+	typeListZiplist    = 10
+	typeSetIntset      = 11
+	typeZsetZiplist    = 12
+	typeHashZiplist    = 13
+	typeListQuicklist  = 14
+	typeHashListpack   = 16
+	typeZsetListpack   = 17
+	typeListQuicklist2 = 18
+	typeSetListpack    = 20
+
+	opFunction2    = 0xF5
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMs = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+
+	len6Bit  = 0
+	len14Bit = 1
+	len32Bit = 0x80
+	len64Bit = 0x81
+	lenEnc   = 3
+
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+
+	// the version written by Encoder, loadable by redis 5.0 and later
+	writeVersion = 9
+	// the newest version Decoder understands
+	maxReadVersion = 12
+)
+
+// Kinds of the values held by an Object
+const (
+	StringKind = "string"
+	ListKind   = "list"
+	SetKind    = "set"
+	ZSetKind   = "zset"
+	HashKind   = "hash"
+)
+
+var (
+	ErrBadMagic    = errors.New("not a redis rdb file")
+	ErrBadVersion  = errors.New("unsupported rdb version")
+	ErrBadChecksum = errors.New("rdb checksum mismatch")
+)
+
+// ZSetEntry is a member of a sorted set
+type ZSetEntry struct {
+	Member string
+	Score  float64
+}
+
+// Object is a key read from or written to an rdb file, only the field that
+// matches Kind is set
+type Object struct {
+	DB         int
+	Key        string
+	Kind       string
+	Expiration *time.Time
+
+	String []byte
+	List   [][]byte
+	Set    [][]byte
+	ZSet   []ZSetEntry
+	Hash   map[string][]byte
+}
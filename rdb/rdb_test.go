@@ -0,0 +1,235 @@
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encode(t *testing.T, objects []*Object) []byte {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range objects {
+		if err := enc.WriteObject(object); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := enc.WriteFooter(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func decode(data []byte) ([]*Object, error) {
+	var objects []*Object
+	err := NewDecoder(bytes.NewReader(data)).Parse(func(object *Object) bool {
+		objects = append(objects, object)
+		return true
+	})
+	return objects, err
+}
+
+func TestRoundTrip(t *testing.T) {
+	expiration := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	long := []byte(strings.Repeat("compressible ", 100))
+	tests := []struct {
+		name   string
+		object *Object
+	}{
+		{"string", &Object{Key: "k", Kind: StringKind, String: []byte("value")}},
+		{"empty string", &Object{Key: "k", Kind: StringKind, String: []byte{}}},
+		{"int8 string", &Object{Key: "k", Kind: StringKind, String: []byte("-12")}},
+		{"int16 string", &Object{Key: "k", Kind: StringKind, String: []byte("1000")}},
+		{"int32 string", &Object{Key: "k", Kind: StringKind, String: []byte("-2147483648")}},
+		{"not canonical int", &Object{Key: "k", Kind: StringKind, String: []byte("007")}},
+		{"lzf string", &Object{Key: "k", Kind: StringKind, String: long}},
+		{"binary string", &Object{Key: "k", Kind: StringKind, String: []byte{0, 0xFF, '\r', '\n'}}},
+		{"long key", &Object{Key: strings.Repeat("k", 20000), Kind: StringKind, String: []byte("v")}},
+		{"expiration", &Object{Key: "k", Kind: StringKind, String: []byte("v"), Expiration: &expiration}},
+		{"other db", &Object{DB: 3, Key: "k", Kind: StringKind, String: []byte("v")}},
+		{"list", &Object{Key: "l", Kind: ListKind, List: [][]byte{[]byte("a"), []byte("1"), long}}},
+		{"set", &Object{Key: "s", Kind: SetKind, Set: [][]byte{[]byte("a"), []byte("b")}}},
+		{"zset", &Object{Key: "z", Kind: ZSetKind, ZSet: []ZSetEntry{
+			{"a", 1.5}, {"b", -2}, {"c", math.Inf(1)}, {"d", math.Inf(-1)},
+		}}},
+		{"hash", &Object{Key: "h", Kind: HashKind, Hash: map[string][]byte{"f": []byte("v"), "n": []byte("1")}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := decode(encode(t, []*Object{tt.object}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) != 1 || !reflect.DeepEqual(objects[0], tt.object) {
+				t.Fatalf("decoded %+v, want %+v", objects, tt.object)
+			}
+		})
+	}
+}
+
+func TestRoundTripDatabases(t *testing.T) {
+	objects := []*Object{
+		{DB: 0, Key: "a", Kind: StringKind, String: []byte("1")},
+		{DB: 0, Key: "b", Kind: StringKind, String: []byte("2")},
+		{DB: 5, Key: "c", Kind: StringKind, String: []byte("3")},
+	}
+	decoded, err := decode(encode(t, objects))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, objects) {
+		t.Fatalf("decoded %+v, want %+v", decoded, objects)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	valid := encode(t, []*Object{{Key: "k", Kind: StringKind, String: []byte("value")}})
+	corrupt := func(modify func(data []byte) []byte) []byte {
+		return modify(append([]byte(nil), valid...))
+	}
+	// huge is a 64 bit length, object starts a file with the key k
+	huge := []byte{len64Bit, 0x7F, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+	object := func(valueType byte, value ...byte) []byte {
+		return append([]byte{'R', 'E', 'D', 'I', 'S', '0', '0', '1', '1', opSelectDB, 0, valueType, 1, 'k'}, value...)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"bad magic", corrupt(func(data []byte) []byte { return append([]byte("REDIX"), data[5:]...) }), ErrBadMagic},
+		{"version not a number", corrupt(func(data []byte) []byte { return append([]byte("REDIS00x9"), data[9:]...) }), ErrBadMagic},
+		{"future version", corrupt(func(data []byte) []byte { return append([]byte("REDIS0099"), data[9:]...) }), ErrBadVersion},
+		{"bad checksum", corrupt(func(data []byte) []byte { data[len(data)-1] ^= 1; return data }), ErrBadChecksum},
+		{"modified value", corrupt(func(data []byte) []byte { data[len(data)-10] ^= 1; return data }), ErrBadChecksum},
+		{"missing checksum", corrupt(func(data []byte) []byte { return data[:len(data)-8] }), io.ErrUnexpectedEOF},
+		{"empty", nil, io.ErrUnexpectedEOF},
+		{"huge string", object(typeString, huge...), io.ErrUnexpectedEOF},
+		{"huge list", object(typeList, huge...), io.ErrUnexpectedEOF},
+		{"huge zset", object(typeZset, huge...), io.ErrUnexpectedEOF},
+		{"huge hash", object(typeHash, huge...), io.ErrUnexpectedEOF},
+		{"huge compressed length", object(typeString, append([]byte{lenEnc<<6 | encLZF}, huge...)...), io.ErrUnexpectedEOF},
+		{"huge lzf length", object(typeString, append(append([]byte{lenEnc<<6 | encLZF, 1}, huge...), 0)...), errLZFCorrupted},
+		{"negative lzf length", object(typeString, lenEnc<<6|encLZF, 1, len64Bit, 0xFF, 0, 0, 0, 0, 0, 0, 0, 0), errLZFCorrupted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decode(tt.data)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+	// every truncation of a valid file is an error
+	for n := 0; n < len(valid); n++ {
+		if _, err := decode(valid[:n]); err == nil {
+			t.Fatalf("file truncated to %d bytes decoded", n)
+		}
+	}
+}
+
+// TestDecodePacked reads the compact encodings of redis 7, the files are
+// written without checksum
+func TestDecodePacked(t *testing.T) {
+	// a listpack of "f1", 7, -100, 1000
+	listpack := []byte{
+		0, 0, 0, 0, 4, 0,
+		0x82, 'f', '1', 3,
+		0x07, 1,
+		0xDF, 0x9C, 2,
+		0xF1, 0xE8, 0x03, 3,
+		0xFF,
+	}
+	// an intset of 16 bit integers 2 and -3
+	intset := []byte{2, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0xFD, 0xFF}
+	file := func(valueType byte, blob []byte) []byte {
+		data := []byte("REDIS0011")
+		data = append(data, opSelectDB, 0, valueType, 1, 'k', byte(len(blob)))
+		data = append(data, blob...)
+		data = append(data, opEOF, 0, 0, 0, 0, 0, 0, 0, 0)
+		return data
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want *Object
+	}{
+		{"listpack set", file(typeSetListpack, listpack), &Object{Key: "k", Kind: SetKind, Set: [][]byte{
+			[]byte("f1"), []byte("7"), []byte("-100"), []byte("1000"),
+		}}},
+		{"listpack hash", file(typeHashListpack, listpack), &Object{Key: "k", Kind: HashKind, Hash: map[string][]byte{
+			"f1": []byte("7"), "-100": []byte("1000"),
+		}}},
+		{"listpack zset", file(typeZsetListpack, listpack), &Object{Key: "k", Kind: ZSetKind, ZSet: []ZSetEntry{
+			{"f1", 7}, {"-100", 1000},
+		}}},
+		{"intset", file(typeSetIntset, intset), &Object{Key: "k", Kind: SetKind, Set: [][]byte{
+			[]byte("2"), []byte("-3"),
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, err := decode(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(objects) != 1 || !reflect.DeepEqual(objects[0], tt.want) {
+				t.Fatalf("decoded %+v, want %+v", objects, tt.want)
+			}
+		})
+	}
+	// every truncation of the blobs is an error
+	for n := 0; n < len(listpack)-1; n++ {
+		if items, err := parseListpack(listpack[:n]); err == nil {
+			t.Fatalf("listpack truncated to %d bytes parsed as %q", n, items)
+		}
+	}
+	for n := 0; n < len(intset); n++ {
+		if items, err := parseIntset(intset[:n]); err == nil {
+			t.Fatalf("intset truncated to %d bytes parsed as %q", n, items)
+		}
+	}
+}
+
+func TestLZF(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"repeated", strings.Repeat("a", 1000)},
+		{"words", strings.Repeat("hello world ", 50)},
+		{"long reference", strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := lzfCompress([]byte(tt.input))
+			if compressed == nil || len(compressed) >= len(tt.input) {
+				t.Fatalf("%d bytes compressed to %d", len(tt.input), len(compressed))
+			}
+			out, err := lzfDecompress(compressed, len(tt.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != tt.input {
+				t.Fatalf("decompressed %q", out)
+			}
+			if _, err := lzfDecompress(compressed, len(tt.input)-1); err == nil {
+				t.Fatal("decompressed to a shorter length")
+			}
+			if _, err := lzfDecompress(compressed[:len(compressed)-1], len(tt.input)); err == nil {
+				t.Fatal("decompressed truncated data")
+			}
+		})
+	}
+	if compressed := lzfCompress([]byte("abcdefghij")); compressed != nil {
+		t.Fatalf("incompressible input compressed to %q", compressed)
+	}
+}
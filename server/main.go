@@ -1,28 +1,28 @@
 package main
 
 import (
-	"fmt"
 	"kv_storage/config"
+	"kv_storage/logger"
 	"kv_storage/server/tcp"
 	"os"
 )
 
-var defaultProperties = &config.Config{
-	Bind:    "localhost",
-	Port:    8002,
-	AofFile: "../backups/cmdLog2.txt",
-}
+var defaultProperties = func() *config.Config {
+	properties := config.NewDefaultConfig()
+	properties.Bind = "localhost"
+	properties.Port = 8002
+	properties.AofFile = "../backups/cmdLog2.txt"
+	return properties
+}()
 
 func main() {
 	configFilename := os.Getenv("CONFIG")
-	fmt.Println(configFilename)
 	if configFilename == "" {
 		if fileExists("redis.conf") {
-			config.SetupConfig("redis.conf")
-			fmt.Println("redis.conf")
+			configFilename = "redis.conf"
+			config.SetupConfig(configFilename)
 		} else {
 			config.Properties = defaultProperties
-			fmt.Println("default.conf")
 		}
 	} else {
 		config.SetupConfig(configFilename)
@@ -30,11 +30,39 @@ func main() {
 	if len(config.Properties.Peers) > 1 {
 		config.Properties.IsCluster = true
 	}
-	fmt.Println(config.Properties)
-	server := tcp.NewBackend(config.Properties)
+	setupLogger(config.Properties)
+	if configFilename == "" {
+		logger.Info("no config file, using the default config")
+	} else {
+		logger.Info("config loaded", "file", configFilename)
+	}
+	server, err := tcp.NewBackend(config.Properties)
+	if err != nil {
+		logger.Error("aborting startup", "err", err)
+		os.Exit(1)
+	}
 	server.Start()
 }
 
+// setupLogger applies loglevel, log-format and logfile, an invalid option
+// is reported and its default kept
+func setupLogger(properties *config.Config) {
+	level, levelErr := logger.ParseLevel(properties.LogLevel)
+	format, formatErr := logger.ParseFormat(properties.LogFormat)
+	logFile := properties.LogFile
+	if logFile == `""` {
+		logFile = ""
+	}
+	if err := logger.Setup(level, format, logFile); err != nil {
+		logger.Error("open log file error", "file", logFile, "err", err)
+	}
+	for _, err := range []error{levelErr, formatErr} {
+		if err != nil {
+			logger.Error(err.Error())
+		}
+	}
+}
+
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	return err == nil && !info.IsDir()
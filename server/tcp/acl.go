@@ -0,0 +1,222 @@
+package tcp
+
+import (
+	"kv_storage/entity"
+	"kv_storage/executer"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+func init() {
+	executer.RegisterCommand("acl", -2, executer.FlagAdmin|executer.FlagNoSlowlog, 0, 0, 0, nil)
+}
+
+// checkPermissions replies a NOPERM error and logs the denial when the user
+// of c may not run cmd on its keys, nil when it may
+func (backend *Backend) checkPermissions(c *connection, cmd *executer.Command, args [][]byte) entity.Reply {
+	u := backend.acl.User(c.user)
+	if u == nil {
+		// the user was deleted, its clients are being disconnected
+		return entity.MakeErrReply("NOPERM User " + c.user + " has no permissions to run the '" + cmd.Name + "' command")
+	}
+	denial := u.CanRun(cmd, args)
+	if denial == nil {
+		return nil
+	}
+	backend.acl.AddLog(denial.Reason, denial.Object, u.Name, c.info())
+	if denial.Reason == "key" {
+		return entity.MakeErrReply("NOPERM No permissions to access a key")
+	}
+	return entity.MakeErrReply("NOPERM User " + u.Name + " has no permissions to run the '" + denial.Object + "' command")
+}
+
+// aclCommand implements the ACL subcommands
+func (backend *Backend) aclCommand(c *connection, args [][]byte) entity.Reply {
+	sub := strings.ToLower(string(args[1]))
+	switch sub {
+	case "setuser":
+		if len(args) < 3 {
+			return executer.MakeArityErr("acl|setuser")
+		}
+		rules := make([]string, len(args)-3)
+		for i, arg := range args[3:] {
+			rules[i] = string(arg)
+		}
+		if err := backend.acl.SetUser(string(args[2]), rules); err != nil {
+			return entity.MakeErrReply("ERR " + err.Error())
+		}
+		return entity.MakeOkReply()
+	case "getuser":
+		if len(args) != 3 {
+			return executer.MakeArityErr("acl|getuser")
+		}
+		return backend.aclGetUser(string(args[2]))
+	case "deluser":
+		if len(args) < 3 {
+			return executer.MakeArityErr("acl|deluser")
+		}
+		names := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			names[i] = string(arg)
+		}
+		deleted, err := backend.acl.DelUser(names)
+		if err != nil {
+			return entity.MakeErrReply("ERR " + err.Error())
+		}
+		backend.killRemovedUsers(c)
+		return entity.MakeIntReply(int64(deleted))
+	case "list":
+		if len(args) != 2 {
+			return executer.MakeArityErr("acl|list")
+		}
+		var lines [][]byte
+		for _, u := range backend.acl.Users() {
+			lines = append(lines, []byte(u.String()))
+		}
+		return entity.MakeMultiBulkReply(lines)
+	case "whoami":
+		if len(args) != 2 {
+			return executer.MakeArityErr("acl|whoami")
+		}
+		return entity.MakeBulkReply([]byte(c.user))
+	case "cat":
+		if len(args) > 3 {
+			return executer.MakeArityErr("acl|cat")
+		}
+		return aclCat(args[2:])
+	case "log":
+		if len(args) > 3 {
+			return executer.MakeArityErr("acl|log")
+		}
+		return backend.aclLog(args[2:])
+	case "save":
+		if len(args) != 2 {
+			return executer.MakeArityErr("acl|save")
+		}
+		if err := backend.acl.Save(); err != nil {
+			return entity.MakeErrReply("ERR There was an error trying to save the ACLs. Please check the server logs for more information")
+		}
+		return entity.MakeOkReply()
+	case "load":
+		if len(args) != 2 {
+			return executer.MakeArityErr("acl|load")
+		}
+		if err := backend.acl.Load(); err != nil {
+			return entity.MakeErrReply("ERR " + err.Error())
+		}
+		backend.killRemovedUsers(c)
+		return entity.MakeOkReply()
+	}
+	return entity.MakeErrReply("ERR unknown subcommand '" + executer.ErrorArg(args[1]) + "'. Try ACL HELP.")
+}
+
+// aclGetUser replies the flags, passwords and rules of a user, nil when it
+// doesn't exist
+func (backend *Backend) aclGetUser(name string) entity.Reply {
+	u := backend.acl.User(name)
+	if u == nil {
+		return entity.MakeNullBulkReply()
+	}
+	flags, passwords, commands, keys, channels := u.Info()
+	flagReplies := make([]redis.Reply, len(flags))
+	for i, flag := range flags {
+		flagReplies[i] = entity.MakeBulkReply([]byte(flag))
+	}
+	passwordReplies := make([][]byte, len(passwords))
+	for i, password := range passwords {
+		passwordReplies[i] = []byte(password)
+	}
+	return entity.MakeMapReply([]redis.Reply{
+		entity.MakeBulkReply([]byte("flags")), entity.MakeMultiRawReply(flagReplies),
+		entity.MakeBulkReply([]byte("passwords")), entity.MakeMultiBulkReply(passwordReplies),
+		entity.MakeBulkReply([]byte("commands")), entity.MakeBulkReply([]byte(commands)),
+		entity.MakeBulkReply([]byte("keys")), entity.MakeBulkReply([]byte(keys)),
+		entity.MakeBulkReply([]byte("channels")), entity.MakeBulkReply([]byte(channels)),
+		entity.MakeBulkReply([]byte("selectors")), entity.MakeEmptyMultiBulkReply(),
+	})
+}
+
+// aclCat replies the categories, or the commands of a category
+func aclCat(args [][]byte) entity.Reply {
+	if len(args) == 0 {
+		var names [][]byte
+		for _, name := range executer.CategoryNames() {
+			names = append(names, []byte(name))
+		}
+		return entity.MakeMultiBulkReply(names)
+	}
+	category := strings.ToLower(string(args[0]))
+	found := false
+	for _, name := range executer.CategoryNames() {
+		found = found || name == category
+	}
+	if !found {
+		return entity.MakeErrReply("ERR Unknown category '" + executer.ErrorArg(args[0]) + "'")
+	}
+	var names []string
+	for _, cmd := range executer.Commands() {
+		for _, c := range append([]*executer.Command{cmd}, cmd.Subcommands()...) {
+			for _, name := range c.Categories() {
+				if name == category {
+					names = append(names, c.Name)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	replies := make([][]byte, len(names))
+	for i, name := range names {
+		replies[i] = []byte(name)
+	}
+	return entity.MakeMultiBulkReply(replies)
+}
+
+// aclLog implements ACL LOG [count|RESET]
+func (backend *Backend) aclLog(args [][]byte) entity.Reply {
+	count := 10
+	if len(args) == 1 {
+		if strings.ToLower(string(args[0])) == "reset" {
+			backend.acl.ResetLog()
+			return entity.MakeOkReply()
+		}
+		n, err := strconv.Atoi(string(args[0]))
+		if err != nil || n < 0 {
+			return entity.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	now := time.Now()
+	var replies []redis.Reply
+	for _, entry := range backend.acl.Log(count) {
+		replies = append(replies, entity.MakeMapReply([]redis.Reply{
+			entity.MakeBulkReply([]byte("count")), entity.MakeIntReply(entry.Count),
+			entity.MakeBulkReply([]byte("reason")), entity.MakeBulkReply([]byte(entry.Reason)),
+			entity.MakeBulkReply([]byte("context")), entity.MakeBulkReply([]byte(entry.Context)),
+			entity.MakeBulkReply([]byte("object")), entity.MakeBulkReply([]byte(entry.Object)),
+			entity.MakeBulkReply([]byte("username")), entity.MakeBulkReply([]byte(entry.Username)),
+			entity.MakeBulkReply([]byte("age-seconds")), entity.MakeDoubleReply(now.Sub(entry.Created).Seconds()),
+			entity.MakeBulkReply([]byte("client-info")), entity.MakeBulkReply([]byte(entry.ClientInfo)),
+			entity.MakeBulkReply([]byte("entry-id")), entity.MakeIntReply(entry.EntryID),
+			entity.MakeBulkReply([]byte("timestamp-created")), entity.MakeIntReply(entry.Created.UnixMilli()),
+			entity.MakeBulkReply([]byte("timestamp-last-updated")), entity.MakeIntReply(entry.Updated.UnixMilli()),
+		}))
+	}
+	return entity.MakeMultiRawReply(replies)
+}
+
+// killRemovedUsers disconnects the clients whose user no longer exists
+func (backend *Backend) killRemovedUsers(self *connection) {
+	for _, c := range backend.sortedClients() {
+		c.mu.Lock()
+		user := c.user
+		c.mu.Unlock()
+		if backend.acl.User(user) == nil {
+			backend.kill(self, c)
+		}
+	}
+}
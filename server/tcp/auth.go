@@ -0,0 +1,57 @@
+package tcp
+
+import (
+	"kv_storage/acl"
+	"kv_storage/entity"
+	"kv_storage/executer"
+)
+
+func init() {
+	executer.RegisterCommand("auth", -2, executer.FlagNoSlowlog, 0, 0, 0, nil)
+	executer.RegisterCommand("quit", -1, 0, 0, 0, 0, nil)
+}
+
+var (
+	errNoAuth    = entity.MakeErrReply("NOAUTH Authentication required.")
+	errWrongPass = entity.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+)
+
+// allowedUnauthenticated reports whether cmd runs before the client
+// authenticates
+func allowedUnauthenticated(cmd *executer.Command) bool {
+	return cmd.Name == "auth" || cmd.Name == "hello" || cmd.Name == "quit"
+}
+
+// defaultAuthenticated reports whether new clients are authenticated as the
+// default user, which happens when it takes any password
+func (backend *Backend) defaultAuthenticated() bool {
+	u := backend.acl.User(acl.DefaultUserName)
+	return u != nil && u.Enabled() && u.NoPass()
+}
+
+// auth implements AUTH [username] password
+func (backend *Backend) auth(c *connection, args [][]byte) entity.Reply {
+	if len(args) > 3 {
+		return entity.MakeErrReply("ERR syntax error")
+	}
+	username, password := []byte(acl.DefaultUserName), args[1]
+	if len(args) == 3 {
+		username, password = args[1], args[2]
+	} else if u := backend.acl.User(acl.DefaultUserName); u != nil && u.NoPass() {
+		return entity.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	return backend.login(c, username, password)
+}
+
+// login authenticates c as username when password matches
+func (backend *Backend) login(c *connection, username, password []byte) entity.Reply {
+	if !backend.acl.Authenticate(username, password) {
+		backend.acl.AddLog("auth", "AUTH", string(username), c.info())
+		return errWrongPass
+	}
+	c.mu.Lock()
+	c.authenticated = true
+	c.user = string(username)
+	c.mu.Unlock()
+	return entity.MakeOkReply()
+}
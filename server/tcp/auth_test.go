@@ -0,0 +1,119 @@
+package tcp
+
+import (
+	"kv_storage/config"
+	"strings"
+	"testing"
+)
+
+// serveConfig serves a backend made from cfg on a loopback port and returns
+// it with its address
+func serveConfig(t *testing.T, cfg *config.Config) (*Backend, string) {
+	backend, err := NewBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.listen("127.0.0.1:0", nil); err != nil {
+		t.Fatal(err)
+	}
+	listener := backend.listeners[len(backend.listeners)-1]
+	t.Cleanup(func() { listener.Close() })
+	return backend, listener.Addr().String()
+}
+
+// passwordConfig returns a config without persistence whose default user
+// needs password, none when it is empty
+func passwordConfig(password string) *config.Config {
+	cfg := config.NewDefaultConfig()
+	cfg.DbFilename, cfg.Save = "", ""
+	cfg.RequirePass = password
+	return cfg
+}
+
+func TestAuth(t *testing.T) {
+	_, address := serveConfig(t, passwordConfig("secret"))
+	c := dial(t, address)
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"ping", "-NOAUTH Authentication required.\r\n"},
+		{"get k", "-NOAUTH Authentication required.\r\n"},
+		{"client id", "-NOAUTH Authentication required.\r\n"},
+		{"auth", "-ERR wrong number of arguments for 'auth' command\r\n"},
+		{"auth a b c", "-ERR syntax error\r\n"},
+		{"auth wrong", "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"auth default wrong", "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"auth nobody secret", "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"ping", "-NOAUTH Authentication required.\r\n"},
+		{"auth secret", "+OK\r\n"},
+		{"ping", "$4\r\npong\r\n"},
+		{"acl whoami", "$7\r\ndefault\r\n"},
+		// a failed AUTH keeps the client authenticated
+		{"auth wrong", "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		{"ping", "$4\r\npong\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(t, strings.Fields(tt.cmd)...); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	other := dial(t, address)
+	if got := other.do(t, "auth", "default", "secret"); got != "+OK\r\n" {
+		t.Errorf("auth default secret = %q, want +OK", got)
+	}
+	if got := other.do(t, "ping"); got != pong {
+		t.Errorf("ping after auth default secret = %q, want pong", got)
+	}
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	c := dial(t, serveTest(t))
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"ping", "$4\r\npong\r\n"},
+		{"auth secret", "-ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?\r\n"},
+		{"auth default anything", "+OK\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(t, strings.Fields(tt.cmd)...); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestMasterAuth(t *testing.T) {
+	_, address := serveConfig(t, passwordConfig("secret"))
+	tests := []struct {
+		masterAuth string
+		wantErr    bool
+		wantPing   string
+	}{
+		{"secret", false, pong},
+		{"wrong", true, ""},
+		// without masterauth the peer is reached but refuses the commands
+		{"", false, "-NOAUTH Authentication required.\r\n"},
+	}
+	for _, tt := range tests {
+		cfg := passwordConfig("")
+		cfg.MasterAuth = tt.masterAuth
+		node, err := NewBackend(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := node.dialPeer(address)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("masterauth %q: dial error %v, want an error %v", tt.masterAuth, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if reply, err := ping(conn); err != nil || reply != tt.wantPing {
+			t.Errorf("masterauth %q: ping = %q, %v, want %q", tt.masterAuth, reply, err, tt.wantPing)
+		}
+		conn.Close()
+	}
+}
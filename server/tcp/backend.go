@@ -1,236 +1,692 @@
 package tcp
 
 import (
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"io"
+	"kv_storage/acl"
 	"kv_storage/algorithm"
 	"kv_storage/aof"
 	"kv_storage/config"
 	"kv_storage/datastore"
 	"kv_storage/entity"
 	"kv_storage/executer"
+	"kv_storage/latency"
+	"kv_storage/logger"
 	"kv_storage/parser"
+	"kv_storage/snapshot"
 	"net"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 type Backend struct {
-	connectionNum uint16
-	ConnWg        *sync.WaitGroup
-	executer      *executer.Executer
-	aof           *aof.AofInstance
-	address       string
-	listener      net.Listener
+	ConnWg   *sync.WaitGroup
+	executer *executer.Executer
+	aof      *aof.AofInstance
+	saver    *snapshot.Saver
+	// address is the id of the node in the hash ring, the tls address
+	// with tls-cluster
+	address   string
+	listeners []net.Listener
+	// tcpAddress and tlsAddress are where the plaintext and the tls
+	// clients are accepted, empty when disabled
+	tcpAddress string
+	tlsAddress string
+	tlsConfig  *tls.Config
+	// unixSocket is the path of the unix socket listener, empty when
+	// disabled, and unixSocketPerm the permissions of its file
+	unixSocket     string
+	unixSocketPerm os.FileMode
+	// peerTLS dials the peers with mutual tls when tls-cluster is set
+	peerTLS *tls.Config
 
 	isCluster    bool
 	peers        []string
 	deadPeers    map[string]struct{}
 	innerConns   map[string]net.Conn
+	innerConns3  map[string]net.Conn
 	osSignalChan chan os.Signal
+	done         chan struct{}
+
+	// clients are the connected clients by id
+	clientsMu sync.Mutex
+	clients   map[int64]*connection
+	pause     clientPause
+
+	maxClients int
+	// idleTimeout disconnects the clients idle for longer, 0 never does
+	idleTimeout  time.Duration
+	tcpKeepalive time.Duration
+	tcpBacklog   int
+	// queryLimits bound the requests, outputLimits the pending output of
+	// the clients by class
+	queryLimits  parser.Limits
+	outputLimits map[string]outputBufferLimit
+
+	acl        *acl.ACL
+	masterAuth string
+
+	stats    serverStats
+	slowlog  *slowlog
+	monitors monitors
+	// infoPercentiles are the percentiles of the commands in INFO
+	// latencystats
+	infoPercentiles []float64
+
+	shutdownTimeout time.Duration
+	// execMu is read locked by the running commands, a shutdown locks it to
+	// wait for them and to hold back the new ones
+	execMu     sync.RWMutex
+	closing    int32
+	shutdownMu sync.Mutex
+	// stopped is closed once the server is shut down
+	stopped chan struct{}
 }
 
-func NewBackend(config *config.Config) *Backend {
+// NewBackend loads the dataset and returns a server ready to start. It fails
+// when the acl file or the tls config can not be loaded, rather than running
+// with the default user open to anyone or without tls, and when the aof can
+// not be loaded, rather than serving a partial dataset the next rewrite
+// would persist.
+func NewBackend(config *config.Config) (*Backend, error) {
+	users, err := acl.New(config.RequirePass, config.AclFile, config.AclLogMaxLen)
+	if err != nil {
+		return nil, fmt.Errorf("load acl file: %w", err)
+	}
+	var serverTLS, peerTLS *tls.Config
+	if config.TlsPort != 0 || config.TlsCluster {
+		if config.TlsCluster && config.TlsPort == 0 {
+			return nil, errors.New("tls-cluster requires tls-port")
+		}
+		if serverTLS, peerTLS, err = newTLSConfigs(config); err != nil {
+			return nil, fmt.Errorf("tls config: %w", err)
+		}
+		if !config.TlsCluster {
+			peerTLS = nil
+		}
+	}
 	db := datastore.NewMap()
-	aofInstance := aof.NewAofInstance(config.AofFile)
+	aofInstance := aof.NewAofInstance(config)
 	execInstance := executer.NewExecuter(db)
-	aofInstance.Init(db, execInstance)
+	if err := aofInstance.Init(db, execInstance); err != nil {
+		aofInstance.Close()
+		return nil, fmt.Errorf("load aof: %w", err)
+	}
+	if !aofInstance.Enabled() {
+		// the aof is always more complete than the snapshot, so the
+		// snapshot is only loaded when the aof is disabled
+		loadSnapshot(config.DbFilename, db)
+	}
+	saveRules, err := snapshot.ParseSaveRules(config.Save)
+	if err != nil {
+		logger.Error("invalid save rules", "err", err)
+	}
+	outputLimits, err := parseOutputBufferLimits(config.ClientOutputBufferLimit)
+	if err != nil {
+		logger.Error("invalid client-output-buffer-limit", "err", err)
+	}
+	var tcpAddress, tlsAddress string
+	if config.Port != 0 {
+		tcpAddress = fmt.Sprint(config.Bind, ":", config.Port)
+	}
+	if config.TlsPort != 0 {
+		tlsAddress = fmt.Sprint(config.Bind, ":", config.TlsPort)
+	}
+	address := tcpAddress
+	if config.TlsCluster {
+		address = tlsAddress
+	}
+	unixSocketPerm, err := parseUnixSocketPerm(config.UnixSocketPerm)
+	if err != nil {
+		logger.Error("invalid unixsocketperm", "err", err)
+	}
+	latency.Setup(time.Duration(config.LatencyMonitorThreshold)*time.Millisecond, config.LatencyTracking)
+	infoPercentiles, err := parseInfoPercentiles(config.LatencyTrackingInfoPercentiles)
+	if err != nil {
+		logger.Error("invalid latency-tracking-info-percentiles", "err", err)
+		infoPercentiles = defaultInfoPercentiles
+	}
 	backend := &Backend{
-		ConnWg:       &sync.WaitGroup{},
-		executer:     execInstance,
-		aof:          aofInstance,
-		address:      fmt.Sprint(config.Bind, ":", config.Port),
+		ConnWg:     &sync.WaitGroup{},
+		executer:   execInstance,
+		aof:        aofInstance,
+		saver:      snapshot.NewSaver(config.DbFilename, saveRules, execInstance),
+		address:    address,
+		tcpAddress: tcpAddress,
+		tlsAddress: tlsAddress,
+		tlsConfig:  serverTLS,
+		peerTLS:    peerTLS,
+
+		unixSocket:     config.UnixSocket,
+		unixSocketPerm: unixSocketPerm,
+
 		isCluster:    config.IsCluster,
 		deadPeers:    make(map[string]struct{}),
 		innerConns:   make(map[string]net.Conn),
+		innerConns3:  make(map[string]net.Conn),
 		osSignalChan: make(chan os.Signal, 1),
+		done:         make(chan struct{}),
+
+		clients:      make(map[int64]*connection),
+		maxClients:   config.MaxClients,
+		idleTimeout:  time.Duration(config.Timeout) * time.Second,
+		tcpKeepalive: time.Duration(config.TcpKeepalive) * time.Second,
+		tcpBacklog:   config.TcpBacklog,
+		queryLimits: parser.Limits{
+			MaxMultiBulkLen: int64(config.ProtoMaxMultiBulkLen),
+			MaxBulkLen:      int64(config.ProtoMaxBulkLen),
+			MaxQueryBuffer:  int64(config.ClientQueryBufferLimit),
+			MaxDepth:        parser.DefaultLimits.MaxDepth,
+			MaxElements:     int64(config.ProtoMaxMultiBulkLen),
+		},
+		outputLimits:    outputLimits,
+		acl:             users,
+		masterAuth:      config.MasterAuth,
+		stats:           newServerStats(),
+		slowlog:         newSlowlog(config.SlowlogLogSlowerThan, config.SlowlogMaxLen),
+		infoPercentiles: infoPercentiles,
+		shutdownTimeout: time.Duration(config.ShutdownTimeout) * time.Second,
+		stopped:         make(chan struct{}),
 	}
 	if config.IsCluster {
 		backend.peers = config.Peers
 		algorithm.Consistenthash.AddNode(backend.address)
-		fmt.Printf("%v添加%v到哈希环上\n", backend.address, backend.address)
 		for _, address := range backend.peers {
 			algorithm.Consistenthash.AddNode(address)
-			fmt.Printf("%v添加%v到哈希环上\n", backend.address, address)
 		}
-		fmt.Printf("%v节点的哈希环%v\n", backend.address, algorithm.Consistenthash.GetKeys())
+		logger.Info("cluster nodes added to the hash ring", "node", backend.address, "peers", strings.Join(backend.peers, ","))
+		logger.Debug("hash ring", "node", backend.address, "keys", fmt.Sprint(algorithm.Consistenthash.GetKeys()))
 	}
-	return backend
+	return backend, nil
 }
 
+func loadSnapshot(fileName string, db *datastore.Map) {
+	if _, err := os.Stat(fileName); err != nil {
+		return
+	}
+	logger.Info("loading snapshot", "file", fileName)
+	if err := snapshot.ReadFile(fileName, db); err != nil {
+		logger.Error("load snapshot error", "file", fileName, "err", err)
+		return
+	}
+	logger.Info("snapshot loaded", "file", fileName)
+}
+
+// Handle serves a plaintext client
 func (backend *Backend) Handle(conn net.Conn) {
+	backend.handle(conn, nil)
+}
+
+// handle serves a client, with tls when tlsConfig is not nil
+func (backend *Backend) handle(conn net.Conn, tlsConfig *tls.Config) {
+	c := newConnection(meteredConn{Conn: conn, stats: &backend.stats}, tlsConfig)
 	defer func() {
-		conn.Close()
-		fmt.Printf("server instance %v connection is completed and closed!\n", backend.address)
+		c.conn.Close()
+		logger.Debug("connection closed", "id", c.id)
 	}()
-	backend.connectionNum++
-	fmt.Println("handing connection ..., connection number:", backend.connectionNum)
-
-	ch := parser.ParseStream(conn)
-	for payload := range ch {
-		// fmt.Println(payload.Data.ToBytes(), "\r\n" + string(payload.Data.ToBytes()))
-		if payload.Err != nil {
-			if payload.Err == io.EOF ||
-				payload.Err == io.ErrUnexpectedEOF ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
+	if err := handshake(c); err != nil {
+		logger.Warning("tls handshake error", "addr", conn.RemoteAddr(), "err", err)
+		return
+	}
+	// every client is a normal one, the replica and pubsub limits of the
+	// config never apply
+	c.out.limit = backend.outputLimits["normal"]
+	c.authenticated = backend.defaultAuthenticated()
+	n, ok := backend.addClient(c)
+	if !ok {
+		atomic.AddInt64(&backend.stats.rejected, 1)
+		logger.Warning("max number of clients reached, connection refused", "addr", conn.RemoteAddr())
+		c.write(entity.MakeErrReply("ERR max number of clients reached"))
+		c.flush()
+		return
+	}
+	atomic.AddInt64(&backend.stats.connections, 1)
+	logger.Debug("accepted client", "id", c.id, "addr", c.addr(), "clients", n)
+	defer backend.removeClient(c)
+
+	for {
+		if !c.pipelined() {
+			if err := c.flush(); err != nil {
+				if errors.Is(err, errOutputBufferLimit) {
+					logger.Warning("closing client for overcoming of output buffer limits", "err", err, "client", c.info())
+				}
+				return
+			}
+		}
+		c.trackBuffers()
+		if !backend.setIdleDeadline(c) {
+			return
+		}
+		args, err := parser.ReadCommand(c.reader, backend.queryLimits)
+		if err != nil {
+			ok, fatal := parser.IsProtocolError(err)
+			if !ok {
+				if errors.Is(err, parser.ErrQueryBufferLimit) {
+					logger.Warning("closing client that reached max query buffer length", "client", c.info())
+				} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() && atomic.LoadInt32(&backend.closing) == 0 {
+					logger.Info("closing idle client", "id", c.id, "timeout", backend.idleTimeout)
+				}
 				// connection closed
-				// h.closeClient(client)
-				// logger.Info("connection closed: " + client.RemoteAddr().String())
 				return
 			}
-			// protocol err
-			errReply := entity.MakeErrReply(payload.Err.Error())
-			_, err := conn.Write(errReply.ToBytes())
-			if err != nil {
-				// h.closeClient(client)
-				// logger.Info("connection closed: " + client.RemoteAddr().String())
-				fmt.Println("connection closed")
+			c.write(entity.MakeErrReply("ERR " + err.Error()))
+			if fatal {
+				logger.Warning("protocol error from client", "err", err, "client", c.info())
+				c.flush()
 				return
 			}
 			continue
 		}
-		if payload.Data == nil {
-			fmt.Println("empty payload")
-			continue
+		c.beginCommand(args)
+		if err := c.write(backend.exec(c, args)); err != nil {
+			if errors.Is(err, errOutputBufferLimit) {
+				logger.Warning("closing client for overcoming of output buffer limits", "err", err, "client", c.info())
+			}
+			return
 		}
-		r, ok := payload.Data.(*entity.MultiBulkReply)
-		if !ok {
-			fmt.Println("require multi bulk protocol")
-			continue
+		if c.closeAfterReply {
+			c.flush()
+			return
 		}
-		if len(r.Args) > 1 && string(r.Args[0]) == "expire" {
-			r.Args = aof.ExpireToExpireAt(r.Args)
+		if c.monitor != nil {
+			if c.flush() == nil {
+				backend.serveMonitor(c)
+			}
+			return
 		}
-		if ok, reply := backend.resend(r.Args); ok {
-			conn.Write(reply.ToBytes())
-			continue
+	}
+}
+
+// setIdleDeadline makes the next read fail once the client has been idle
+// for the idle timeout, it returns false when the server is shutting down
+func (backend *Backend) setIdleDeadline(c *connection) bool {
+	if backend.idleTimeout <= 0 {
+		return true
+	}
+	c.conn.SetReadDeadline(time.Now().Add(backend.idleTimeout))
+	// a shutdown closes done before it wakes up the clients with a past
+	// deadline, which must not be pushed back
+	select {
+	case <-backend.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// exec runs a request of a client, the reply is written by the caller so
+// that the replies of a pipeline are sent together
+func (backend *Backend) exec(c *connection, args [][]byte) entity.Reply {
+	cmd, ok := executer.LookupCommand(args[0])
+	if !ok {
+		return executer.MakeUnknownCommandErr(args[0])
+	}
+	if !cmd.CheckArity(args) {
+		return executer.MakeArityErr(cmd.Name)
+	}
+	if !allowedUnauthenticated(cmd) {
+		if !c.authenticated {
+			return errNoAuth
+		}
+		if reply := backend.checkPermissions(c, cmd, args); reply != nil {
+			return reply
 		}
-		reply := backend.executer.Execute(r.Args)
-		go backend.aof.ToCmdCh(payload)
-		conn.Write(reply.ToBytes())
 	}
+	atomic.AddInt64(&backend.stats.commands, 1)
+	start := time.Now()
+	if reply, ok := backend.connectionCommand(c, cmd, args); ok {
+		backend.commandDone(c, cmd, args, start)
+		return reply
+	}
+	// the connection commands above are never paused so that CLIENT
+	// UNPAUSE gets through
+	backend.waitUnpaused(cmd)
+	if cmd.Name == "shutdown" {
+		// the shutdown waits for the other running commands
+		return backend.shutdownCommand(c, args)
+	}
+	backend.execMu.RLock()
+	defer backend.execMu.RUnlock()
+	if atomic.LoadInt32(&backend.closing) == 1 {
+		return entity.MakeErrReply("ERR Server is shutting down")
+	}
+	// the time waiting for the pause and the lock is not the command's
+	start = time.Now()
+	reply := backend.call(c, cmd, args)
+	backend.commandDone(c, cmd, args, start)
+	return reply
+}
+
+// call runs a command on the server, on the dataset or on the peer owning
+// its key
+func (backend *Backend) call(c *connection, cmd *executer.Command, args [][]byte) entity.Reply {
+	if cmd.HasFlag(executer.FlagAdmin) {
+		return backend.serverCommand(cmd, args)
+	}
+	if ok, reply := backend.resend(cmd, args, c.protocol); ok {
+		return reply
+	}
+	return backend.aof.Execute(args)
+}
+
+// commandDone records a command of c that started at start, the commands
+// holding passwords are neither logged nor shown to the monitors and the
+// admin ones are not shown either
+func (backend *Backend) commandDone(c *connection, cmd *executer.Command, args [][]byte, start time.Time) {
+	duration := time.Since(start)
+	if !cmd.HasFlag(executer.FlagNoSlowlog) {
+		backend.slowlog.add(c, args, duration)
+		if !cmd.HasFlag(executer.FlagAdmin) {
+			backend.monitors.feed(c, args, start)
+		}
+	}
+	latency.RecordCommand(cmd.Name, duration)
+	latency.AddSample(latency.EventCommand, duration)
 }
 
 func (backend *Backend) Start() {
 	if backend.isCluster {
 		go backend.Heartbeat()
 	}
-	backend.listener, _ = net.Listen("tcp", backend.address)
-	fmt.Printf("server is listening in %v!\n", backend.address)
+	if backend.tcpAddress != "" {
+		if err := backend.listen(backend.tcpAddress, nil); err != nil {
+			logger.Error("listen error", "err", err)
+			return
+		}
+	}
+	if backend.tlsAddress != "" {
+		if err := backend.listen(backend.tlsAddress, backend.tlsConfig); err != nil {
+			logger.Error("listen error", "err", err)
+			return
+		}
+	}
+	if backend.unixSocket != "" {
+		if err := backend.listenUnix(backend.unixSocket, backend.unixSocketPerm); err != nil {
+			logger.Error("listen error", "err", err)
+			return
+		}
+	}
+	if len(backend.listeners) == 0 {
+		logger.Error("none of port, tls-port and unixsocket is set, nothing to listen on")
+		return
+	}
 	signal.Notify(backend.osSignalChan, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
-		<-backend.osSignalChan
-		close(backend.osSignalChan)
-		backend.Stop()
+		for sig := range backend.osSignalChan {
+			if sig == syscall.SIGHUP {
+				// logrotate moved the log file away
+				if err := logger.Reopen(); err != nil {
+					logger.Error("reopen log file error", "err", err)
+				}
+				continue
+			}
+			// like redis the server keeps running when the snapshot fails
+			if err := backend.Shutdown(nil, shutdownDefault); err == nil {
+				return
+			}
+		}
 	}()
 
 	go backend.aof.Persist()
+	go backend.saver.Cron(backend.done)
+	go backend.sampleStats()
+
+	<-backend.stopped
+	logger.Info("server stopped", "address", backend.address)
+}
+
+// listen accepts the clients on address, with tls when tlsConfig is not
+// nil, the plaintext and the tls listeners run side by side
+func (backend *Backend) listen(address string, tlsConfig *tls.Config) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	if err := setBacklog(listener, backend.tcpBacklog); err != nil {
+		logger.Warning("set tcp backlog error", "err", err)
+	}
+	backend.listeners = append(backend.listeners, listener)
+	logger.Info("server is listening", "address", address, "tls", tlsConfig != nil)
+	go backend.accept(listener, tlsConfig)
+	return nil
+}
 
+func (backend *Backend) accept(listener net.Listener, tlsConfig *tls.Config) {
 	for {
-		fmt.Printf("%v waiting for connection\n", backend.address)
-		conn, err := backend.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
-			fmt.Println("listener accept error:", err.Error())
-			break
+			if atomic.LoadInt32(&backend.closing) == 0 {
+				logger.Error("listener accept error", "address", listener.Addr(), "err", err)
+			}
+			return
+		}
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			// go enables a 15s keepalive on accepted connections, tcp-keepalive 0
+			// disables it like in redis
+			if backend.tcpKeepalive > 0 {
+				tcpConn.SetKeepAlive(true)
+				tcpConn.SetKeepAlivePeriod(backend.tcpKeepalive)
+			} else {
+				tcpConn.SetKeepAlive(false)
+			}
 		}
 		backend.ConnWg.Add(1)
 		go func() {
 			defer backend.ConnWg.Done()
-			backend.Handle(conn)
+			backend.handle(conn, tlsConfig)
 		}()
 	}
-	backend.ConnWg.Wait()
-	fmt.Println(backend.address, "hand end")
 }
 
-func (backend *Backend) Stop() {
-	fmt.Printf("server %v is shutting down!\n", backend.address)
-	backend.listener.Close()
-	fmt.Printf("server %v listener is closed!\n", backend.address)
-	if backend.isCluster {
-		for _, peerConn := range backend.innerConns {
-			peerConn.Close()
+func init() {
+	executer.RegisterCommand("bgrewriteaof", 1, executer.FlagAdmin, 0, 0, 0, nil)
+	executer.RegisterCommand("save", 1, executer.FlagAdmin, 0, 0, 0, nil)
+	executer.RegisterCommand("bgsave", 1, executer.FlagAdmin, 0, 0, 0, nil)
+	executer.RegisterCommand("lastsave", 1, executer.FlagAdmin, 0, 0, 0, nil)
+}
+
+// serverCommand handles the admin commands, they act on the server itself
+// rather than on the dataset and are never forwarded to other nodes
+func (backend *Backend) serverCommand(cmd *executer.Command, args [][]byte) entity.Reply {
+	switch cmd.Name {
+	case "bgrewriteaof":
+		if err := backend.aof.BackgroundRewrite(); err != nil {
+			return entity.MakeErrReply("ERR " + err.Error())
+		}
+		return entity.MakeStatusReply("Background append only file rewriting started")
+	case "save":
+		if err := backend.saver.Save(); err != nil {
+			return entity.MakeErrReply("ERR " + err.Error())
+		}
+		return entity.MakeOkReply()
+	case "bgsave":
+		if err := backend.saver.BackgroundSave(); err != nil {
+			return entity.MakeErrReply("ERR " + err.Error())
 		}
+		return entity.MakeStatusReply("Background saving started")
+	case "lastsave":
+		return entity.MakeIntReply(backend.saver.LastSave().Unix())
+	case "info":
+		return backend.info(args)
+	case "slowlog":
+		return backend.slowlog.command(args)
+	case "latency":
+		return backend.latencyCommand(args)
 	}
+	return executer.MakeUnknownCommandErr(args[0])
 }
 
-func (backend *Backend) resend(args [][]byte) (bool, entity.Reply) {
+// resend forwards a command to the node owning its first key, commands
+// without keys such as the heartbeat ping are executed locally. The peer
+// replies in the protocol version of the client so the reply keeps its shape.
+func (backend *Backend) resend(cmd *executer.Command, args [][]byte, protocol int) (bool, entity.Reply) {
 	if !backend.isCluster {
 		return false, nil
 	}
-	key, isHeartbeat := executer.GetKey(args)
-	if isHeartbeat || key == "" {
+	keys := cmd.Keys(args)
+	if len(keys) == 0 {
 		return false, nil
 	}
-	fmt.Println("key:", key)
+	key := string(keys[0])
 	nodeId := algorithm.Consistenthash.PickNode(key)
-	fmt.Println("need node id:", nodeId, "current node id:", backend.address)
 	if backend.address == nodeId {
-		fmt.Println("dont't resend")
 		return false, nil
 	}
 	// 转发
+	if logger.Enabled(logger.LevelDebug) {
+		logger.Debug("forwarding command", "command", cmd.Name, "key", key, "node", nodeId)
+	}
 	re := entity.MakeMultiBulkReply(args)
-	cmd := re.ToBytes()
-	fmt.Println(string(cmd))
-	peerConn, ok := backend.innerConns[nodeId]
-	var err error
-	if !ok {
-		peerConn, err = net.Dial("tcp", nodeId)
-		if err != nil {
-			panic(err)
-		}
-		backend.innerConns[nodeId] = peerConn
+	data := re.ToBytes()
+	peerConn, err := backend.peerConn(nodeId, protocol)
+	if err != nil {
+		logger.Warning("forward error", "node", backend.address, "peer", nodeId, "err", err)
+		return true, entity.MakeErrReply("ERR forwarding to " + nodeId + " failed: " + err.Error())
 	}
-	n, err := peerConn.Write(cmd)
+	start := time.Now()
+	_, err = peerConn.Write(data)
 	if err != nil {
-		panic(err)
+		backend.dropPeerConn(nodeId, protocol)
+		logger.Warning("forward error", "node", backend.address, "peer", nodeId, "err", err)
+		return true, entity.MakeErrReply("ERR forwarding to " + nodeId + " failed: " + err.Error())
 	}
-	fmt.Println("resend", n, "bytes")
 	reply, timeout := parser.WaitReplyWithTime(peerConn)
+	latency.AddSample(latency.EventClusterForward, time.Since(start))
 	if timeout {
+		// a late reply would be taken for the one of the next command
+		backend.dropPeerConn(nodeId, protocol)
 		return true, entity.MakeBulkReply([]byte("timeout"))
 	}
-	fmt.Println("resend reply:", string(reply.ToBytes()))
+	if reply == nil {
+		backend.dropPeerConn(nodeId, protocol)
+		return true, entity.MakeErrReply("ERR forwarding to " + nodeId + " failed: connection lost")
+	}
 	return true, reply
 }
 
+// dropPeerConn closes the connection to a peer speaking the given protocol
+// version so that the next command dials it again
+func (backend *Backend) dropPeerConn(nodeId string, protocol int) {
+	conns := backend.innerConns
+	if protocol == entity.RESP3 {
+		conns = backend.innerConns3
+	}
+	if peerConn, ok := conns[nodeId]; ok {
+		peerConn.Close()
+		delete(conns, nodeId)
+	}
+}
+
+// peerConn returns the connection to a peer speaking the given protocol
+// version, RESP3 connections say HELLO 3 once they are dialed
+func (backend *Backend) peerConn(nodeId string, protocol int) (net.Conn, error) {
+	conns := backend.innerConns
+	if protocol == entity.RESP3 {
+		conns = backend.innerConns3
+	}
+	if peerConn, ok := conns[nodeId]; ok {
+		return peerConn, nil
+	}
+	peerConn, err := backend.dialPeer(nodeId)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == entity.RESP3 {
+		hello := entity.MakeMultiBulkReply([][]byte{[]byte("hello"), []byte("3")})
+		if _, err = peerConn.Write(hello.ToBytes()); err != nil {
+			peerConn.Close()
+			return nil, err
+		}
+		if reply, timeout := parser.WaitReplyWithTime(peerConn); timeout || entity.IsErrorReply(reply) {
+			peerConn.Close()
+			return nil, errors.New("peer " + nodeId + " does not speak RESP3")
+		}
+	}
+	conns[nodeId] = peerConn
+	return peerConn, nil
+}
+
+// dialPeer connects to a peer, over mutual tls with tls-cluster, and
+// authenticates with masterauth
+func (backend *Backend) dialPeer(nodeId string) (net.Conn, error) {
+	var peerConn net.Conn
+	var err error
+	if backend.peerTLS != nil {
+		peerConn, err = tls.Dial("tcp", nodeId, backend.peerTLS)
+	} else {
+		peerConn, err = net.Dial("tcp", nodeId)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if backend.masterAuth == "" {
+		return peerConn, nil
+	}
+	auth := entity.MakeMultiBulkReply([][]byte{[]byte("auth"), []byte(backend.masterAuth)})
+	if _, err = peerConn.Write(auth.ToBytes()); err != nil {
+		peerConn.Close()
+		return nil, err
+	}
+	reply, timeout := parser.WaitReplyWithTime(peerConn)
+	if timeout {
+		peerConn.Close()
+		return nil, errors.New("peer " + nodeId + " auth timeout")
+	}
+	if entity.IsErrorReply(reply) {
+		peerConn.Close()
+		// the heartbeat retries quietly, so the misconfiguration is logged here
+		logger.Error("masterauth refused", "node", backend.address, "peer", nodeId, "reply", strings.TrimSpace(string(reply.ToBytes())))
+		return nil, errors.New("peer " + nodeId + " refused masterauth")
+	}
+	return peerConn, nil
+}
+
 func (backend *Backend) doHeartbeat() {
 	for _, id := range backend.peers {
 		peerConn, ok := backend.innerConns[id]
 		var err error
 		if !ok {
-			peerConn, err = net.Dial("tcp", id)
+			peerConn, err = backend.dialPeer(id)
 			if err == nil {
 				delete(backend.deadPeers, id)
 				backend.innerConns[id] = peerConn
-				fmt.Printf("%v reactive node %v\n", backend.address, id)
+				logger.Info("peer connected", "node", backend.address, "peer", id)
 			} else {
 				continue
 			}
 		}
 		_, err = peerConn.Write(entity.MakeMultiBulkReply([][]byte{[]byte("ping")}).ToBytes())
+		if err == nil {
+			_, timeout := parser.WaitReplyWithTime(peerConn)
+			if timeout {
+				err = errors.New("timeout")
+			}
+		}
 		if err != nil {
 			backend.deadPeers[id] = struct{}{}
 			delete(backend.innerConns, id)
-			fmt.Printf("%v dead node %v\n", backend.address, id)
-		} else if _, timeout := parser.WaitReplyWithTime(peerConn); timeout {
-			backend.deadPeers[id] = struct{}{}
-			delete(backend.innerConns, id)
-			fmt.Printf("%v dead node %v\n", backend.address, id)
+			if peerConn3, ok := backend.innerConns3[id]; ok {
+				peerConn3.Close()
+				delete(backend.innerConns3, id)
+			}
+			logger.Warning("peer is down", "node", backend.address, "peer", id, "err", err)
 		}
 	}
-	fmt.Printf("%v's peers: ", backend.address)
-	for _, id := range backend.peers {
-		if _, ok := backend.innerConns[id]; ok {
-			fmt.Printf("%v ", id)
+	if logger.Enabled(logger.LevelDebug) {
+		var alive []string
+		for _, id := range backend.peers {
+			if _, ok := backend.innerConns[id]; ok {
+				alive = append(alive, id)
+			}
 		}
+		logger.Debug("heartbeat", "node", backend.address, "alive", strings.Join(alive, ","))
 	}
-	fmt.Println()
 }
 
 func (backend *Backend) Heartbeat() {
@@ -241,8 +697,8 @@ func (backend *Backend) Heartbeat() {
 		case <-timer.C:
 			backend.doHeartbeat()
 			timer.Reset(life)
-		case <-backend.osSignalChan:
-			fmt.Printf("%v stop heartbeat!", backend.address)
+		case <-backend.done:
+			logger.Debug("heartbeat stopped", "node", backend.address)
 			return
 		}
 	}
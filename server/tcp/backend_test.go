@@ -0,0 +1,169 @@
+package tcp
+
+import (
+	"kv_storage/algorithm"
+	"kv_storage/config"
+	"kv_storage/entity"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/lib/utils"
+)
+
+// writeHybridAof leaves a hybrid aof in dir made of a base snapshot and an
+// incr part and returns the config loading it
+func writeHybridAof(t *testing.T, dir string) *config.Config {
+	cfg := config.NewDefaultConfig()
+	cfg.DbFilename, cfg.Save = "", ""
+	cfg.AofFile = filepath.Join(dir, "cmdLog.txt")
+	cfg.AofUseSnapshotPreamble = true
+	cfg.AutoAofRewritePercentage = 0
+	backend, err := NewBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := backend.aof
+	go a.Persist()
+	a.Execute(utils.ToCmdLine("set", "a", "1"))
+	if err := a.BackgroundRewrite(); err != nil {
+		t.Fatal(err)
+	}
+	for a.Status().Rewriting {
+		time.Sleep(time.Millisecond)
+	}
+	if err := a.Status().LastRewriteErr; err != nil {
+		t.Fatal(err)
+	}
+	a.Execute(utils.ToCmdLine("set", "b", "2"))
+	if err := a.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+	return cfg
+}
+
+// part returns the only file of dir matching pattern
+func part(t *testing.T, dir, pattern string) string {
+	names, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("parts %v matching %s: %v", names, pattern, err)
+	}
+	return names[0]
+}
+
+func TestNewBackendAofErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, dir string)
+		wantErr bool
+	}{
+		{"intact", func(t *testing.T, dir string) {}, false},
+		{"bad base checksum", func(t *testing.T, dir string) {
+			name := part(t, dir, "*.base.snap")
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data[len(data)-1] ^= 1
+			if err := os.WriteFile(name, data, 0666); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"corrupt incr part", func(t *testing.T, dir string) {
+			name := part(t, dir, "*.incr.aof")
+			if err := os.WriteFile(name, []byte("*2\r\n$3\r\nget\r\n$x\r\n"), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"missing base", func(t *testing.T, dir string) {
+			if err := os.Remove(part(t, dir, "*.base.snap")); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := writeHybridAof(t, dir)
+			tt.corrupt(t, dir)
+			backend, err := NewBackend(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewBackend error %v, want an error %v", err, tt.wantErr)
+			}
+			if err == nil {
+				backend.aof.Close()
+			}
+		})
+	}
+}
+
+// keyOn returns a key of the hash ring owned by node
+func keyOn(t *testing.T, node string) string {
+	for i := 0; i < 10000; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if algorithm.Consistenthash.PickNode(key) == node {
+			return key
+		}
+	}
+	t.Fatalf("no key on %s", node)
+	return ""
+}
+
+func TestResendErrors(t *testing.T) {
+	_, peer := serveConfig(t, passwordConfig("secret"))
+	_, refusing := serveConfig(t, passwordConfig("other"))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	down := listener.Addr().String()
+	listener.Close()
+
+	cfg := passwordConfig("")
+	cfg.IsCluster = true
+	cfg.Peers = []string{peer, refusing, down}
+	cfg.MasterAuth = "secret"
+	_, address := serveConfig(t, cfg)
+	peerKey, refusingKey, downKey := keyOn(t, peer), keyOn(t, refusing), keyOn(t, down)
+	c := dial(t, address)
+	admin := dial(t, peer)
+	admin.do(t, "auth", "secret")
+
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"set " + peerKey + " v", "+"},
+		{"get " + peerKey, "$1\r\nv\r\n"},
+		{"get " + refusingKey, "-ERR forwarding to " + refusing + " failed: peer " + refusing + " refused masterauth\r\n"},
+		{"get " + downKey, "-ERR forwarding to " + down + " failed: "},
+		// the node is still serving after the failures
+		{"get " + peerKey, "$1\r\nv\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(t, strings.Fields(tt.cmd)...); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+
+	// a connection to a peer that went away fails once then is dialed again
+	for _, protocol := range []int{entity.RESP2, entity.RESP3} {
+		c.do(t, "hello", strconv.Itoa(protocol))
+		c.protocol = protocol
+		if got := c.do(t, "get", peerKey); got != "$1\r\nv\r\n" {
+			t.Fatalf("RESP%d get = %q, want v", protocol, got)
+		}
+		admin.do(t, "client", "kill", "type", "normal", "skipme", "yes")
+		if got := c.do(t, "get", peerKey); !strings.HasPrefix(got, "-ERR forwarding to "+peer+" failed: ") {
+			t.Errorf("RESP%d get from a lost peer = %q, want a forwarding error", protocol, got)
+		}
+		if got := c.do(t, "get", peerKey); got != "$1\r\nv\r\n" {
+			t.Errorf("RESP%d get after a lost peer = %q, want v", protocol, got)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package tcp
+
+import (
+	"kv_storage/entity"
+	"kv_storage/executer"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	client := executer.RegisterCommand("client", -2, 0, 0, 0, 0, nil)
+	// like redis the subcommands acting on other clients are admin ones
+	client.RegisterSubcommand("kill", -3, executer.FlagAdmin)
+	client.RegisterSubcommand("list", -2, executer.FlagAdmin)
+	client.RegisterSubcommand("pause", -3, executer.FlagAdmin)
+	client.RegisterSubcommand("unpause", 2, executer.FlagAdmin)
+	client.RegisterSubcommand("no-evict", 3, executer.FlagAdmin)
+}
+
+// addClient registers a client and returns the number of clients, it is
+// refused when maxclients clients are already connected
+func (backend *Backend) addClient(c *connection) (int, bool) {
+	backend.clientsMu.Lock()
+	defer backend.clientsMu.Unlock()
+	if len(backend.clients) >= backend.maxClients {
+		return len(backend.clients), false
+	}
+	backend.clients[c.id] = c
+	return len(backend.clients), true
+}
+
+func (backend *Backend) removeClient(c *connection) {
+	backend.clientsMu.Lock()
+	defer backend.clientsMu.Unlock()
+	delete(backend.clients, c.id)
+	close(c.closed)
+}
+
+// sortedClients returns the clients ordered by id
+func (backend *Backend) sortedClients() []*connection {
+	backend.clientsMu.Lock()
+	clients := make([]*connection, 0, len(backend.clients))
+	for _, c := range backend.clients {
+		clients = append(clients, c)
+	}
+	backend.clientsMu.Unlock()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].id < clients[j].id
+	})
+	return clients
+}
+
+// isUnixSocket reports whether c connected to the unix socket
+func (c *connection) isUnixSocket() bool {
+	_, ok := c.conn.LocalAddr().(*net.UnixAddr)
+	return ok
+}
+
+// addr is the address of the client, the clients of the unix socket are
+// named after the socket like in redis
+func (c *connection) addr() string {
+	if c.isUnixSocket() {
+		return c.laddr()
+	}
+	return c.conn.RemoteAddr().String()
+}
+
+// laddr is the address the client connected to
+func (c *connection) laddr() string {
+	if c.isUnixSocket() {
+		return c.conn.LocalAddr().String() + ":0"
+	}
+	return c.conn.LocalAddr().String()
+}
+
+// info formats the client like a line of CLIENT LIST
+func (c *connection) info() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	flags := "N"
+	if c.noEvict {
+		flags = "e"
+	}
+	if c.monitor != nil {
+		flags = "O"
+	}
+	if c.isUnixSocket() {
+		flags += "U"
+	}
+	var b strings.Builder
+	b.WriteString("id=" + strconv.FormatInt(c.id, 10))
+	b.WriteString(" addr=" + c.addr())
+	b.WriteString(" laddr=" + c.laddr())
+	b.WriteString(" name=" + c.name)
+	b.WriteString(" age=" + strconv.FormatInt(int64(now.Sub(c.createdAt)/time.Second), 10))
+	b.WriteString(" idle=" + strconv.FormatInt(int64(now.Sub(c.lastActive)/time.Second), 10))
+	b.WriteString(" flags=" + flags)
+	b.WriteString(" db=0 sub=0 psub=0 multi=-1")
+	b.WriteString(" qbuf=" + strconv.Itoa(c.queryBuffer))
+	b.WriteString(" qbuf-free=" + strconv.Itoa(ioBufferSize-c.queryBuffer))
+	b.WriteString(" obl=" + strconv.Itoa(c.outputBuffer))
+	b.WriteString(" oll=0")
+	b.WriteString(" omem=" + strconv.Itoa(c.outputBuffer))
+	b.WriteString(" cmd=" + c.lastCmd)
+	b.WriteString(" user=" + c.user)
+	b.WriteString(" resp=" + strconv.Itoa(c.protocol))
+	return b.String()
+}
+
+// clientCommand implements the CLIENT subcommands
+func (backend *Backend) clientCommand(c *connection, args [][]byte) entity.Reply {
+	sub := strings.ToLower(string(args[1]))
+	switch sub {
+	case "id":
+		if len(args) != 2 {
+			return executer.MakeArityErr("client|id")
+		}
+		return entity.MakeIntReply(c.id)
+	case "info":
+		if len(args) != 2 {
+			return executer.MakeArityErr("client|info")
+		}
+		return entity.MakeVerbatimReply("txt", []byte(c.info()+"\n"))
+	case "list":
+		return backend.clientList(args[2:])
+	case "getname":
+		if len(args) != 2 {
+			return executer.MakeArityErr("client|getname")
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.name == "" {
+			return entity.MakeNullBulkReply()
+		}
+		return entity.MakeBulkReply([]byte(c.name))
+	case "setname":
+		if len(args) != 3 {
+			return executer.MakeArityErr("client|setname")
+		}
+		if !validClientName(args[2]) {
+			return errInvalidClientName
+		}
+		c.mu.Lock()
+		c.name = string(args[2])
+		c.mu.Unlock()
+		return entity.MakeOkReply()
+	case "kill":
+		if len(args) < 3 {
+			return executer.MakeArityErr("client|kill")
+		}
+		return backend.clientKill(c, args[2:])
+	case "pause":
+		if len(args) != 3 && len(args) != 4 {
+			return executer.MakeArityErr("client|pause")
+		}
+		timeout, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil || timeout < 0 {
+			return entity.MakeErrReply("ERR timeout is not an integer or out of range")
+		}
+		writeOnly := false
+		if len(args) == 4 {
+			switch strings.ToLower(string(args[3])) {
+			case "write":
+				writeOnly = true
+			case "all":
+			default:
+				return entity.MakeErrReply("ERR syntax error")
+			}
+		}
+		backend.pause.start(time.Now().Add(time.Duration(timeout)*time.Millisecond), writeOnly)
+		return entity.MakeOkReply()
+	case "unpause":
+		if len(args) != 2 {
+			return executer.MakeArityErr("client|unpause")
+		}
+		backend.pause.stop()
+		return entity.MakeOkReply()
+	case "no-evict":
+		if len(args) != 3 {
+			return executer.MakeArityErr("client|no-evict")
+		}
+		switch strings.ToLower(string(args[2])) {
+		case "on":
+			c.mu.Lock()
+			c.noEvict = true
+			c.mu.Unlock()
+		case "off":
+			c.mu.Lock()
+			c.noEvict = false
+			c.mu.Unlock()
+		default:
+			return entity.MakeErrReply("ERR syntax error")
+		}
+		return entity.MakeOkReply()
+	}
+	return entity.MakeErrReply("ERR unknown subcommand '" + executer.ErrorArg(args[1]) + "'. Try CLIENT HELP.")
+}
+
+// clientList implements CLIENT LIST [TYPE type] [ID id [id ...]], every
+// client is a normal one
+func (backend *Backend) clientList(args [][]byte) entity.Reply {
+	clients := backend.sortedClients()
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return entity.MakeErrReply("ERR syntax error")
+		}
+		switch strings.ToLower(string(args[0])) {
+		case "type":
+			typ := strings.ToLower(string(args[1]))
+			if !isClientType(typ) {
+				return entity.MakeErrReply("ERR Unknown client type '" + executer.ErrorArg(args[1]) + "'")
+			}
+			if typ != "normal" {
+				clients = nil
+			}
+			args = args[2:]
+		case "id":
+			ids := make(map[int64]bool)
+			for _, arg := range args[1:] {
+				id, err := strconv.ParseInt(string(arg), 10, 64)
+				if err != nil || id <= 0 {
+					return entity.MakeErrReply("ERR Invalid client ID")
+				}
+				ids[id] = true
+			}
+			var selected []*connection
+			for _, c := range clients {
+				if ids[c.id] {
+					selected = append(selected, c)
+				}
+			}
+			clients = selected
+			args = nil
+		default:
+			return entity.MakeErrReply("ERR syntax error")
+		}
+	}
+	var b strings.Builder
+	for _, c := range clients {
+		b.WriteString(c.info())
+		b.WriteByte('\n')
+	}
+	return entity.MakeVerbatimReply("txt", []byte(b.String()))
+}
+
+var errInvalidClientName = entity.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+
+// validClientName reports whether name only has printable characters
+// other than space
+func validClientName(name []byte) bool {
+	for _, b := range name {
+		if b < '!' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func isClientType(typ string) bool {
+	return typ == "normal" || typ == "master" || typ == "replica" || typ == "slave" || typ == "pubsub"
+}
+
+// clientKill implements the old CLIENT KILL ip:port form and the new form
+// with ID, ADDR, LADDR, USER, TYPE, MAXAGE and SKIPME filters, which replies
+// the number of killed clients
+func (backend *Backend) clientKill(self *connection, args [][]byte) entity.Reply {
+	if len(args) == 1 {
+		addr := string(args[0])
+		for _, c := range backend.sortedClients() {
+			if c.addr() == addr {
+				backend.kill(self, c)
+				return entity.MakeOkReply()
+			}
+		}
+		return entity.MakeErrReply("ERR No such client")
+	}
+	if len(args)%2 != 0 {
+		return entity.MakeErrReply("ERR syntax error")
+	}
+	var filters []func(c *connection) bool
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "id":
+			id, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || id <= 0 {
+				return entity.MakeErrReply("ERR client-id should be greater than 0")
+			}
+			filters = append(filters, func(c *connection) bool { return c.id == id })
+		case "addr":
+			filters = append(filters, func(c *connection) bool { return c.addr() == value })
+		case "laddr":
+			filters = append(filters, func(c *connection) bool { return c.laddr() == value })
+		case "user":
+			filters = append(filters, func(c *connection) bool {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				return c.user == value
+			})
+		case "type":
+			typ := strings.ToLower(value)
+			if !isClientType(typ) {
+				return entity.MakeErrReply("ERR Unknown client type '" + executer.ErrorArg(args[i+1]) + "'")
+			}
+			filters = append(filters, func(c *connection) bool { return typ == "normal" })
+		case "maxage":
+			maxAge, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return entity.MakeErrReply("ERR syntax error")
+			}
+			filters = append(filters, func(c *connection) bool {
+				return time.Since(c.createdAt) >= time.Duration(maxAge)*time.Second
+			})
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return entity.MakeErrReply("ERR syntax error")
+			}
+		default:
+			return entity.MakeErrReply("ERR syntax error")
+		}
+	}
+	killed := 0
+	for _, c := range backend.sortedClients() {
+		if c == self && skipMe {
+			continue
+		}
+		match := true
+		for _, filter := range filters {
+			if !filter(c) {
+				match = false
+				break
+			}
+		}
+		if match {
+			backend.kill(self, c)
+			killed++
+		}
+	}
+	return entity.MakeIntReply(int64(killed))
+}
+
+// kill disconnects c, a client killing itself is closed after the reply
+func (backend *Backend) kill(self, c *connection) {
+	if c == self {
+		c.closeAfterReply = true
+		return
+	}
+	c.conn.Close()
+}
+
+// clientPause holds back the commands of the clients until a deadline,
+// either all of them or only the write commands
+type clientPause struct {
+	mu        sync.Mutex
+	until     time.Time
+	writeOnly bool
+	// changed is closed when the pause is started again or stopped
+	changed chan struct{}
+}
+
+// start pauses the clients until the later of until and the current
+// deadline, pausing all commands wins over pausing the write commands
+func (p *clientPause) start(until time.Time, writeOnly bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Now().Before(p.until) {
+		if until.Before(p.until) {
+			until = p.until
+		}
+		writeOnly = writeOnly && p.writeOnly
+	}
+	p.until, p.writeOnly = until, writeOnly
+	p.notify()
+}
+
+func (p *clientPause) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.until = time.Time{}
+	p.notify()
+}
+
+// notify wakes up the paused clients, the caller must hold mu
+func (p *clientPause) notify() {
+	if p.changed != nil {
+		close(p.changed)
+	}
+	p.changed = make(chan struct{})
+}
+
+// waitUnpaused blocks while cmd is paused, a shutdown ends the pause
+func (backend *Backend) waitUnpaused(cmd *executer.Command) {
+	p := &backend.pause
+	for {
+		p.mu.Lock()
+		remaining := time.Until(p.until)
+		if remaining <= 0 || (p.writeOnly && !cmd.HasFlag(executer.FlagWrite)) {
+			p.mu.Unlock()
+			return
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		timer := time.NewTimer(remaining)
+		select {
+		case <-timer.C:
+		case <-changed:
+		case <-backend.done:
+			timer.Stop()
+			return
+		}
+		timer.Stop()
+	}
+}
@@ -0,0 +1,222 @@
+package tcp
+
+import (
+	"bufio"
+	"kv_storage/entity"
+	"kv_storage/parser"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClient talks to a server, the replies are returned encoded in
+// protocol, RESP2 unless the test switched with HELLO
+type testClient struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	protocol int
+}
+
+func dial(t *testing.T, address string) *testClient {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, reader: bufio.NewReader(conn), protocol: entity.RESP2}
+}
+
+// send sends a command and returns the reply, or the error reading it
+func (c *testClient) send(args ...string) (string, error) {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	cmd := make([][]byte, len(args))
+	for i, arg := range args {
+		cmd[i] = []byte(arg)
+	}
+	if _, err := c.conn.Write(entity.MakeMultiBulkReply(cmd).ToBytes()); err != nil {
+		return "", err
+	}
+	reply, err := parser.ReadReply(c.reader)
+	if err != nil {
+		return "", err
+	}
+	return string(entity.Encode(reply, c.protocol)), nil
+}
+
+func (c *testClient) do(t *testing.T, args ...string) string {
+	reply, err := c.send(args...)
+	if err != nil {
+		t.Fatalf("%s: %v", strings.Join(args, " "), err)
+	}
+	return reply
+}
+
+// id returns the client id of c
+func (c *testClient) id(t *testing.T) string {
+	reply := c.do(t, "client", "id")
+	if !strings.HasPrefix(reply, ":") {
+		t.Fatalf("client id = %q", reply)
+	}
+	return strings.TrimSpace(reply[1:])
+}
+
+// closed reports whether the server closed the connection of c
+func (c *testClient) closed() bool {
+	_, err := c.send("ping")
+	return err != nil
+}
+
+func TestClientCommand(t *testing.T) {
+	c := dial(t, serveTest(t))
+	tests := []struct {
+		cmd  []string
+		want string
+	}{
+		{[]string{"client", "getname"}, "$-1\r\n"},
+		{[]string{"client", "setname", "app"}, "+OK\r\n"},
+		{[]string{"client", "getname"}, "$3\r\napp\r\n"},
+		{[]string{"client", "setname", "a b"}, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n"},
+		{[]string{"client", "setname", "a\nb"}, "-ERR Client names cannot contain spaces, newlines or special characters.\r\n"},
+		{[]string{"client", "getname"}, "$3\r\napp\r\n"},
+		{[]string{"client", "setname", ""}, "+OK\r\n"},
+		{[]string{"client", "getname"}, "$-1\r\n"},
+		{[]string{"client", "setname"}, "-ERR wrong number of arguments for 'client|setname' command\r\n"},
+		{[]string{"client", "id", "x"}, "-ERR wrong number of arguments for 'client|id' command\r\n"},
+		{[]string{"client", "no-evict", "on"}, "+OK\r\n"},
+		{[]string{"client", "no-evict", "maybe"}, "-ERR syntax error\r\n"},
+		{[]string{"client", "pause", "-1"}, "-ERR timeout is not an integer or out of range\r\n"},
+		{[]string{"client", "pause", "0", "reads"}, "-ERR syntax error\r\n"},
+		{[]string{"client", "pause", "0"}, "+OK\r\n"},
+		{[]string{"client", "unpause"}, "+OK\r\n"},
+		{[]string{"client", "list", "type", "pubsub"}, "$0\r\n\r\n"},
+		{[]string{"client", "list", "type", "bogus"}, "-ERR Unknown client type 'bogus'\r\n"},
+		{[]string{"client", "list", "id", "0"}, "-ERR Invalid client ID\r\n"},
+		{[]string{"client", "kill", "id", "0"}, "-ERR client-id should be greater than 0\r\n"},
+		{[]string{"client", "kill", "id", "1000000"}, ":0\r\n"},
+		{[]string{"client", "kill", "type", "master"}, ":0\r\n"},
+		{[]string{"client", "kill", "skipme", "maybe"}, "-ERR syntax error\r\n"},
+		{[]string{"client", "kill", "192.0.2.1:1"}, "-ERR No such client\r\n"},
+		{[]string{"client", "bogus"}, "-ERR unknown subcommand 'bogus'. Try CLIENT HELP.\r\n"},
+		{[]string{"client", "bo\r\n+OK"}, "-ERR unknown subcommand 'bo  +OK'. Try CLIENT HELP.\r\n"},
+		{[]string{"client", "list", "type", "a\nb"}, "-ERR Unknown client type 'a b'\r\n"},
+		{[]string{"client", "kill", "type", "a\r\nb"}, "-ERR Unknown client type 'a  b'\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(t, tt.cmd...); got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestClientList(t *testing.T) {
+	c := dial(t, serveTest(t))
+	c.do(t, "client", "setname", "app")
+	id := c.id(t)
+	for _, cmd := range [][]string{{"client", "list"}, {"client", "list", "id", id}, {"client", "info"}} {
+		reply := c.do(t, cmd...)
+		for _, field := range []string{"id=" + id + " ", " name=app ", " cmd=client|"} {
+			if !strings.Contains(reply, field) {
+				t.Errorf("%q = %q, want %q in it", cmd, reply, field)
+			}
+		}
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	tests := []struct {
+		name string
+		// kill returns the command killing victim
+		kill func(victim *testClient, victimID string) []string
+		want string
+		// self tells that the killer kills itself
+		self bool
+	}{
+		{"id", func(victim *testClient, id string) []string {
+			return []string{"client", "kill", "id", id}
+		}, ":1\r\n", false},
+		{"addr", func(victim *testClient, id string) []string {
+			return []string{"client", "kill", "addr", victim.conn.LocalAddr().String()}
+		}, ":1\r\n", false},
+		{"old form", func(victim *testClient, id string) []string {
+			return []string{"client", "kill", victim.conn.LocalAddr().String()}
+		}, "+OK\r\n", false},
+		{"self", func(victim *testClient, id string) []string {
+			return []string{"client", "kill", "id", id, "skipme", "no"}
+		}, ":1\r\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address := serveTest(t)
+			killer, bystander := dial(t, address), dial(t, address)
+			victim := dial(t, address)
+			if tt.self {
+				victim = killer
+			}
+			if got := killer.do(t, tt.kill(victim, victim.id(t))...); got != tt.want {
+				t.Fatalf("kill = %q, want %q", got, tt.want)
+			}
+			if !victim.closed() {
+				t.Fatal("the killed client is still served")
+			}
+			if bystander.closed() {
+				t.Fatal("another client was killed")
+			}
+		})
+	}
+}
+
+// TestClientAdminSubcommands checks that the subcommands acting on other
+// clients are refused to the users without the dangerous commands
+func TestClientAdminSubcommands(t *testing.T) {
+	address := serveTest(t)
+	admin, c := dial(t, address), dial(t, address)
+	if got := admin.do(t, "acl", "setuser", "app", "on", "nopass", "~*", "+@all", "-@dangerous"); got != "+OK\r\n" {
+		t.Fatalf("acl setuser = %q", got)
+	}
+	if got := c.do(t, "auth", "app", "x"); got != "+OK\r\n" {
+		t.Fatalf("auth = %q", got)
+	}
+	tests := []struct {
+		cmd     []string
+		allowed bool
+	}{
+		{[]string{"client", "id"}, true},
+		{[]string{"client", "setname", "app"}, true},
+		{[]string{"client", "getname"}, true},
+		{[]string{"client", "info"}, true},
+		{[]string{"client", "kill", "id", admin.id(t)}, false},
+		{[]string{"client", "list"}, false},
+		{[]string{"client", "pause", "0"}, false},
+		{[]string{"client", "unpause"}, false},
+		{[]string{"client", "no-evict", "on"}, false},
+	}
+	for _, tt := range tests {
+		got := c.do(t, tt.cmd...)
+		if denied := strings.HasPrefix(got, "-NOPERM"); denied == tt.allowed {
+			t.Errorf("%q = %q, allowed %v", tt.cmd, got, tt.allowed)
+		}
+	}
+	if admin.closed() {
+		t.Fatal("the admin was killed")
+	}
+	if got := admin.do(t, "acl", "log", "1"); !strings.Contains(got, "client|no-evict") {
+		t.Errorf("acl log = %q, want the denied subcommand", got)
+	}
+}
+
+func TestClientIDs(t *testing.T) {
+	address := serveTest(t)
+	previous := int64(0)
+	for i := 0; i < 3; i++ {
+		id, err := strconv.ParseInt(dial(t, address).id(t), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id <= previous {
+			t.Fatalf("client id %d after %d", id, previous)
+		}
+		previous = id
+	}
+}
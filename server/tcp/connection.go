@@ -0,0 +1,222 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"kv_storage/entity"
+	"kv_storage/executer"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+// serverVersion is the redis version whose protocol is implemented, clients
+// look at it in the HELLO reply to pick the features they use
+const serverVersion = "7.0.0"
+
+var lastConnectionID int64
+
+// ioBufferSize is the size of the read and write buffers of a connection,
+// like PROTO_IOBUF_LEN of redis
+const ioBufferSize = 16 * 1024
+
+// connection keeps the state of a client connection
+type connection struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writer    *bufio.Writer
+	out       outputWriter
+	id        int64
+	createdAt time.Time
+	// closed is closed once the connection is served no more
+	closed chan struct{}
+	// closeAfterReply is set when the client kills itself
+	closeAfterReply bool
+
+	// mu guards the fields below, they are changed by the goroutine serving
+	// the connection and read by CLIENT LIST from other goroutines
+	mu            sync.Mutex
+	protocol      int
+	name          string
+	user          string
+	authenticated bool
+	noEvict       bool
+	// monitor is set once the client sent MONITOR
+	monitor *monitor
+	// lastCmd is the command running or last run, lastActive the time it
+	// was received
+	lastCmd    string
+	lastActive time.Time
+	// queryBuffer and outputBuffer are the sizes of the buffers when the
+	// next request was read
+	queryBuffer  int
+	outputBuffer int
+}
+
+// newConnection wraps conn, with tls when tlsConfig is not nil
+func newConnection(conn net.Conn, tlsConfig *tls.Config) *connection {
+	now := time.Now()
+	c := &connection{
+		conn:       conn,
+		reader:     bufio.NewReaderSize(conn, ioBufferSize),
+		id:         atomic.AddInt64(&lastConnectionID, 1),
+		createdAt:  now,
+		closed:     make(chan struct{}),
+		protocol:   entity.RESP2,
+		user:       "default",
+		lastCmd:    "NULL",
+		lastActive: now,
+	}
+	c.out.conn = conn
+	if tlsConfig != nil {
+		c.conn = tls.Server(limitedConn{Conn: conn, out: &c.out}, tlsConfig)
+		c.reader = bufio.NewReaderSize(c.conn, ioBufferSize)
+		c.writer = bufio.NewWriterSize(c.conn, ioBufferSize)
+	} else {
+		c.writer = bufio.NewWriterSize(&c.out, ioBufferSize)
+	}
+	return c
+}
+
+// write buffers reply in the protocol version negotiated by the client, it
+// is sent by flush
+func (c *connection) write(reply entity.Reply) error {
+	c.out.begin(reply, c.protocol, c.writer.Buffered())
+	defer c.out.end()
+	_, err := entity.Write(c.writer, reply, c.protocol)
+	return err
+}
+
+// flush sends the buffered replies
+func (c *connection) flush() error {
+	return c.writer.Flush()
+}
+
+// beginCommand records the command the client sent
+func (c *connection) beginCommand(args [][]byte) {
+	name := "NULL"
+	if cmd, ok := executer.LookupCommand(args[0]); ok {
+		name = cmd.Name
+		if (name == "client" || name == "command" || name == "acl" || name == "slowlog" || name == "latency") && len(args) > 1 {
+			name += "|" + strings.ToLower(string(args[1]))
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastCmd = name
+	c.lastActive = time.Now()
+}
+
+// trackBuffers records the sizes of the buffers while the client waits for
+// its next request
+func (c *connection) trackBuffers() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.queryBuffer = c.reader.Buffered()
+	c.outputBuffer = c.writer.Buffered()
+}
+
+// pipelined reports whether more requests were already received, their
+// replies are sent together once the input is drained
+func (c *connection) pipelined() bool {
+	return c.reader.Buffered() > 0
+}
+
+func init() {
+	executer.RegisterCommand("hello", -1, executer.FlagNoSlowlog, 0, 0, 0, nil)
+}
+
+// connectionCommand handles the commands that change the state of the
+// connection, they are never forwarded to other nodes
+func (backend *Backend) connectionCommand(c *connection, cmd *executer.Command, args [][]byte) (entity.Reply, bool) {
+	switch cmd.Name {
+	case "hello":
+		return backend.hello(c, args), true
+	case "client":
+		return backend.clientCommand(c, args), true
+	case "auth":
+		return backend.auth(c, args), true
+	case "acl":
+		return backend.aclCommand(c, args), true
+	case "monitor":
+		return backend.startMonitor(c), true
+	case "quit":
+		c.closeAfterReply = true
+		return entity.MakeOkReply(), true
+	}
+	return nil, false
+}
+
+// hello switches the connection to the requested protocol version, it may
+// authenticate and name the client as well, and replies the properties of
+// the server
+func (backend *Backend) hello(c *connection, args [][]byte) entity.Reply {
+	version := c.protocol
+	if len(args) > 1 {
+		var err error
+		version, err = strconv.Atoi(string(args[1]))
+		if err != nil {
+			return entity.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if version != entity.RESP2 && version != entity.RESP3 {
+			return entity.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+	}
+	var username, password, name []byte
+	for i := 2; i < len(args); i++ {
+		more := len(args) - i - 1
+		switch strings.ToLower(string(args[i])) {
+		case "auth":
+			if more < 2 {
+				return entity.MakeErrReply("ERR Syntax error in HELLO option '" + executer.ErrorArg(args[i]) + "'")
+			}
+			username, password = args[i+1], args[i+2]
+			i += 2
+		case "setname":
+			if more < 1 {
+				return entity.MakeErrReply("ERR Syntax error in HELLO option '" + executer.ErrorArg(args[i]) + "'")
+			}
+			name = args[i+1]
+			i++
+		default:
+			return entity.MakeErrReply("ERR Syntax error in HELLO option '" + executer.ErrorArg(args[i]) + "'")
+		}
+	}
+	if username != nil {
+		if reply := backend.login(c, username, password); entity.IsErrorReply(reply) {
+			return reply
+		}
+	}
+	if !c.authenticated {
+		return entity.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	if name != nil {
+		if !validClientName(name) {
+			return errInvalidClientName
+		}
+		c.mu.Lock()
+		c.name = string(name)
+		c.mu.Unlock()
+	}
+	c.mu.Lock()
+	c.protocol = version
+	c.mu.Unlock()
+	mode := "standalone"
+	if backend.isCluster {
+		mode = "cluster"
+	}
+	return entity.MakeMapReply([]redis.Reply{
+		entity.MakeBulkReply([]byte("server")), entity.MakeBulkReply([]byte("kv_storage")),
+		entity.MakeBulkReply([]byte("version")), entity.MakeBulkReply([]byte(serverVersion)),
+		entity.MakeBulkReply([]byte("proto")), entity.MakeIntReply(int64(c.protocol)),
+		entity.MakeBulkReply([]byte("id")), entity.MakeIntReply(c.id),
+		entity.MakeBulkReply([]byte("mode")), entity.MakeBulkReply([]byte(mode)),
+		entity.MakeBulkReply([]byte("role")), entity.MakeBulkReply([]byte("master")),
+		entity.MakeBulkReply([]byte("modules")), entity.MakeEmptyMultiBulkReply(),
+	})
+}
@@ -0,0 +1,47 @@
+package tcp
+
+import (
+	"kv_storage/entity"
+	"testing"
+)
+
+func TestHello(t *testing.T) {
+	c := dial(t, serveTest(t))
+	id := c.id(t)
+	properties := func(header string, proto string) string {
+		return header + "$6\r\nserver\r\n$10\r\nkv_storage\r\n$7\r\nversion\r\n$5\r\n" + serverVersion + "\r\n" +
+			"$5\r\nproto\r\n:" + proto + "\r\n$2\r\nid\r\n:" + id + "\r\n$4\r\nmode\r\n$10\r\nstandalone\r\n" +
+			"$4\r\nrole\r\n$6\r\nmaster\r\n$7\r\nmodules\r\n*0\r\n"
+	}
+	c.do(t, "zadd", "z", "1.5", "a", "2", "b")
+	tests := []struct {
+		cmd      []string
+		protocol int
+		want     string
+	}{
+		{[]string{"hello"}, entity.RESP2, properties("*14\r\n", "2")},
+		{[]string{"get", "missing"}, entity.RESP2, "$-1\r\n"},
+		{[]string{"zrevrange", "z", "0", "-1", "withscores"}, entity.RESP2, "*4\r\n$1\r\nb\r\n$1\r\n2\r\n$1\r\na\r\n$3\r\n1.5\r\n"},
+		{[]string{"hello", "4"}, entity.RESP2, "-NOPROTO unsupported protocol version\r\n"},
+		{[]string{"hello", "x"}, entity.RESP2, "-ERR Protocol version is not an integer or out of range\r\n"},
+		{[]string{"hello", "3", "setname"}, entity.RESP2, "-ERR Syntax error in HELLO option 'setname'\r\n"},
+		{[]string{"hello", "3", "auth", "default"}, entity.RESP2, "-ERR Syntax error in HELLO option 'auth'\r\n"},
+		{[]string{"hello", "3", "bogus"}, entity.RESP2, "-ERR Syntax error in HELLO option 'bogus'\r\n"},
+		{[]string{"hello", "3", "auth", "nobody", "wrong"}, entity.RESP2, "-WRONGPASS invalid username-password pair or user is disabled.\r\n"},
+		// a failed HELLO keeps the protocol
+		{[]string{"get", "missing"}, entity.RESP2, "$-1\r\n"},
+		{[]string{"hello", "3", "setname", "app"}, entity.RESP3, properties("%7\r\n", "3")},
+		{[]string{"get", "missing"}, entity.RESP3, "_\r\n"},
+		{[]string{"client", "getname"}, entity.RESP3, "$3\r\napp\r\n"},
+		{[]string{"zrevrange", "z", "0", "-1", "withscores"}, entity.RESP3, "*2\r\n*2\r\n$1\r\nb\r\n,2\r\n*2\r\n$1\r\na\r\n,1.5\r\n"},
+		{[]string{"hello"}, entity.RESP3, properties("%7\r\n", "3")},
+		{[]string{"hello", "2"}, entity.RESP2, properties("*14\r\n", "2")},
+		{[]string{"get", "missing"}, entity.RESP2, "$-1\r\n"},
+	}
+	for _, tt := range tests {
+		c.protocol = tt.protocol
+		if got := c.do(t, tt.cmd...); got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"kv_storage/entity"
+	"kv_storage/executer"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	executer.RegisterCommand("info", -1, executer.FlagAdmin, 0, 0, 0, nil)
+}
+
+// infoSections are the sections of INFO in the order they are written,
+// all of them are default ones
+var infoSections = []string{"server", "clients", "memory", "persistence", "stats", "replication", "latencystats", "keyspace"}
+
+// statsSampleInterval and statsSamples make the instantaneous metrics the
+// average of the rates seen over the last 1.6 seconds, like in redis
+const (
+	statsSampleInterval = 100 * time.Millisecond
+	statsSamples        = 16
+)
+
+// serverStats are the counters of INFO stats
+type serverStats struct {
+	startedAt time.Time
+	runID     string
+
+	connections int64 // accepted clients
+	rejected    int64 // clients refused by maxclients
+	commands    int64
+	netInput    int64
+	netOutput   int64
+
+	ops    metric
+	input  metric
+	output metric
+
+	peakMemory uint64
+}
+
+func newServerStats() serverStats {
+	id := make([]byte, 20)
+	rand.Read(id)
+	return serverStats{startedAt: time.Now(), runID: hex.EncodeToString(id)}
+}
+
+// metric turns the samples of a counter into an instantaneous rate
+type metric struct {
+	mu        sync.Mutex
+	lastTime  time.Time
+	lastValue int64
+	samples   [statsSamples]float64
+	next      int
+}
+
+// track records the value of the counter at now
+func (m *metric) track(value int64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.lastTime.IsZero() {
+		elapsed := now.Sub(m.lastTime).Seconds()
+		if elapsed > 0 {
+			m.samples[m.next] = float64(value-m.lastValue) / elapsed
+			m.next = (m.next + 1) % statsSamples
+		}
+	}
+	m.lastTime, m.lastValue = now, value
+}
+
+// rate returns the average per second of the samples
+func (m *metric) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sum float64
+	for _, sample := range m.samples {
+		sum += sample
+	}
+	return sum / statsSamples
+}
+
+// sampleStats samples the counters of the instantaneous metrics and the
+// memory peak until the server is shut down
+func (backend *Backend) sampleStats() {
+	ticker := time.NewTicker(statsSampleInterval)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		select {
+		case now := <-ticker.C:
+			stats := &backend.stats
+			stats.ops.track(atomic.LoadInt64(&stats.commands), now)
+			stats.input.track(atomic.LoadInt64(&stats.netInput), now)
+			stats.output.track(atomic.LoadInt64(&stats.netOutput), now)
+			if i%10 == 0 {
+				var mem runtime.MemStats
+				runtime.ReadMemStats(&mem)
+				backend.trackPeakMemory(mem.HeapAlloc)
+			}
+		case <-backend.done:
+			return
+		}
+	}
+}
+
+func (backend *Backend) trackPeakMemory(used uint64) uint64 {
+	for {
+		peak := atomic.LoadUint64(&backend.stats.peakMemory)
+		if used <= peak {
+			return peak
+		}
+		if atomic.CompareAndSwapUint64(&backend.stats.peakMemory, peak, used) {
+			return used
+		}
+	}
+}
+
+// meteredConn counts the bytes read from and written to a client
+type meteredConn struct {
+	net.Conn
+	stats *serverStats
+}
+
+func (c meteredConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.stats.netInput, int64(n))
+	return n, err
+}
+
+func (c meteredConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.stats.netOutput, int64(n))
+	return n, err
+}
+
+// info implements INFO [section ...], where a section may also be default,
+// all or everything
+func (backend *Backend) info(args [][]byte) entity.Reply {
+	sections := make(map[string]bool)
+	if len(args) == 1 {
+		sections["default"] = true
+	}
+	for _, arg := range args[1:] {
+		sections[strings.ToLower(string(arg))] = true
+	}
+	all := sections["default"] || sections["all"] || sections["everything"]
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !sections[section] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString("# " + strings.ToUpper(section[:1]) + section[1:] + "\r\n")
+		for _, field := range backend.infoSection(section) {
+			b.WriteString(field[0] + ":" + field[1] + "\r\n")
+		}
+	}
+	return entity.MakeVerbatimReply("txt", []byte(b.String()))
+}
+
+// infoSection returns the fields of a section as name and value pairs
+func (backend *Backend) infoSection(section string) [][2]string {
+	switch section {
+	case "server":
+		return backend.infoServer()
+	case "clients":
+		return backend.infoClients()
+	case "memory":
+		return backend.infoMemory()
+	case "persistence":
+		return backend.infoPersistence()
+	case "stats":
+		return backend.infoStats()
+	case "replication":
+		return [][2]string{
+			{"role", "master"},
+			{"connected_slaves", "0"},
+			{"master_failover_state", "no-failover"},
+			{"master_replid", backend.stats.runID},
+			{"master_replid2", strings.Repeat("0", 40)},
+			{"master_repl_offset", "0"},
+			{"second_repl_offset", "-1"},
+			{"repl_backlog_active", "0"},
+			{"repl_backlog_size", "0"},
+			{"repl_backlog_first_byte_offset", "0"},
+			{"repl_backlog_histlen", "0"},
+		}
+	case "latencystats":
+		return backend.infoLatencystats()
+	case "keyspace":
+		keys, expires, avgTTL := backend.executer.Keyspace()
+		if keys == 0 {
+			return nil
+		}
+		return [][2]string{{"db0", fmt.Sprintf("keys=%d,expires=%d,avg_ttl=%d", keys, expires, avgTTL.Milliseconds())}}
+	}
+	return nil
+}
+
+func (backend *Backend) infoServer() [][2]string {
+	mode := "standalone"
+	if backend.isCluster {
+		mode = "cluster"
+	}
+	port := "0"
+	if _, p, err := net.SplitHostPort(backend.tcpAddress); err == nil {
+		port = p
+	}
+	executable, _ := os.Executable()
+	uptime := time.Since(backend.stats.startedAt)
+	return [][2]string{
+		{"redis_version", serverVersion},
+		{"redis_mode", mode},
+		{"os", runtime.GOOS + " " + runtime.GOARCH},
+		{"arch_bits", strconv.Itoa(strconv.IntSize)},
+		{"multiplexing_api", "goroutine"},
+		{"go_version", runtime.Version()},
+		{"process_id", strconv.Itoa(os.Getpid())},
+		{"run_id", backend.stats.runID},
+		{"tcp_port", port},
+		{"server_time_usec", strconv.FormatInt(time.Now().UnixMicro(), 10)},
+		{"uptime_in_seconds", strconv.FormatInt(int64(uptime/time.Second), 10)},
+		{"uptime_in_days", strconv.FormatInt(int64(uptime/(24*time.Hour)), 10)},
+		{"executable", executable},
+	}
+}
+
+func (backend *Backend) infoClients() [][2]string {
+	clients := backend.sortedClients()
+	maxInput, maxOutput := 0, 0
+	for _, c := range clients {
+		c.mu.Lock()
+		if c.queryBuffer > maxInput {
+			maxInput = c.queryBuffer
+		}
+		if c.outputBuffer > maxOutput {
+			maxOutput = c.outputBuffer
+		}
+		c.mu.Unlock()
+	}
+	return [][2]string{
+		{"connected_clients", strconv.Itoa(len(clients))},
+		{"maxclients", strconv.Itoa(backend.maxClients)},
+		{"client_recent_max_input_buffer", strconv.Itoa(maxInput)},
+		{"client_recent_max_output_buffer", strconv.Itoa(maxOutput)},
+		{"blocked_clients", "0"},
+		{"tracking_clients", "0"},
+	}
+}
+
+// infoMemory reports the memory of the go heap as used memory, which
+// estimates the size of the dataset and of the client buffers
+func (backend *Backend) infoMemory() [][2]string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	used := mem.HeapAlloc
+	peak := backend.trackPeakMemory(used)
+	rss := residentMemory()
+	if rss == 0 {
+		rss = mem.Sys
+	}
+	system := systemMemory()
+	return [][2]string{
+		{"used_memory", strconv.FormatUint(used, 10)},
+		{"used_memory_human", bytesToHuman(used)},
+		{"used_memory_rss", strconv.FormatUint(rss, 10)},
+		{"used_memory_rss_human", bytesToHuman(rss)},
+		{"used_memory_peak", strconv.FormatUint(peak, 10)},
+		{"used_memory_peak_human", bytesToHuman(peak)},
+		{"used_memory_peak_perc", fmt.Sprintf("%.2f%%", float64(used)*100/float64(peak))},
+		{"total_system_memory", strconv.FormatUint(system, 10)},
+		{"total_system_memory_human", bytesToHuman(system)},
+		{"maxmemory", "0"},
+		{"maxmemory_human", "0B"},
+		{"maxmemory_policy", "noeviction"},
+		{"mem_fragmentation_ratio", fmt.Sprintf("%.2f", float64(rss)/float64(used))},
+		{"mem_allocator", "go"},
+	}
+}
+
+func (backend *Backend) infoPersistence() [][2]string {
+	save := backend.saver.Status()
+	aof := backend.aof.Status()
+	fields := [][2]string{
+		{"loading", "0"},
+		{"async_loading", "0"},
+		{"rdb_changes_since_last_save", strconv.FormatInt(save.Changes, 10)},
+		{"rdb_bgsave_in_progress", boolToInfo(save.Saving)},
+		{"rdb_last_save_time", strconv.FormatInt(save.LastSave.Unix(), 10)},
+		{"rdb_last_bgsave_status", statusToInfo(save.LastStatus)},
+		{"rdb_last_bgsave_time_sec", lastDurationToInfo(save.Started, save.Saving, save.LastDuration)},
+		{"rdb_current_bgsave_time_sec", currentDurationToInfo(save.Started, save.Saving)},
+		{"aof_enabled", boolToInfo(aof.Enabled)},
+		{"aof_rewrite_in_progress", boolToInfo(aof.Rewriting)},
+		{"aof_rewrite_scheduled", "0"},
+		{"aof_last_rewrite_time_sec", lastDurationToInfo(aof.RewriteStarted, aof.Rewriting, aof.LastRewriteDuration)},
+		{"aof_current_rewrite_time_sec", currentDurationToInfo(aof.RewriteStarted, aof.Rewriting)},
+		{"aof_last_bgrewrite_status", statusToInfo(aof.LastRewriteErr)},
+		{"aof_last_write_status", statusToInfo(aof.LastWriteErr)},
+	}
+	if aof.Enabled {
+		fields = append(fields,
+			[2]string{"aof_current_size", strconv.FormatInt(aof.CurrentSize, 10)},
+			[2]string{"aof_base_size", strconv.FormatInt(aof.BaseSize, 10)})
+	}
+	return fields
+}
+
+func (backend *Backend) infoStats() [][2]string {
+	stats := &backend.stats
+	keyspace := backend.executer.Stats()
+	return [][2]string{
+		{"total_connections_received", strconv.FormatInt(atomic.LoadInt64(&stats.connections), 10)},
+		{"total_commands_processed", strconv.FormatInt(atomic.LoadInt64(&stats.commands), 10)},
+		{"instantaneous_ops_per_sec", strconv.FormatInt(int64(stats.ops.rate()), 10)},
+		{"total_net_input_bytes", strconv.FormatInt(atomic.LoadInt64(&stats.netInput), 10)},
+		{"total_net_output_bytes", strconv.FormatInt(atomic.LoadInt64(&stats.netOutput), 10)},
+		{"instantaneous_input_kbps", fmt.Sprintf("%.2f", stats.input.rate()/1024)},
+		{"instantaneous_output_kbps", fmt.Sprintf("%.2f", stats.output.rate()/1024)},
+		{"rejected_connections", strconv.FormatInt(atomic.LoadInt64(&stats.rejected), 10)},
+		{"expired_keys", strconv.FormatInt(keyspace.ExpiredKeys, 10)},
+		{"evicted_keys", "0"},
+		{"keyspace_hits", strconv.FormatInt(keyspace.KeyspaceHits, 10)},
+		{"keyspace_misses", strconv.FormatInt(keyspace.KeyspaceMisses, 10)},
+		{"pubsub_channels", "0"},
+		{"pubsub_patterns", "0"},
+	}
+}
+
+func boolToInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func statusToInfo(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
+
+// lastDurationToInfo formats in seconds how long the last save or rewrite
+// took, -1 when there was none
+func lastDurationToInfo(started time.Time, running bool, last time.Duration) string {
+	if started.IsZero() || running && last == 0 {
+		return "-1"
+	}
+	return strconv.FormatInt(int64(last/time.Second), 10)
+}
+
+// currentDurationToInfo formats in seconds for how long the save or rewrite
+// in progress has run, -1 when none is running
+func currentDurationToInfo(started time.Time, running bool) string {
+	if !running {
+		return "-1"
+	}
+	return strconv.FormatInt(int64(time.Since(started)/time.Second), 10)
+}
+
+// bytesToHuman formats n like redis does, such as 1.50M
+func bytesToHuman(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T", "P"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatUint(n, 10) + "B"
+	}
+	return fmt.Sprintf("%.2f%s", value, units[i])
+}
+
+// residentMemory returns the resident set size of the process, 0 when the
+// system doesn't tell it
+func residentMemory() uint64 {
+	data, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return 0
+	}
+	pages, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return pages * uint64(os.Getpagesize())
+}
+
+// systemMemory returns the memory of the machine, 0 when the system doesn't
+// tell it
+func systemMemory() uint64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, _ := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024
+		}
+	}
+	return 0
+}
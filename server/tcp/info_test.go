@@ -0,0 +1,101 @@
+package tcp
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// infoReply sends INFO with args and returns its section headers and fields
+func infoReply(t *testing.T, c *testClient, args ...string) ([]string, map[string]string) {
+	reply := c.do(t, append([]string{"info"}, args...)...)
+	if !strings.HasPrefix(reply, "$") {
+		t.Fatalf("info %s = %q, want a bulk string", strings.Join(args, " "), reply)
+	}
+	var sections []string
+	fields := make(map[string]string)
+	for _, line := range strings.Split(reply[strings.Index(reply, "\r\n")+2:], "\r\n") {
+		if strings.HasPrefix(line, "# ") {
+			sections = append(sections, line[2:])
+		} else if name, value, ok := strings.Cut(line, ":"); ok {
+			fields[name] = value
+		}
+	}
+	return sections, fields
+}
+
+func TestInfoSections(t *testing.T) {
+	c := dial(t, serveTest(t))
+	all := []string{"Server", "Clients", "Memory", "Persistence", "Stats", "Replication", "Latencystats", "Keyspace"}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{nil, all},
+		{[]string{"default"}, all},
+		{[]string{"all"}, all},
+		{[]string{"everything"}, all},
+		{[]string{"keyspace"}, []string{"Keyspace"}},
+		{[]string{"STATS"}, []string{"Stats"}},
+		// the sections keep their order whatever the order of the arguments
+		{[]string{"clients", "server"}, []string{"Server", "Clients"}},
+		{[]string{"bogus"}, nil},
+	}
+	for _, tt := range tests {
+		if got, _ := infoReply(t, c, tt.args...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("info %s sections = %q, want %q", strings.Join(tt.args, " "), got, tt.want)
+		}
+	}
+}
+
+func TestInfoFields(t *testing.T) {
+	address := serveTest(t)
+	c := dial(t, address)
+	if _, fields := infoReply(t, c, "keyspace"); len(fields) != 0 {
+		t.Errorf("keyspace of an empty dataset = %q, want no field", fields)
+	}
+
+	dial(t, address).do(t, "ping")
+	c.do(t, "set", "a", "1")
+	c.do(t, "set", "b", "2", "ex", "100")
+	c.do(t, "rpush", "l", "x")
+	c.do(t, "get", "a")
+	c.do(t, "get", "missing")
+	_, fields := infoReply(t, c)
+	tests := []struct {
+		field string
+		want  string
+	}{
+		{"redis_mode", "standalone"},
+		{"arch_bits", strconv.Itoa(strconv.IntSize)},
+		{"connected_clients", "2"},
+		{"blocked_clients", "0"},
+		{"maxmemory_policy", "noeviction"},
+		{"aof_enabled", "0"},
+		{"rdb_bgsave_in_progress", "0"},
+		{"total_connections_received", "2"},
+		{"keyspace_hits", "1"},
+		{"keyspace_misses", "1"},
+		{"expired_keys", "0"},
+		{"role", "master"},
+		{"connected_slaves", "0"},
+	}
+	for _, tt := range tests {
+		if got := fields[tt.field]; got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+	if _, ok := fields["aof_current_size"]; ok {
+		t.Error("aof_current_size reported without an aof")
+	}
+
+	db, ok := fields["db0"]
+	if !ok || !strings.HasPrefix(db, "keys=3,expires=1,avg_ttl=") {
+		t.Fatalf("db0 = %q, want keys=3,expires=1", db)
+	}
+	avgTTL, err := strconv.Atoi(strings.TrimPrefix(db, "keys=3,expires=1,avg_ttl="))
+	if err != nil || avgTTL <= 99000 || avgTTL > 100000 {
+		t.Errorf("db0 avg_ttl = %d, %v, want about 100000", avgTTL, err)
+	}
+}
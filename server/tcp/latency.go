@@ -0,0 +1,136 @@
+package tcp
+
+import (
+	"errors"
+	"kv_storage/entity"
+	"kv_storage/executer"
+	"kv_storage/latency"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+func init() {
+	executer.RegisterCommand("latency", -2, executer.FlagAdmin, 0, 0, 0, nil)
+}
+
+// defaultInfoPercentiles are the percentiles of INFO latencystats when
+// latency-tracking-info-percentiles is invalid
+var defaultInfoPercentiles = []float64{50, 99, 99.9}
+
+// parseInfoPercentiles parses latency-tracking-info-percentiles, a list of
+// percentages separated by spaces
+func parseInfoPercentiles(s string) ([]float64, error) {
+	var percentiles []float64
+	for _, field := range strings.Fields(s) {
+		p, err := strconv.ParseFloat(field, 64)
+		if err != nil || p < 0 || p > 100 {
+			return nil, errors.New(field + " is not a percentile between 0 and 100")
+		}
+		percentiles = append(percentiles, p)
+	}
+	return percentiles, nil
+}
+
+// latencyCommand implements LATENCY LATEST, HISTORY event, RESET [event ...]
+// and HISTOGRAM [command ...]
+func (backend *Backend) latencyCommand(args [][]byte) entity.Reply {
+	switch strings.ToLower(string(args[1])) {
+	case "latest":
+		if len(args) != 2 {
+			return executer.MakeArityErr("latency|latest")
+		}
+		var replies []redis.Reply
+		for _, latest := range latency.LatestSamples() {
+			replies = append(replies, entity.MakeMultiRawReply([]redis.Reply{
+				entity.MakeBulkReply([]byte(latest.Event)),
+				entity.MakeIntReply(latest.Time.Unix()),
+				entity.MakeIntReply(latest.Duration.Milliseconds()),
+				entity.MakeIntReply(latest.Max.Milliseconds()),
+			}))
+		}
+		return entity.MakeMultiRawReply(replies)
+	case "history":
+		if len(args) != 3 {
+			return executer.MakeArityErr("latency|history")
+		}
+		var replies []redis.Reply
+		for _, sample := range latency.History(string(args[2])) {
+			replies = append(replies, entity.MakeMultiRawReply([]redis.Reply{
+				entity.MakeIntReply(sample.Time.Unix()),
+				entity.MakeIntReply(sample.Duration.Milliseconds()),
+			}))
+		}
+		return entity.MakeMultiRawReply(replies)
+	case "reset":
+		names := make([]string, 0, len(args)-2)
+		for _, arg := range args[2:] {
+			names = append(names, string(arg))
+		}
+		return entity.MakeIntReply(int64(latency.Reset(names...)))
+	case "histogram":
+		return latencyHistogram(args[2:])
+	case "help":
+		return entity.MakeMultiBulkReply([][]byte{
+			[]byte("LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			[]byte("LATEST"),
+			[]byte("    Return the latest latency samples for all events."),
+			[]byte("HISTORY <event>"),
+			[]byte("    Return time-latency samples for the <event> class."),
+			[]byte("RESET [<event> ...]"),
+			[]byte("    Reset latency data of one or more <event> classes."),
+			[]byte("    (default: reset all data for all event classes)"),
+			[]byte("HISTOGRAM [COMMAND ...]"),
+			[]byte("    Return a cumulative distribution of latencies in the format of a histogram for the specified command names."),
+			[]byte("    If no commands are specified then all histograms are replied."),
+		})
+	}
+	return entity.MakeErrReply("ERR unknown subcommand '" + executer.ErrorArg(args[1]) + "'. Try LATENCY HELP.")
+}
+
+// latencyHistogram replies for each command its number of calls and its
+// durations in power of two microseconds buckets, the commands that never
+// ran are left out
+func latencyHistogram(names [][]byte) entity.Reply {
+	commands := latency.Commands()
+	if len(names) > 0 {
+		commands = commands[:0:0]
+		for _, name := range names {
+			commands = append(commands, strings.ToLower(string(name)))
+		}
+	}
+	var replies []redis.Reply
+	for _, name := range commands {
+		h := latency.CommandHistogram(name)
+		if h == nil {
+			continue
+		}
+		var buckets []redis.Reply
+		for _, bucket := range h.Cumulative() {
+			buckets = append(buckets, entity.MakeIntReply(bucket.Usec), entity.MakeIntReply(bucket.Count))
+		}
+		replies = append(replies, entity.MakeBulkReply([]byte(name)), entity.MakeMapReply([]redis.Reply{
+			entity.MakeBulkReply([]byte("calls")), entity.MakeIntReply(h.Count()),
+			entity.MakeBulkReply([]byte("histogram_usec")), entity.MakeMapReply(buckets),
+		}))
+	}
+	return entity.MakeMapReply(replies)
+}
+
+// infoLatencystats returns the percentiles of the durations of each command
+// in microseconds
+func (backend *Backend) infoLatencystats() [][2]string {
+	var fields [][2]string
+	for _, name := range latency.Commands() {
+		durations := latency.CommandHistogram(name).Percentiles(backend.infoPercentiles...)
+		values := make([]string, len(durations))
+		for i, d := range durations {
+			values[i] = "p" + strconv.FormatFloat(backend.infoPercentiles[i], 'f', -1, 64) + "=" +
+				strconv.FormatFloat(float64(d)/float64(time.Microsecond), 'f', 3, 64)
+		}
+		fields = append(fields, [2]string{"latency_percentiles_usec_" + name, strings.Join(values, ",")})
+	}
+	return fields
+}
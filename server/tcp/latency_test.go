@@ -0,0 +1,102 @@
+package tcp
+
+import (
+	"kv_storage/entity"
+	"kv_storage/latency"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInfoPercentiles(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []float64
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"50 99 99.9", []float64{50, 99, 99.9}, false},
+		{" 0  100 ", []float64{0, 100}, false},
+		{"101", nil, true},
+		{"-1", nil, true},
+		{"50 p99", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := parseInfoPercentiles(tt.s)
+		if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.wantErr {
+			t.Errorf("parseInfoPercentiles(%q) = %v, %v, want %v, an error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// recordTestCommand records durations for a command name no other test
+// uses, the histograms are global
+func recordTestCommand(durations ...time.Duration) string {
+	name := "test-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	for _, d := range durations {
+		latency.RecordCommand(name, d)
+	}
+	return name
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	name := recordTestCommand(500, 1500, 3000)
+	histogram := "%1\r\n$" + strconv.Itoa(len(name)) + "\r\n" + name + "\r\n" +
+		"%2\r\n$5\r\ncalls\r\n:3\r\n$14\r\nhistogram_usec\r\n%3\r\n:1\r\n:1\r\n:2\r\n:2\r\n:4\r\n:3\r\n"
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{[]string{name}, histogram},
+		{[]string{strings.ToUpper(name), "never-ran"}, histogram},
+		{[]string{"never-ran"}, "%0\r\n"},
+	}
+	for _, tt := range tests {
+		args := make([][]byte, len(tt.names))
+		for i, name := range tt.names {
+			args[i] = []byte(name)
+		}
+		if got := string(entity.Encode(latencyHistogram(args), entity.RESP3)); got != tt.want {
+			t.Errorf("latency histogram %s = %q, want %q", strings.Join(tt.names, " "), got, tt.want)
+		}
+	}
+}
+
+func TestInfoLatencystats(t *testing.T) {
+	name := recordTestCommand(time.Microsecond, 3*time.Microsecond)
+	backend := &Backend{infoPercentiles: []float64{50, 99.9}}
+	want := "p50=1.007,p99.9=3.007"
+	for _, field := range backend.infoLatencystats() {
+		if field[0] == "latency_percentiles_usec_"+name {
+			if field[1] != want {
+				t.Errorf("%s = %q, want %q", field[0], field[1], want)
+			}
+			return
+		}
+	}
+	t.Errorf("no latency percentiles of %s", name)
+}
+
+func TestLatencyCommand(t *testing.T) {
+	c := dial(t, serveTest(t))
+	c.do(t, "ping")
+	c.do(t, "hello", "3")
+	c.protocol = entity.RESP3
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"latency histogram ping", "%1\r\n$4\r\nping\r\n%2\r\n$5\r\ncalls\r\n:"},
+		{"latency histogram never-ran", "%0\r\n"},
+		{"latency bogus", "-ERR unknown subcommand 'bogus'. Try LATENCY HELP.\r\n"},
+		{"latency latest extra", "-ERR wrong number of arguments for 'latency|latest' command\r\n"},
+		{"latency history", "-ERR wrong number of arguments for 'latency|history' command\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(t, strings.Fields(tt.cmd)...); !strings.HasPrefix(got, tt.want) {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+	"kv_storage/config"
+	"kv_storage/entity"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+// outputBufferLimit disconnects a client whose pending output reaches hard
+// bytes, or stays at soft bytes or more for softSeconds, 0 disables a limit
+type outputBufferLimit struct {
+	hard        int64
+	soft        int64
+	softSeconds time.Duration
+}
+
+func (l outputBufferLimit) enabled() bool {
+	return l.hard > 0 || l.soft > 0
+}
+
+// parseOutputBufferLimits parses client-output-buffer-limit, classes left
+// out have no limits. The replica and pubsub classes are accepted so that
+// the redis configs load, but the server has no such clients and only the
+// normal limits are enforced.
+func parseOutputBufferLimits(s string) (map[string]outputBufferLimit, error) {
+	fields := strings.Fields(strings.Trim(s, "\""))
+	if len(fields)%4 != 0 {
+		return nil, errors.New("invalid client output buffer limits: " + s)
+	}
+	limits := make(map[string]outputBufferLimit)
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = "replica"
+		}
+		if class != "normal" && class != "replica" && class != "pubsub" {
+			return nil, errors.New("invalid client class in client output buffer limits: " + fields[i])
+		}
+		hard, err := config.ParseSize(fields[i+1])
+		if err != nil || hard < 0 {
+			return nil, errors.New("invalid client output buffer limits: " + s)
+		}
+		soft, err := config.ParseSize(fields[i+2])
+		if err != nil || soft < 0 {
+			return nil, errors.New("invalid client output buffer limits: " + s)
+		}
+		seconds, err := strconv.ParseInt(fields[i+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return nil, errors.New("invalid client output buffer limits: " + s)
+		}
+		limits[class] = outputBufferLimit{hard: hard, soft: soft, softSeconds: time.Duration(seconds) * time.Second}
+	}
+	return limits, nil
+}
+
+// outputCheckInterval is how long a write waits for a client that does not
+// read before the output buffer limit is checked
+const outputCheckInterval = 100 * time.Millisecond
+
+// errOutputBufferLimit is returned by the writes of a client over its
+// output buffer limit
+var errOutputBufferLimit = errors.New("output buffer limits")
+
+// outputWriter writes the output of a client to the connection. The replies
+// are encoded as the client reads them, so the pending output of a client
+// that doesn't read is the rest of the reply being written plus the earlier
+// replies still buffered, it is checked against the limit while the writes
+// wait.
+type outputWriter struct {
+	conn  net.Conn
+	limit outputBufferLimit
+
+	// reply is the reply being written and protocol its version, its size
+	// is computed once a write waits, -1 until then
+	reply    redis.Reply
+	protocol int
+	size     int64
+	// buffered is the output buffered before the reply, sent counts the
+	// bytes sent since the reply began
+	buffered int64
+	sent     int64
+	// softSince is when the pending output reached the soft limit
+	softSince time.Time
+	// deadline is the write deadline set by the tls connection above, the
+	// writes past it fail whatever the limit
+	deadline time.Time
+}
+
+// begin records that reply is written after buffered bytes of output
+func (w *outputWriter) begin(reply redis.Reply, protocol int, buffered int) {
+	w.reply, w.protocol, w.size = reply, protocol, -1
+	w.buffered, w.sent = int64(buffered), 0
+}
+
+func (w *outputWriter) end() {
+	w.reply = nil
+}
+
+func (w *outputWriter) Write(p []byte) (int, error) {
+	if !w.limit.enabled() {
+		return w.conn.Write(p)
+	}
+	written := 0
+	for {
+		deadline := time.Now().Add(outputCheckInterval)
+		if !w.deadline.IsZero() && w.deadline.Before(deadline) {
+			deadline = w.deadline
+		}
+		w.conn.SetWriteDeadline(deadline)
+		n, err := w.conn.Write(p[written:])
+		written += n
+		w.sent += int64(n)
+		if err == nil {
+			if !w.softSince.IsZero() {
+				w.check(0)
+			}
+			return written, nil
+		}
+		if netErr, ok := err.(net.Error); !ok || !netErr.Timeout() || deadline.Equal(w.deadline) {
+			return written, err
+		}
+		if err := w.check(len(p) - written); err != nil {
+			return written, err
+		}
+	}
+}
+
+// check returns errOutputBufferLimit when the pending output is over the
+// limit, unsent is what is left of the write in progress
+func (w *outputWriter) check(unsent int) error {
+	pending := int64(unsent)
+	if w.reply != nil {
+		if w.size < 0 {
+			var counter byteCounter
+			entity.Write(&counter, w.reply, w.protocol)
+			w.size = int64(counter)
+		}
+		pending = w.buffered + w.size - w.sent
+	}
+	if w.limit.hard > 0 && pending >= w.limit.hard {
+		return fmt.Errorf("%w, %d bytes pending over the hard limit", errOutputBufferLimit, pending)
+	}
+	if w.limit.soft == 0 || pending < w.limit.soft {
+		w.softSince = time.Time{}
+		return nil
+	}
+	if w.softSince.IsZero() {
+		w.softSince = time.Now()
+		return nil
+	}
+	if time.Since(w.softSince) >= w.limit.softSeconds {
+		return fmt.Errorf("%w, %d bytes pending over the soft limit for %v", errOutputBufferLimit, pending, w.limit.softSeconds)
+	}
+	return nil
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package tcp
+
+import "net"
+
+// setBacklog does nothing, the backlog is the default of the platform
+func setBacklog(listener net.Listener, backlog int) error {
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build unix
+
+package tcp
+
+import (
+	"kv_storage/logger"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// setBacklog resizes the queue of the pending connections of listener,
+// listening again on a listening socket only changes its backlog
+func setBacklog(listener net.Listener, backlog int) error {
+	tcpListener, ok := listener.(*net.TCPListener)
+	if !ok {
+		return nil
+	}
+	if data, err := os.ReadFile("/proc/sys/net/core/somaxconn"); err == nil {
+		somaxconn, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err == nil && somaxconn < backlog {
+			logger.Warning("the tcp backlog setting cannot be enforced because /proc/sys/net/core/somaxconn is set to a lower value", "tcp-backlog", backlog, "somaxconn", somaxconn)
+		}
+	}
+	rawConn, err := tcpListener.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var listenErr error
+	err = rawConn.Control(func(fd uintptr) {
+		listenErr = syscall.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return listenErr
+}
@@ -0,0 +1,216 @@
+package tcp
+
+import (
+	"errors"
+	"kv_storage/entity"
+	"kv_storage/executer"
+	"kv_storage/logger"
+	"kv_storage/parser"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	executer.RegisterCommand("monitor", 1, executer.FlagAdmin, 0, 0, 0, nil)
+}
+
+// monitorQueueLen is the number of commands queued for a monitor, the
+// commands run while its queue is full are dropped so that a slow monitor
+// never holds back the server
+const monitorQueueLen = 1024
+
+// monitorDropLogInterval limits the warnings about the dropped commands
+const monitorDropLogInterval = time.Second
+
+var errMonitorKeyspace = entity.MakeErrReply("ERR Replica can't interact with the keyspace")
+
+// monitor is the queue of the commands streamed to a client in MONITOR mode
+type monitor struct {
+	queue   chan string
+	dropped int64
+}
+
+// monitors are the clients in MONITOR mode
+type monitors struct {
+	mu  sync.RWMutex
+	set map[*monitor]struct{}
+	// count is len(set), read without locking when no client monitors
+	count int32
+}
+
+func (m *monitors) add(mon *monitor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.set == nil {
+		m.set = make(map[*monitor]struct{})
+	}
+	m.set[mon] = struct{}{}
+	atomic.StoreInt32(&m.count, int32(len(m.set)))
+}
+
+func (m *monitors) remove(mon *monitor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.set, mon)
+	atomic.StoreInt32(&m.count, int32(len(m.set)))
+}
+
+// feed queues the command args that c ran at start for every monitor, a
+// monitor whose queue is full misses it
+func (m *monitors) feed(c *connection, args [][]byte, start time.Time) {
+	if atomic.LoadInt32(&m.count) == 0 {
+		return
+	}
+	line := monitorLine(c, args, start)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for mon := range m.set {
+		select {
+		case mon.queue <- line:
+		default:
+			atomic.AddInt64(&mon.dropped, 1)
+		}
+	}
+}
+
+// monitorLine formats a command like redis does:
+// 1339518083.107412 [0 127.0.0.1:60866] "set" "key" "value"
+func monitorLine(c *connection, args [][]byte, t time.Time) string {
+	b := make([]byte, 0, 64)
+	b = strconv.AppendInt(b, t.Unix(), 10)
+	b = append(b, '.')
+	usec := strconv.Itoa(t.Nanosecond() / 1000)
+	for i := len(usec); i < 6; i++ {
+		b = append(b, '0')
+	}
+	b = append(b, usec...)
+	b = append(b, " [0 "...)
+	if c.isUnixSocket() {
+		b = append(b, "unix:"+c.conn.LocalAddr().String()...)
+	} else {
+		b = append(b, c.addr()...)
+	}
+	b = append(b, ']')
+	for _, arg := range args {
+		b = append(b, ' ')
+		b = appendRepr(b, arg)
+	}
+	return string(b)
+}
+
+// appendRepr appends s quoted, escaping the quotes, the backslashes and
+// the unprintable bytes, so that a line never breaks the protocol
+func appendRepr(b []byte, s []byte) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	for _, c := range s {
+		switch c {
+		case '\\', '"':
+			b = append(b, '\\', c)
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		case '\a':
+			b = append(b, '\\', 'a')
+		case '\b':
+			b = append(b, '\\', 'b')
+		default:
+			if c < ' ' || c > '~' {
+				b = append(b, '\\', 'x', hex[c>>4], hex[c&0xf])
+			} else {
+				b = append(b, c)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+// startMonitor turns c into a monitor, the commands are streamed once the
+// reply is sent
+func (backend *Backend) startMonitor(c *connection) entity.Reply {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.monitor == nil {
+		c.monitor = &monitor{queue: make(chan string, monitorQueueLen)}
+		backend.monitors.add(c.monitor)
+	}
+	return entity.MakeOkReply()
+}
+
+// serveMonitor streams the commands to a client in MONITOR mode until it
+// disconnects or quits. The client may still run the commands that do not
+// touch the keyspace, like a redis replica, and is never idle.
+func (backend *Backend) serveMonitor(c *connection) {
+	defer backend.monitors.remove(c.monitor)
+	c.conn.SetReadDeadline(time.Time{})
+	requests := make(chan [][]byte)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		defer close(requests)
+		for {
+			args, err := parser.ReadCommand(c.reader, backend.queryLimits)
+			if err != nil {
+				return
+			}
+			select {
+			case requests <- args:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var dropped int64
+	var droppedLogged time.Time
+	for {
+		var reply entity.Reply
+		select {
+		case line := <-c.monitor.queue:
+			reply = entity.MakeStatusReply(line)
+		case args, ok := <-requests:
+			if !ok {
+				return
+			}
+			c.beginCommand(args)
+			reply = backend.monitorExec(c, args)
+		case <-backend.done:
+			return
+		}
+		if err := c.write(reply); err != nil {
+			if errors.Is(err, errOutputBufferLimit) {
+				logger.Warning("closing client for overcoming of output buffer limits", "err", err, "client", c.info())
+			}
+			return
+		}
+		if c.closeAfterReply {
+			c.flush()
+			return
+		}
+		if len(c.monitor.queue) > 0 {
+			continue
+		}
+		if err := c.flush(); err != nil {
+			return
+		}
+		if n := atomic.LoadInt64(&c.monitor.dropped); n > dropped && time.Since(droppedLogged) >= monitorDropLogInterval {
+			logger.Warning("monitor is too slow, commands dropped", "id", c.id, "dropped", n-dropped)
+			dropped = n
+			droppedLogged = time.Now()
+		}
+	}
+}
+
+// monitorExec runs a request of a monitor, the commands reading or writing
+// the dataset are refused, with or without keys like KEYS
+func (backend *Backend) monitorExec(c *connection, args [][]byte) entity.Reply {
+	if cmd, ok := executer.LookupCommand(args[0]); ok && cmd.HasFlag(executer.FlagReadonly|executer.FlagWrite) {
+		return errMonitorKeyspace
+	}
+	return backend.exec(c, args)
+}
@@ -0,0 +1,119 @@
+package tcp
+
+import (
+	"kv_storage/entity"
+	"kv_storage/parser"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAppendRepr(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"", `""`},
+		{"value", `"value"`},
+		{`a"b\c`, `"a\"b\\c"`},
+		{"a\r\nb", `"a\r\nb"`},
+		{"\t\a\b", `"\t\a\b"`},
+		{"\x00\x1b\x7f\xff", `"\x00\x1b\x7f\xff"`},
+	}
+	for _, tt := range tests {
+		if got := string(appendRepr(nil, []byte(tt.s))); got != tt.want {
+			t.Errorf("appendRepr(%q) = %s, want %s", tt.s, got, tt.want)
+		}
+	}
+}
+
+// receive returns the next reply the server sends to c
+func (c *testClient) receive(t *testing.T) string {
+	c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reply, err := parser.ReadReply(c.reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(entity.Encode(reply, c.protocol))
+}
+
+func TestMonitor(t *testing.T) {
+	address := serveTest(t)
+	m := dial(t, address)
+	if got := m.do(t, "monitor"); got != "+OK\r\n" {
+		t.Fatalf("monitor = %q, want +OK", got)
+	}
+	c := dial(t, address)
+	c.do(t, "set", "k", "a\r\nb")
+	// the commands holding passwords and the admin ones are not streamed
+	c.do(t, "auth", "secret")
+	c.do(t, "info", "server")
+	c.do(t, "get", "k")
+	prefix := `^\+\d+\.\d{6} \[0 ` + regexp.QuoteMeta(c.conn.LocalAddr().String()) + `\] `
+	for _, want := range []string{`"set" "k" "a\\r\\nb"`, `"get" "k"`} {
+		line := m.receive(t)
+		if !regexp.MustCompile(prefix + want + "\r\n$").MatchString(line) {
+			t.Errorf("monitor line %q, want %s", line, want)
+		}
+	}
+
+	// a monitor only runs the commands that don't touch the dataset
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"get k", "-ERR Replica can't interact with the keyspace\r\n"},
+		{"set k v", "-ERR Replica can't interact with the keyspace\r\n"},
+		{"mget k", "-ERR Replica can't interact with the keyspace\r\n"},
+		{"keys *", "-ERR Replica can't interact with the keyspace\r\n"},
+		{"bogus", "-ERR unknown command 'bogus'\r\n"},
+	}
+	for _, tt := range tests {
+		if got := m.do(t, strings.Fields(tt.cmd)...); got != tt.want {
+			t.Errorf("monitor %s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+	if got := c.do(t, "get", "k"); got != "$4\r\na\r\nb\r\n" {
+		t.Errorf("get k = %q, the monitor changed it", got)
+	}
+	if line := m.receive(t); !regexp.MustCompile(prefix + `"get" "k"` + "\r\n$").MatchString(line) {
+		t.Errorf("monitor line %q, want the get of the client", line)
+	}
+}
+
+func TestMonitorsFeed(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	c := &connection{conn: conn}
+	var m monitors
+	// nothing is formatted while no client monitors
+	m.feed(c, [][]byte{[]byte("get"), []byte("k")}, time.Now())
+
+	slow := &monitor{queue: make(chan string, 2)}
+	fast := &monitor{queue: make(chan string, 8)}
+	m.add(slow)
+	m.add(fast)
+	for i := 0; i < 5; i++ {
+		m.feed(c, [][]byte{[]byte("get"), []byte("k")}, time.Now())
+	}
+	if len(slow.queue) != 2 || atomic.LoadInt64(&slow.dropped) != 3 {
+		t.Errorf("slow monitor queued %d, dropped %d, want 2 and 3", len(slow.queue), slow.dropped)
+	}
+	if len(fast.queue) != 5 || atomic.LoadInt64(&fast.dropped) != 0 {
+		t.Errorf("fast monitor queued %d, dropped %d, want 5 and 0", len(fast.queue), fast.dropped)
+	}
+	if line := <-fast.queue; !strings.HasSuffix(line, ` "get" "k"`) {
+		t.Errorf("monitor line %q", line)
+	}
+
+	m.remove(slow)
+	m.remove(fast)
+	m.feed(c, [][]byte{[]byte("get"), []byte("k")}, time.Now())
+	if len(fast.queue) != 4 || atomic.LoadInt32(&m.count) != 0 {
+		t.Errorf("removed monitor queued %d, count %d", len(fast.queue), m.count)
+	}
+}
@@ -0,0 +1,96 @@
+package tcp
+
+import (
+	"bufio"
+	"kv_storage/config"
+	"kv_storage/entity"
+	"kv_storage/parser"
+	"net"
+	"strconv"
+	"testing"
+)
+
+const pipelineKeySpace = 1000
+
+// serveTest serves an in-process backend without persistence on a loopback
+// port and returns its address
+func serveTest(tb testing.TB) string {
+	cfg := config.NewDefaultConfig()
+	cfg.DbFilename, cfg.Save = "", ""
+	backend, err := NewBackend(cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go backend.Handle(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// benchmarkPipeline sends b.N commands, alternately set and get, in batches
+// of depth commands like redis-benchmark -P and reads all the replies of a
+// batch before the next one. The allocations include the ones of the server.
+func benchmarkPipeline(b *testing.B, depth int) {
+	conn, err := net.Dial("tcp", serveTest(b))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer conn.Close()
+	var batch []byte
+	ends := make([]int, depth)
+	for i := 0; i < depth; i++ {
+		key := []byte("key:" + strconv.Itoa(i%pipelineKeySpace))
+		var cmd [][]byte
+		if i%2 == 0 {
+			cmd = [][]byte{[]byte("set"), key, []byte("xxx")}
+		} else {
+			cmd = [][]byte{[]byte("get"), key}
+		}
+		batch = append(batch, entity.MakeMultiBulkReply(cmd).ToBytes()...)
+		ends[i] = len(batch)
+	}
+	reader := bufio.NewReader(conn)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for sent := 0; sent < b.N; sent += depth {
+		n := depth
+		if b.N-sent < n {
+			n = b.N - sent
+		}
+		if _, err := conn.Write(batch[:ends[n-1]]); err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			reply, err := parser.ReadReply(reader)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if entity.IsErrorReply(reply) {
+				b.Fatalf("server replied %s", reply.ToBytes())
+			}
+		}
+	}
+}
+
+func BenchmarkPipeline1(b *testing.B) {
+	benchmarkPipeline(b, 1)
+}
+
+func BenchmarkPipeline16(b *testing.B) {
+	benchmarkPipeline(b, 16)
+}
+
+func BenchmarkPipeline128(b *testing.B) {
+	benchmarkPipeline(b, 128)
+}
@@ -0,0 +1,151 @@
+package tcp
+
+import (
+	"kv_storage/entity"
+	"kv_storage/executer"
+	"kv_storage/logger"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// shutdownMode tells whether a shutdown saves a snapshot
+type shutdownMode int
+
+const (
+	// shutdownDefault saves a snapshot when save rules are configured
+	shutdownDefault shutdownMode = iota
+	shutdownNoSave
+	shutdownSave
+)
+
+func init() {
+	executer.RegisterCommand("shutdown", -1, executer.FlagAdmin, 0, 0, 0, nil)
+}
+
+// shutdownCommand implements SHUTDOWN [NOSAVE|SAVE], nothing is replied
+// when the server shuts down
+func (backend *Backend) shutdownCommand(c *connection, args [][]byte) entity.Reply {
+	save := shutdownDefault
+	for _, arg := range args[1:] {
+		switch strings.ToLower(string(arg)) {
+		case "nosave":
+			save = shutdownNoSave
+		case "save":
+			save = shutdownSave
+		default:
+			return entity.MakeErrReply("ERR syntax error")
+		}
+	}
+	if len(args) > 2 {
+		return entity.MakeErrReply("ERR syntax error")
+	}
+	if err := backend.Shutdown(c, save); err != nil {
+		return entity.MakeErrReply("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+	return &entity.NoReply{}
+}
+
+// Shutdown stops the server within the shutdown timeout: it waits for the
+// running commands while holding back the new ones, flushes the aof to
+// disk, saves a snapshot if asked to, then stops accepting connections and
+// disconnects the clients, the held back ones are replied an error. When
+// the snapshot fails the clients resume and the server keeps running.
+// self is the client sending SHUTDOWN, nil for a signal.
+func (backend *Backend) Shutdown(self *connection, save shutdownMode) error {
+	backend.shutdownMu.Lock()
+	defer backend.shutdownMu.Unlock()
+	select {
+	case <-backend.stopped:
+		return nil
+	default:
+	}
+	logger.Info("server is shutting down", "address", backend.address)
+	deadline := time.NewTimer(backend.shutdownTimeout)
+	defer deadline.Stop()
+
+	atomic.StoreInt32(&backend.closing, 1)
+	paused := make(chan struct{})
+	go func() {
+		backend.execMu.Lock()
+		close(paused)
+	}()
+	forced := false
+	select {
+	case <-paused:
+	case <-deadline.C:
+		// the lock is taken once the stuck commands finish, it is never
+		// released as the server exits anyway
+		logger.Warning("shutdown timeout, some commands are still running", "timeout", backend.shutdownTimeout)
+		forced = true
+	}
+
+	if err := backend.aof.Sync(); err != nil {
+		logger.Error("aof sync error", "err", err)
+	}
+	if save == shutdownSave || (save == shutdownDefault && backend.saver.Enabled()) {
+		if err := backend.saver.Save(); err != nil {
+			logger.Error("error trying to save the snapshot, can't shut down", "err", err)
+			if !forced {
+				atomic.StoreInt32(&backend.closing, 0)
+				backend.execMu.Unlock()
+				return err
+			}
+		}
+	}
+	if err := backend.aof.Shutdown(); err != nil {
+		logger.Error("aof sync error", "err", err)
+	}
+
+	// closing the unix socket listener removes its socket file
+	for _, listener := range backend.listeners {
+		listener.Close()
+	}
+	logger.Info("listeners closed", "address", backend.address)
+	close(backend.done)
+	if backend.isCluster {
+		for _, peerConn := range backend.innerConns {
+			peerConn.Close()
+		}
+		for _, peerConn := range backend.innerConns3 {
+			peerConn.Close()
+		}
+	}
+	if !forced {
+		// the held back commands see closing and reply an error
+		backend.execMu.Unlock()
+	}
+	backend.disconnectClients(self, deadline.C)
+	close(backend.stopped)
+	return nil
+}
+
+// disconnectClients lets the clients send the replies they have buffered
+// and waits for them to disconnect until deadline, the remaining ones are
+// closed
+func (backend *Backend) disconnectClients(self *connection, deadline <-chan time.Time) {
+	backend.clientsMu.Lock()
+	clients := make([]*connection, 0, len(backend.clients))
+	for _, c := range backend.clients {
+		clients = append(clients, c)
+	}
+	backend.clientsMu.Unlock()
+
+	for _, c := range clients {
+		// wake up the clients waiting for a request
+		c.conn.SetReadDeadline(time.Now())
+	}
+	timeout := false
+	for _, c := range clients {
+		if c == self || timeout {
+			c.conn.Close()
+			continue
+		}
+		select {
+		case <-c.closed:
+		case <-deadline:
+			timeout = true
+			c.conn.Close()
+		}
+	}
+}
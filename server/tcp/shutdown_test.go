@@ -0,0 +1,109 @@
+package tcp
+
+import (
+	"bytes"
+	"kv_storage/aof"
+	"kv_storage/config"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveAof serves a backend persisting to an aof in dir on a loopback port
+// and returns it with its address
+func serveAof(t *testing.T, dir string) (*Backend, string) {
+	cfg := config.NewDefaultConfig()
+	cfg.DbFilename, cfg.Save = filepath.Join(dir, "dump.snap"), ""
+	cfg.AofFile = filepath.Join(dir, "cmdLog.txt")
+	backend, err := NewBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go backend.aof.Persist()
+	t.Cleanup(func() {
+		backend.Shutdown(nil, shutdownNoSave)
+		backend.aof.Close()
+	})
+	if err := backend.listen("127.0.0.1:0", nil); err != nil {
+		t.Fatal(err)
+	}
+	return backend, backend.listeners[0].Addr().String()
+}
+
+func TestShutdownSyntax(t *testing.T) {
+	_, address := serveAof(t, t.TempDir())
+	c := dial(t, address)
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"shutdown bogus", "-ERR syntax error\r\n"},
+		{"shutdown save nosave", "-ERR syntax error\r\n"},
+		{"ping", "$4\r\npong\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(t, strings.Fields(tt.cmd)...); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tests := []struct {
+		cmd      string
+		wantSnap bool
+	}{
+		{"shutdown", false},
+		{"shutdown nosave", false},
+		{"shutdown save", true},
+		{"SHUTDOWN SAVE", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			dir := t.TempDir()
+			backend, address := serveAof(t, dir)
+			c, other := dial(t, address), dial(t, address)
+			c.do(t, "set", "k", "v")
+			c.do(t, "rpush", "l", "a", "b")
+			if reply, err := c.send(strings.Fields(tt.cmd)...); err == nil {
+				t.Fatalf("%s replied %q", tt.cmd, reply)
+			}
+			select {
+			case <-backend.stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("server not stopped")
+			}
+			if !other.closed() {
+				t.Error("other client still connected")
+			}
+			if conn, err := net.Dial("tcp", address); err == nil {
+				conn.Close()
+				t.Error("listener still accepting")
+			}
+
+			// every command replied before the shutdown is in the aof
+			file, err := os.Open(filepath.Join(dir, "cmdLog.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			var got []string
+			err = aof.ReadRecords(file, func(record *aof.Record) bool {
+				got = append(got, string(bytes.Join(record.Args, []byte(" "))))
+				return true
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []string{"set k v", "rpush l a b"}; strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("aof commands %q, want %q", got, want)
+			}
+			if _, err := os.Stat(filepath.Join(dir, "dump.snap")); (err == nil) != tt.wantSnap {
+				t.Errorf("snapshot saved %v, want %v", err == nil, tt.wantSnap)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package tcp
+
+import (
+	"kv_storage/entity"
+	"kv_storage/executer"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hdt3213/godis/interface/redis"
+)
+
+func init() {
+	executer.RegisterCommand("slowlog", -2, executer.FlagAdmin, 0, 0, 0, nil)
+}
+
+const (
+	// slowlogMaxArgs is the number of arguments kept by an entry, the last
+	// one tells how many more there were
+	slowlogMaxArgs = 32
+	// slowlogMaxArgLen is the number of bytes kept of an argument
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id       int64
+	time     time.Time
+	duration time.Duration
+	args     [][]byte
+	addr     string
+	name     string
+}
+
+// slowlog keeps the newest commands that ran for slowerThan or longer
+type slowlog struct {
+	slowerThan time.Duration // negative logs nothing
+	maxLen     int
+
+	mu      sync.Mutex
+	entries []*slowlogEntry // oldest first
+	nextID  int64
+}
+
+// newSlowlog makes a slowlog of the commands of slowerThan microseconds or
+// longer keeping maxLen entries
+func newSlowlog(slowerThan int, maxLen int) *slowlog {
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	return &slowlog{
+		slowerThan: time.Duration(slowerThan) * time.Microsecond,
+		maxLen:     maxLen,
+	}
+}
+
+// add logs the command args of c when it ran long enough
+func (s *slowlog) add(c *connection, args [][]byte, duration time.Duration) {
+	if s.slowerThan < 0 || duration < s.slowerThan || s.maxLen == 0 {
+		return
+	}
+	c.mu.Lock()
+	name := c.name
+	c.mu.Unlock()
+	entry := &slowlogEntry{
+		time:     time.Now(),
+		duration: duration,
+		args:     slowlogArgs(args),
+		addr:     c.addr(),
+		name:     name,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.id = s.nextID
+	s.nextID++
+	s.entries = append(s.entries, entry)
+	if len(s.entries) > s.maxLen {
+		s.entries = s.entries[len(s.entries)-s.maxLen:]
+	}
+}
+
+// slowlogArgs copies args, truncating the long arguments and the long
+// argument lists so that an entry stays small
+func slowlogArgs(args [][]byte) [][]byte {
+	n := len(args)
+	if n > slowlogMaxArgs {
+		n = slowlogMaxArgs - 1
+	}
+	copied := make([][]byte, 0, n+1)
+	for _, arg := range args[:n] {
+		if len(arg) > slowlogMaxArgLen {
+			more := "... (" + strconv.Itoa(len(arg)-slowlogMaxArgLen) + " more bytes)"
+			copied = append(copied, append(append([]byte{}, arg[:slowlogMaxArgLen]...), more...))
+			continue
+		}
+		copied = append(copied, append([]byte{}, arg...))
+	}
+	if n < len(args) {
+		more := "... (" + strconv.Itoa(len(args)-n) + " more arguments)"
+		copied = append(copied, []byte(more))
+	}
+	return copied
+}
+
+// command implements SLOWLOG GET [count], SLOWLOG LEN and SLOWLOG RESET
+func (s *slowlog) command(args [][]byte) entity.Reply {
+	switch strings.ToLower(string(args[1])) {
+	case "get":
+		if len(args) > 3 {
+			return executer.MakeArityErr("slowlog|get")
+		}
+		count := 10
+		if len(args) == 3 {
+			n, err := strconv.Atoi(string(args[2]))
+			if err != nil || n < -1 {
+				return entity.MakeErrReply("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		return s.get(count)
+	case "len":
+		if len(args) != 2 {
+			return executer.MakeArityErr("slowlog|len")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return entity.MakeIntReply(int64(len(s.entries)))
+	case "reset":
+		if len(args) != 2 {
+			return executer.MakeArityErr("slowlog|reset")
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.entries = nil
+		return entity.MakeOkReply()
+	}
+	return entity.MakeErrReply("ERR unknown subcommand '" + executer.ErrorArg(args[1]) + "'. Try SLOWLOG GET, SLOWLOG LEN or SLOWLOG RESET.")
+}
+
+// get replies the count newest entries, newest first, all of them when count
+// is -1. An entry is its id, unix time, duration in microseconds, arguments,
+// client address and client name.
+func (s *slowlog) get(count int) entity.Reply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if count < 0 || count > len(s.entries) {
+		count = len(s.entries)
+	}
+	replies := make([]redis.Reply, 0, count)
+	for i := len(s.entries) - 1; i >= len(s.entries)-count; i-- {
+		entry := s.entries[i]
+		replies = append(replies, entity.MakeMultiRawReply([]redis.Reply{
+			entity.MakeIntReply(entry.id),
+			entity.MakeIntReply(entry.time.Unix()),
+			entity.MakeIntReply(entry.duration.Microseconds()),
+			entity.MakeMultiBulkReply(entry.args),
+			entity.MakeBulkReply([]byte(entry.addr)),
+			entity.MakeBulkReply([]byte(entry.name)),
+		}))
+	}
+	return entity.MakeMultiRawReply(replies)
+}
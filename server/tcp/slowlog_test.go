@@ -0,0 +1,122 @@
+package tcp
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/godis/lib/utils"
+)
+
+func TestSlowlogArgs(t *testing.T) {
+	long := strings.Repeat("x", slowlogMaxArgLen+10)
+	many := make([]string, slowlogMaxArgs+5)
+	for i := range many {
+		many[i] = strconv.Itoa(i)
+	}
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"short", []string{"get", "k"}, []string{"get", "k"}},
+		{"long argument", []string{"set", "k", long}, []string{"set", "k", long[:slowlogMaxArgLen] + "... (10 more bytes)"}},
+		{"as many as kept", many[:slowlogMaxArgs], many[:slowlogMaxArgs]},
+		{"too many arguments", many, append(append([]string(nil), many[:slowlogMaxArgs-1]...), "... (6 more arguments)")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := make([][]byte, len(tt.args))
+			for i, arg := range tt.args {
+				args[i] = []byte(arg)
+			}
+			got := slowlogArgs(args)
+			if len(got) != len(tt.want) {
+				t.Fatalf("%d arguments, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if string(got[i]) != tt.want[i] {
+					t.Fatalf("argument %d is %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+			// the entry does not share the buffer of the command
+			if len(args[0]) > 0 {
+				args[0][0] = '!'
+				if got[0][0] == '!' {
+					t.Fatal("arguments not copied")
+				}
+			}
+		})
+	}
+}
+
+func TestSlowlogAdd(t *testing.T) {
+	tests := []struct {
+		name       string
+		slowerThan int
+		maxLen     int
+		durations  []time.Duration
+		// want are the ids of the entries, newest first
+		want string
+	}{
+		{"every command", 0, 10, []time.Duration{0, time.Microsecond}, "*2\r\n*6\r\n:1\r\n"},
+		{"slow commands", 100, 10, []time.Duration{99 * time.Microsecond, 100 * time.Microsecond, time.Second}, "*2\r\n*6\r\n:1\r\n"},
+		{"disabled", -1, 10, []time.Duration{time.Second}, "*0\r\n"},
+		{"no entries kept", 0, 0, []time.Duration{time.Second}, "*0\r\n"},
+		{"oldest dropped", 0, 2, []time.Duration{1, 2, 3}, "*2\r\n*6\r\n:2\r\n"},
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &connection{conn: server, name: "app"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSlowlog(tt.slowerThan, tt.maxLen)
+			for _, duration := range tt.durations {
+				s.add(c, utils.ToCmdLine("get", "k"), duration)
+			}
+			got := string(s.command(utils.ToCmdLine("slowlog", "get", "-1")).ToBytes())
+			if !strings.HasPrefix(got, tt.want) {
+				t.Fatalf("slowlog get = %q, want it to start with %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlowlogCommand(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	c := &connection{conn: server, name: "app"}
+	s := newSlowlog(0, 10)
+	for i := 0; i < 3; i++ {
+		s.add(c, utils.ToCmdLine("get", "k"+strconv.Itoa(i)), time.Duration(i+1)*time.Millisecond)
+	}
+	for _, entry := range s.entries {
+		entry.time = time.Unix(1760875200, 0)
+	}
+	tests := []struct {
+		cmd  string
+		want string
+	}{
+		{"slowlog len", ":3\r\n"},
+		{"slowlog get 1", "*1\r\n*6\r\n:2\r\n:1760875200\r\n:3000\r\n*2\r\n$3\r\nget\r\n$2\r\nk2\r\n$4\r\npipe\r\n$3\r\napp\r\n"},
+		{"slowlog get 0", "*0\r\n"},
+		{"slowlog get -2", "-ERR count should be greater than or equal to -1\r\n"},
+		{"slowlog get x", "-ERR count should be greater than or equal to -1\r\n"},
+		{"slowlog get 1 2", "-ERR wrong number of arguments for 'slowlog|get' command\r\n"},
+		{"slowlog len x", "-ERR wrong number of arguments for 'slowlog|len' command\r\n"},
+		{"slowlog reset x", "-ERR wrong number of arguments for 'slowlog|reset' command\r\n"},
+		{"slowlog bogus", "-ERR unknown subcommand 'bogus'. Try SLOWLOG GET, SLOWLOG LEN or SLOWLOG RESET.\r\n"},
+		{"slowlog reset", "+OK\r\n"},
+		{"slowlog len", ":0\r\n"},
+		{"slowlog get", "*0\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(s.command(utils.ToCmdLine(strings.Fields(tt.cmd)...)).ToBytes()); got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.cmd, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"kv_storage/config"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// tlsHandshakeTimeout bounds the handshake of a tls client
+const tlsHandshakeTimeout = 10 * time.Second
+
+// newTLSConfigs returns the config of the tls listener and the one dialing
+// the peers, the certificate of the node is its client certificate too so
+// that the peers authenticate each other
+func newTLSConfigs(cfg *config.Config) (server, client *tls.Config, err error) {
+	if cfg.TlsCertFile == "" || cfg.TlsKeyFile == "" {
+		return nil, nil, errors.New("tls-cert-file and tls-key-file are required by tls")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.TlsCertFile, cfg.TlsKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	var pool *x509.CertPool
+	if cfg.TlsCaCertFile != "" {
+		pem, err := os.ReadFile(cfg.TlsCaCertFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, nil, errors.New("no certificate found in " + cfg.TlsCaCertFile)
+		}
+	}
+	server = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	switch strings.ToLower(cfg.TlsAuthClients) {
+	case "yes":
+		if pool == nil {
+			return nil, nil, errors.New("tls-auth-clients requires tls-ca-cert-file")
+		}
+		server.ClientAuth = tls.RequireAndVerifyClientCert
+	case "optional":
+		server.ClientAuth = tls.VerifyClientCertIfGiven
+	case "no":
+		server.ClientAuth = tls.NoClientCert
+	default:
+		return nil, nil, errors.New("tls-auth-clients must be yes, no or optional")
+	}
+	client = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}
+	return server, client, nil
+}
+
+// limitedConn passes the writes of a tls connection through the output
+// writer of the client, which sits under tls because a tls connection is
+// broken once a write times out
+type limitedConn struct {
+	net.Conn
+	out *outputWriter
+}
+
+func (c limitedConn) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+// SetDeadline and SetWriteDeadline keep the write deadline of tls, such as
+// the one of the close notify alert, from being replaced by the output writer
+func (c limitedConn) SetDeadline(t time.Time) error {
+	c.out.deadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c limitedConn) SetWriteDeadline(t time.Time) error {
+	c.out.deadline = t
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// handshake completes the tls handshake of c before its requests are read
+func handshake(c *connection) error {
+	tlsConn, ok := c.conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer tlsConn.SetDeadline(time.Time{})
+	return tlsConn.Handshake()
+}
@@ -0,0 +1,250 @@
+package tcp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"kv_storage/config"
+	"kv_storage/entity"
+	"kv_storage/parser"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCerts are the files of a self-signed ca and of a certificate it
+// signed for 127.0.0.1, valid to authenticate both servers and clients
+type testCerts struct {
+	caFile, certFile, keyFile string
+}
+
+func newTestCerts(t *testing.T) testCerts {
+	dir := t.TempDir()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kv_storage test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "kv_storage test node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := testCerts{
+		caFile:   filepath.Join(dir, "ca.crt"),
+		certFile: filepath.Join(dir, "node.crt"),
+		keyFile:  filepath.Join(dir, "node.key"),
+	}
+	writePEM(t, certs.caFile, "CERTIFICATE", caDER)
+	writePEM(t, certs.certFile, "CERTIFICATE", der)
+	writePEM(t, certs.keyFile, "EC PRIVATE KEY", keyDER)
+	return certs
+}
+
+func writePEM(t *testing.T, fileName, blockType string, der []byte) {
+	if err := os.WriteFile(fileName, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// config returns a server config using certs, the tls port is a
+// placeholder as the tests listen on a free port
+func (certs testCerts) config(authClients string) *config.Config {
+	cfg := config.NewDefaultConfig()
+	cfg.DbFilename, cfg.Save = "", ""
+	cfg.Bind = "127.0.0.1"
+	cfg.TlsPort = 1
+	cfg.TlsCertFile = certs.certFile
+	cfg.TlsKeyFile = certs.keyFile
+	cfg.TlsCaCertFile = certs.caFile
+	cfg.TlsAuthClients = authClients
+	return cfg
+}
+
+// serveTLS serves backend over tls on a free loopback port and returns its
+// address
+func serveTLS(t *testing.T, backend *Backend) string {
+	if err := backend.listen("127.0.0.1:0", backend.tlsConfig); err != nil {
+		t.Fatal(err)
+	}
+	listener := backend.listeners[len(backend.listeners)-1]
+	t.Cleanup(func() { listener.Close() })
+	return listener.Addr().String()
+}
+
+// pong is the reply of PING
+const pong = "$4\r\npong\r\n"
+
+// ping sends PING on conn and returns the reply
+func ping(conn net.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Write(entity.MakeMultiBulkReply([][]byte{[]byte("ping")}).ToBytes()); err != nil {
+		return "", err
+	}
+	reply, err := parser.ReadReply(bufio.NewReader(conn))
+	if err != nil {
+		return "", err
+	}
+	return string(reply.ToBytes()), nil
+}
+
+func TestTLSHandshake(t *testing.T) {
+	certs := newTestCerts(t)
+	pool := x509.NewCertPool()
+	caPEM, _ := os.ReadFile(certs.caFile)
+	pool.AppendCertsFromPEM(caPEM)
+	cert, err := tls.LoadX509KeyPair(certs.certFile, certs.keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		authClients string
+		clientCert  bool
+		wantPong    bool
+	}{
+		{"client cert required and given", "yes", true, true},
+		{"client cert required and missing", "yes", false, false},
+		{"client cert optional and missing", "optional", false, true},
+		{"client cert not asked", "no", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend, err := NewBackend(certs.config(tt.authClients))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientConfig := &tls.Config{RootCAs: pool}
+			if tt.clientCert {
+				clientConfig.Certificates = []tls.Certificate{cert}
+			}
+			conn, err := tls.Dial("tcp", serveTLS(t, backend), clientConfig)
+			if err != nil {
+				if tt.wantPong {
+					t.Fatal(err)
+				}
+				return
+			}
+			defer conn.Close()
+			// with tls 1.3 the client learns that its certificate is refused
+			// only when it reads
+			reply, err := ping(conn)
+			if tt.wantPong && (err != nil || reply != pong) {
+				t.Fatalf("ping = %q, %v, want pong", reply, err)
+			}
+			if !tt.wantPong && err == nil {
+				t.Fatalf("ping = %q, want the connection refused", reply)
+			}
+		})
+	}
+}
+
+func TestTLSPlaintextClientRefused(t *testing.T) {
+	certs := newTestCerts(t)
+	backend, err := NewBackend(certs.config("no"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", serveTLS(t, backend))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if reply, err := ping(conn); err == nil && reply == pong {
+		t.Fatal("a plaintext client was served by the tls listener")
+	}
+}
+
+func TestTLSPeers(t *testing.T) {
+	certs := newTestCerts(t)
+	peer, err := NewBackend(certs.config("yes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := serveTLS(t, peer)
+
+	cfg := certs.config("yes")
+	cfg.TlsCluster = true
+	node, err := NewBackend(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.peerTLS == nil {
+		t.Fatal("tls-cluster node dials its peers without tls")
+	}
+	conn, err := node.dialPeer(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, ok := conn.(*tls.Conn); !ok {
+		t.Fatalf("peer connection is a %T, want a tls connection", conn)
+	}
+	if reply, err := ping(conn); err != nil || reply != pong {
+		t.Fatalf("ping = %q, %v, want pong", reply, err)
+	}
+}
+
+func TestTLSConfigErrors(t *testing.T) {
+	certs := newTestCerts(t)
+	tests := []struct {
+		name   string
+		modify func(cfg *config.Config)
+	}{
+		{"missing cert", func(cfg *config.Config) { cfg.TlsCertFile = "" }},
+		{"unreadable key", func(cfg *config.Config) { cfg.TlsKeyFile = filepath.Join(t.TempDir(), "none.key") }},
+		{"ca without certificate", func(cfg *config.Config) { cfg.TlsCaCertFile = certs.keyFile }},
+		{"client auth without ca", func(cfg *config.Config) { cfg.TlsCaCertFile = "" }},
+		{"invalid tls-auth-clients", func(cfg *config.Config) { cfg.TlsAuthClients = "maybe" }},
+		{"tls-cluster without tls-port", func(cfg *config.Config) { cfg.TlsPort, cfg.TlsCluster = 0, true }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := certs.config("yes")
+			tt.modify(cfg)
+			if backend, err := NewBackend(cfg); err == nil {
+				t.Fatalf("NewBackend = %v, want an error rather than serving plaintext", backend)
+			}
+		})
+	}
+}
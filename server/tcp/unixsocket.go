@@ -0,0 +1,59 @@
+package tcp
+
+import (
+	"errors"
+	"kv_storage/logger"
+	"net"
+	"os"
+	"strconv"
+)
+
+// parseUnixSocketPerm parses the octal permissions of unixsocketperm, 0
+// keeps the ones given by the umask
+func parseUnixSocketPerm(perm string) (os.FileMode, error) {
+	if perm == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(perm, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New("invalid unixsocketperm " + perm)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenUnix accepts the clients on the unix socket path. The socket file
+// left by a server that didn't shut down is removed first, closing the
+// listener removes the file.
+func (backend *Backend) listenUnix(path string, perm os.FileMode) error {
+	if err := removeStaleSocket(path); err != nil {
+		return err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			listener.Close()
+			return err
+		}
+	}
+	backend.listeners = append(backend.listeners, listener)
+	logger.Info("server is listening", "unixsocket", path)
+	go backend.accept(listener, nil)
+	return nil
+}
+
+// removeStaleSocket removes the socket file at path unless a server still
+// accepts on it, other files are left for net.Listen to fail on
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return errors.New("unix socket " + path + " is used by another server")
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,115 @@
+//go:build unix
+
+package tcp
+
+import (
+	"bufio"
+	"kv_storage/entity"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUnixSocketPerm(t *testing.T) {
+	tests := []struct {
+		perm    string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"700", 0700, false},
+		{"0770", 0770, false},
+		{"777", 0777, false},
+		{"1000", 0, true},
+		{"800", 0, true},
+		{"rwx", 0, true},
+		{"-1", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseUnixSocketPerm(tt.perm)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("parseUnixSocketPerm(%q) = %o, %v, want %o, an error %v", tt.perm, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.sock")
+	// a server that didn't shut down left its socket file
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	backend, err := NewBackend(passwordConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.listenUnix(path, 0700); err != nil {
+		t.Fatalf("listen on a stale socket: %v", err)
+	}
+	t.Cleanup(func() { backend.Shutdown(nil, shutdownNoSave) })
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("socket permissions %o, want 700", info.Mode().Perm())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := &testClient{conn: conn, reader: bufio.NewReader(conn), protocol: entity.RESP2}
+	if got := c.do(t, "ping"); got != pong {
+		t.Errorf("ping = %q, want pong", got)
+	}
+	list := c.do(t, "client", "list")
+	for _, want := range []string{" addr=" + path + ":0 ", " laddr=" + path + ":0 ", " flags=NU "} {
+		if !strings.Contains(list, want) {
+			t.Errorf("client list %q does not contain %q", list, want)
+		}
+	}
+
+	// a second server can't take over a socket in use
+	other, err := NewBackend(passwordConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.listenUnix(path, 0); err == nil {
+		t.Error("listen on a socket in use succeeded")
+	}
+	if got := c.do(t, "ping"); got != pong {
+		t.Errorf("ping after a second listen = %q, want pong", got)
+	}
+
+	// the shutdown removes the socket file
+	backend.Shutdown(nil, shutdownNoSave)
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file after the shutdown: %v", err)
+	}
+}
+
+func TestUnixSocketNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kv.sock")
+	if err := os.WriteFile(path, []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	backend, err := NewBackend(passwordConfig(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.listenUnix(path, 0); err == nil {
+		backend.Shutdown(nil, shutdownNoSave)
+		t.Fatal("listen on a regular file succeeded")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "data" {
+		t.Errorf("regular file = %q, %v after listen, want it left as is", data, err)
+	}
+}
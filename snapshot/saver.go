@@ -0,0 +1,189 @@
+package snapshot
+
+import (
+	"errors"
+	"kv_storage/datastore"
+	"kv_storage/executer"
+	"kv_storage/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrSaveInProgress = errors.New("Background save already in progress")
+
+// SaveRule triggers a background save once Changes writes happened within Seconds
+type SaveRule struct {
+	Seconds int64
+	Changes int64
+}
+
+// ParseSaveRules parses the save option, a list of "<seconds> <changes>" pairs
+func ParseSaveRules(s string) ([]SaveRule, error) {
+	fields := strings.Fields(strings.Trim(s, "\""))
+	if len(fields)%2 != 0 {
+		return nil, errors.New("invalid save rules: " + s)
+	}
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid save rules: " + s)
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid save rules: " + s)
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+// Saver writes snapshots of the dataset on demand and according to the save rules
+type Saver struct {
+	fileName string
+	exec     *executer.Executer
+	rules    []SaveRule
+
+	mu         sync.Mutex
+	saving     bool
+	lastSave   time.Time
+	lastTry    time.Time
+	lastDirty  int64 // executer dirty counter when the last save started
+	lastStatus error
+	// lastDuration is how long the last save took
+	lastDuration time.Duration
+}
+
+func NewSaver(fileName string, rules []SaveRule, exec *executer.Executer) *Saver {
+	return &Saver{
+		fileName:  fileName,
+		exec:      exec,
+		rules:     rules,
+		lastSave:  time.Now(),
+		lastDirty: exec.Dirty(),
+	}
+}
+
+// Enabled reports whether save rules are configured, a shutdown saves a
+// snapshot only then unless it is asked to
+func (s *Saver) Enabled() bool {
+	return len(s.rules) > 0
+}
+
+// Save writes a snapshot and returns when it is on disk
+func (s *Saver) Save() error {
+	s.mu.Lock()
+	if s.saving {
+		s.mu.Unlock()
+		return ErrSaveInProgress
+	}
+	s.saving = true
+	s.lastTry = time.Now()
+	s.mu.Unlock()
+	return s.save(s.exec.Snapshot())
+}
+
+// BackgroundSave begins a copy-on-write snapshot of the dataset, which
+// blocks the clients only while the keys are listed, and writes it in a new
+// goroutine
+func (s *Saver) BackgroundSave() error {
+	s.mu.Lock()
+	if s.saving {
+		s.mu.Unlock()
+		return ErrSaveInProgress
+	}
+	s.saving = true
+	s.lastTry = time.Now()
+	s.mu.Unlock()
+	snap, dirty := s.exec.Snapshot()
+	go s.save(snap, dirty)
+	return nil
+}
+
+func (s *Saver) save(snap *datastore.CowSnapshot, dirty int64) error {
+	defer snap.Close()
+	err := WriteFile(s.fileName, snap)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.saving = false
+	s.lastStatus = err
+	s.lastDuration = time.Since(s.lastTry)
+	if err != nil {
+		logger.Error("snapshot save failed", "file", s.fileName, "err", err)
+		return err
+	}
+	s.lastSave = time.Now()
+	s.lastDirty = dirty
+	logger.Info("snapshot saved", "file", s.fileName, "keys", snap.Len(), "duration", s.lastDuration)
+	return nil
+}
+
+// LastSave returns the time of the last successful save
+func (s *Saver) LastSave() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSave
+}
+
+// Status describes the saves for INFO persistence
+type Status struct {
+	Saving bool
+	// Started is when the save in progress, or the last one, started
+	Started      time.Time
+	LastSave     time.Time
+	LastStatus   error
+	LastDuration time.Duration
+	// Changes counts the writes since the last successful save
+	Changes int64
+}
+
+func (s *Saver) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Status{
+		Saving:       s.saving,
+		Started:      s.lastTry,
+		LastSave:     s.lastSave,
+		LastStatus:   s.lastStatus,
+		LastDuration: s.lastDuration,
+		Changes:      s.exec.Dirty() - s.lastDirty,
+	}
+}
+
+// Cron checks the save rules once per second until stop is closed
+func (s *Saver) Cron(stop <-chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.needSave() {
+				s.BackgroundSave()
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *Saver) needSave() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.saving {
+		return false
+	}
+	// retry a failed save only after a few seconds, like redis does
+	if s.lastStatus != nil && time.Since(s.lastTry) < 5*time.Second {
+		return false
+	}
+	elapsed := int64(time.Since(s.lastSave) / time.Second)
+	changes := s.exec.Dirty() - s.lastDirty
+	for _, rule := range s.rules {
+		if changes >= rule.Changes && elapsed >= rule.Seconds {
+			return true
+		}
+	}
+	return false
+}
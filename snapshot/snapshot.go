@@ -0,0 +1,374 @@
+package snapshot
+
+/* 快照文件格式：
+ * magic(KVSNAP) version(1字节)
+ * 每个键一条记录：[0xFC 过期时间(int64毫秒)] 类型(1字节) 键 值
+ * 字符串编码为 uvarint长度+字节，列表为 uvarint元素个数+每个元素的字符串，
+ * 有序集合为 uvarint元素个数+每个元素的成员字符串和分数(float64)
+ * 0xFF 结束标记，最后是前面所有字节的crc32校验和
+ */
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"kv_storage/datastore"
+	"kv_storage/datastruct/sortedset"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	magic   = "KVSNAP"
+	version = 1
+
+	typeString = 0
+	typeList   = 1
+	typeZset   = 2
+
+	opExpireMs = 0xFC
+	opEOF      = 0xFF
+
+	// maxPrealloc bounds what is allocated up front for a length read from
+	// the file, a corrupt length then runs into the end of the file instead
+	// of allocating all of it
+	maxPrealloc = 1024
+)
+
+var (
+	ErrBadMagic    = errors.New("not a snapshot file")
+	ErrBadVersion  = errors.New("unsupported snapshot version")
+	ErrBadChecksum = errors.New("snapshot checksum mismatch")
+	ErrBadType     = errors.New("unknown value type in snapshot")
+)
+
+// Encoder writes entries in the snapshot format
+type Encoder struct {
+	writer *bufio.Writer
+	crc    hash.Hash32
+	buf    [binary.MaxVarintLen64]byte
+}
+
+func NewEncoder(writer io.Writer) *Encoder {
+	crc := crc32.NewIEEE()
+	return &Encoder{
+		writer: bufio.NewWriter(io.MultiWriter(writer, crc)),
+		crc:    crc,
+	}
+}
+
+func (enc *Encoder) WriteHeader() error {
+	if _, err := enc.writer.WriteString(magic); err != nil {
+		return err
+	}
+	return enc.writer.WriteByte(version)
+}
+
+func (enc *Encoder) WriteEntry(entry *datastore.Entry) error {
+	if entry.HasTTL {
+		enc.writer.WriteByte(opExpireMs)
+		binary.BigEndian.PutUint64(enc.buf[:8], uint64(entry.DeadLine.UnixMilli()))
+		enc.writer.Write(enc.buf[:8])
+	}
+	switch v := entry.Value.(type) {
+	case []byte:
+		enc.writer.WriteByte(typeString)
+		enc.writeString([]byte(entry.Key))
+		enc.writeString(v)
+	case [][]byte:
+		enc.writer.WriteByte(typeList)
+		enc.writeString([]byte(entry.Key))
+		enc.writeLength(uint64(len(v)))
+		for _, item := range v {
+			enc.writeString(item)
+		}
+	case []sortedset.Element:
+		enc.writer.WriteByte(typeZset)
+		enc.writeString([]byte(entry.Key))
+		enc.writeLength(uint64(len(v)))
+		for _, element := range v {
+			enc.writeString([]byte(element.Member))
+			binary.BigEndian.PutUint64(enc.buf[:8], math.Float64bits(element.Score))
+			enc.writer.Write(enc.buf[:8])
+		}
+	default:
+		return ErrBadType
+	}
+	return nil
+}
+
+// WriteFooter ends the snapshot and flushes it to the underlying writer
+func (enc *Encoder) WriteFooter() error {
+	enc.writer.WriteByte(opEOF)
+	if err := enc.writer.Flush(); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(enc.buf[:4], enc.crc.Sum32())
+	_, err := enc.writer.Write(enc.buf[:4])
+	if err != nil {
+		return err
+	}
+	return enc.writer.Flush()
+}
+
+func (enc *Encoder) writeLength(n uint64) {
+	size := binary.PutUvarint(enc.buf[:], n)
+	enc.writer.Write(enc.buf[:size])
+}
+
+func (enc *Encoder) writeString(s []byte) {
+	enc.writeLength(uint64(len(s)))
+	enc.writer.Write(s)
+}
+
+// Decoder reads entries in the snapshot format
+type Decoder struct {
+	reader *bufio.Reader
+	crc    hash.Hash32
+	buf    [8]byte
+}
+
+func NewDecoder(reader io.Reader) *Decoder {
+	return &Decoder{reader: bufio.NewReader(reader), crc: crc32.NewIEEE()}
+}
+
+// ReadByte implements io.ByteReader so that binary.ReadUvarint can be used,
+// every byte read goes into the checksum
+func (dec *Decoder) ReadByte() (byte, error) {
+	b, err := dec.reader.ReadByte()
+	if err == nil {
+		dec.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (dec *Decoder) readFull(p []byte) error {
+	if _, err := io.ReadFull(dec.reader, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	dec.crc.Write(p)
+	return nil
+}
+
+func (dec *Decoder) readLength() (uint64, error) {
+	n, err := binary.ReadUvarint(dec)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	n, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	// the string grows as its bytes are read
+	s := make([]byte, 0, prealloc(n))
+	for uint64(len(s)) < n {
+		chunk := n - uint64(len(s))
+		if chunk > uint64(len(s))+maxPrealloc {
+			chunk = uint64(len(s)) + maxPrealloc
+		}
+		start := len(s)
+		s = append(s, make([]byte, chunk)...)
+		if err := dec.readFull(s[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func prealloc(n uint64) int {
+	if n > maxPrealloc {
+		return maxPrealloc
+	}
+	return int(n)
+}
+
+// readOp reads the byte starting a record, the file always ends with
+// opEOF so running out of bytes means it was truncated
+func (dec *Decoder) readOp() (byte, error) {
+	op, err := dec.ReadByte()
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return op, err
+}
+
+func (dec *Decoder) readUint64() (uint64, error) {
+	if err := dec.readFull(dec.buf[:8]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(dec.buf[:8]), nil
+}
+
+func (dec *Decoder) ReadHeader() error {
+	header := make([]byte, len(magic)+1)
+	if err := dec.readFull(header); err != nil {
+		return err
+	}
+	if string(header[:len(magic)]) != magic {
+		return ErrBadMagic
+	}
+	if header[len(magic)] != version {
+		return ErrBadVersion
+	}
+	return nil
+}
+
+// ReadEntry returns the next entry, or io.EOF after the last one once the
+// checksum has been verified
+func (dec *Decoder) ReadEntry() (*datastore.Entry, error) {
+	entry := &datastore.Entry{}
+	op, err := dec.readOp()
+	if err != nil {
+		return nil, err
+	}
+	if op == opEOF {
+		sum := dec.crc.Sum32()
+		if _, err := io.ReadFull(dec.reader, dec.buf[:4]); err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if binary.BigEndian.Uint32(dec.buf[:4]) != sum {
+			return nil, ErrBadChecksum
+		}
+		return nil, io.EOF
+	}
+	if op == opExpireMs {
+		ms, err := dec.readUint64()
+		if err != nil {
+			return nil, err
+		}
+		entry.HasTTL = true
+		entry.DeadLine = time.UnixMilli(int64(ms))
+		if op, err = dec.readOp(); err != nil {
+			return nil, err
+		}
+	}
+	key, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	entry.Key = string(key)
+	switch op {
+	case typeString:
+		if entry.Value, err = dec.readString(); err != nil {
+			return nil, err
+		}
+	case typeList:
+		n, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		items := make([][]byte, 0, prealloc(n))
+		for i := uint64(0); i < n; i++ {
+			item, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		entry.Value = items
+	case typeZset:
+		n, err := dec.readLength()
+		if err != nil {
+			return nil, err
+		}
+		elements := make([]sortedset.Element, 0, prealloc(n))
+		for i := uint64(0); i < n; i++ {
+			member, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			bits, err := dec.readUint64()
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, sortedset.Element{Member: string(member), Score: math.Float64frombits(bits)})
+		}
+		entry.Value = elements
+	default:
+		return nil, ErrBadType
+	}
+	return entry, nil
+}
+
+// Write writes entries as a complete snapshot
+func Write(writer io.Writer, entries datastore.Entries) error {
+	enc := NewEncoder(writer)
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	var err error
+	entries.ForEach(func(entry *datastore.Entry) bool {
+		err = enc.WriteEntry(entry)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	return enc.WriteFooter()
+}
+
+// Read reads a complete snapshot and restores every entry that has not
+// expired yet into db
+func Read(reader io.Reader, db *datastore.Map) error {
+	dec := NewDecoder(reader)
+	if err := dec.ReadHeader(); err != nil {
+		return err
+	}
+	now := time.Now()
+	for {
+		entry, err := dec.ReadEntry()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if entry.HasTTL && !entry.DeadLine.After(now) {
+			continue
+		}
+		if err := db.Restore(entry); err != nil {
+			return err
+		}
+	}
+}
+
+// WriteFile writes the snapshot to a temporary file first and renames it
+// over fileName once it is synced, so a crash never leaves a partial snapshot
+func WriteFile(fileName string, entries datastore.Entries) error {
+	tmpName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if err = Write(file, entries); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+func ReadFile(fileName string, db *datastore.Map) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return Read(file, db)
+}
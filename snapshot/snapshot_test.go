@@ -0,0 +1,170 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"kv_storage/datastore"
+	"kv_storage/datastruct/sortedset"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// sampleEntries are entries of every type, the deadline is rounded to the
+// millisecond the snapshot keeps
+func sampleEntries() datastore.EntryList {
+	deadLine := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	return datastore.EntryList{
+		{Key: "string", Value: []byte("value")},
+		{Key: "empty", Value: []byte{}},
+		{Key: "binary", Value: []byte{0, 0xFF, '\r', '\n'}},
+		{Key: "ttl", Value: []byte("v"), HasTTL: true, DeadLine: deadLine},
+		{Key: "list", Value: [][]byte{[]byte("a"), []byte("b"), []byte("c")}},
+		{Key: "zset", Value: []sortedset.Element{{Member: "a", Score: -1.5}, {Member: "b", Score: 2}}},
+	}
+}
+
+// sorted returns the entries of db sorted by key
+func sorted(db *datastore.Map) datastore.EntryList {
+	entries := datastore.EntryList(db.Snapshot())
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries
+}
+
+func write(t *testing.T, entries datastore.Entries) []byte {
+	var buf bytes.Buffer
+	if err := Write(&buf, entries); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries datastore.EntryList
+		want    []string
+	}{
+		{"empty", nil, nil},
+		{"every type", sampleEntries(), []string{"binary", "empty", "list", "string", "ttl", "zset"}},
+		{"expired skipped", datastore.EntryList{
+			{Key: "live", Value: []byte("v")},
+			{Key: "expired", Value: []byte("v"), HasTTL: true, DeadLine: time.Now().Add(-time.Second)},
+		}, []string{"live"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := datastore.NewMap()
+			if err := Read(bytes.NewReader(write(t, tt.entries)), db); err != nil {
+				t.Fatal(err)
+			}
+			entries := sorted(db)
+			if len(entries) != len(tt.want) {
+				t.Fatalf("restored %d keys, want %v", len(entries), tt.want)
+			}
+			for i, entry := range entries {
+				if entry.Key != tt.want[i] {
+					t.Fatalf("restored %s, want %s", entry.Key, tt.want[i])
+				}
+				for _, written := range tt.entries {
+					if written.Key == entry.Key && !reflect.DeepEqual(entry, written) {
+						t.Fatalf("restored %+v, want %+v", entry, written)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	valid := write(t, sampleEntries())
+	corrupt := func(modify func(data []byte) []byte) []byte {
+		return modify(append([]byte(nil), valid...))
+	}
+	huge := uint64(1) << 62
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"bad magic", corrupt(func(data []byte) []byte { data[0] = 'X'; return data }), ErrBadMagic},
+		{"bad version", corrupt(func(data []byte) []byte { data[len(magic)] = version + 1; return data }), ErrBadVersion},
+		{"bad checksum", corrupt(func(data []byte) []byte { data[len(data)-1] ^= 1; return data }), ErrBadChecksum},
+		{"modified value", corrupt(func(data []byte) []byte { data[len(data)-6] ^= 1; return data }), ErrBadChecksum},
+		{"unknown type", append([]byte(magic), version, 9, 1, 'k'), ErrBadType},
+		{"missing checksum", corrupt(func(data []byte) []byte { return data[:len(data)-4] }), io.ErrUnexpectedEOF},
+		{"empty", nil, io.ErrUnexpectedEOF},
+		{"huge string", binary.AppendUvarint(append([]byte(magic), version, typeString, 1, 'k'), huge), io.ErrUnexpectedEOF},
+		{"huge list", binary.AppendUvarint(append([]byte(magic), version, typeList, 1, 'k'), huge), io.ErrUnexpectedEOF},
+		{"huge zset", binary.AppendUvarint(append([]byte(magic), version, typeZset, 1, 'k'), huge), io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Read(bytes.NewReader(tt.data), datastore.NewMap()); !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+	// every truncation of a valid snapshot is an error
+	for n := 0; n < len(valid); n++ {
+		if err := Read(bytes.NewReader(valid[:n]), datastore.NewMap()); err == nil {
+			t.Fatalf("snapshot truncated to %d bytes read", n)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "dump.snap")
+	db := datastore.NewMap()
+	for _, entry := range sampleEntries() {
+		if err := db.Restore(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	snap := db.BeginSnapshot()
+	defer snap.Close()
+	if err := WriteFile(fileName, snap); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fileName + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+	loaded := datastore.NewMap()
+	if err := ReadFile(fileName, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sorted(loaded), sorted(db); !reflect.DeepEqual(got, want) {
+		t.Fatalf("loaded %+v, want %+v", got, want)
+	}
+	if err := ReadFile(filepath.Join(t.TempDir(), "none"), datastore.NewMap()); !os.IsNotExist(err) {
+		t.Fatalf("got %v reading a missing file", err)
+	}
+}
+
+func TestParseSaveRules(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []SaveRule
+		wantErr bool
+	}{
+		{"", []SaveRule{}, false},
+		{`""`, []SaveRule{}, false},
+		{"3600 1", []SaveRule{{3600, 1}}, false},
+		{"3600 1 300 100 60 10000", []SaveRule{{3600, 1}, {300, 100}, {60, 10000}}, false},
+		{`"900 1"`, []SaveRule{{900, 1}}, false},
+		{"3600", nil, true},
+		{"x 1", nil, true},
+		{"1 x", nil, true},
+	}
+	for _, tt := range tests {
+		rules, err := ParseSaveRules(tt.input)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(rules, tt.want) {
+			t.Errorf("ParseSaveRules(%q) = %v, %v, want %v", tt.input, rules, err, tt.want)
+		}
+	}
+}